@@ -4,4 +4,4 @@ go 1.23
 
 require github.com/bmatcuk/doublestar/v4 v4.7.1
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"magma/internal/daemon"
+	"magma/internal/drift"
+	"magma/internal/hashing"
+	"magma/internal/metrics"
+	"magma/internal/parsing"
+	"magma/internal/policy"
+	"magma/internal/tag"
+	"magma/internal/track"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// Server answers the API requests for a magma directory
+type Server struct {
+	SnapshotsDir string
+	TrackFile    string
+	PolicyFile   string
+	UniqueTags   []string          // Tags that move from older snapshots, see config.VariableConfig.UniqueTags
+	Metrics      *metrics.Registry // Serves GET /metrics and records the snapshots taken through the API when set
+	TextfileDir  string            // node_exporter textfile collector directory the metrics are written to, if set
+
+	// Called after each snapshot written through the API, like daemon.Options.AfterSnapshot
+	AfterSnapshot func(snapshot hashing.Snapshot)
+}
+
+// Summary describes a snapshot without its tree
+type Summary struct {
+	ID       string            `json:"id"`
+	Created  time.Time         `json:"created"`
+	Hash     string            `json:"hash"`
+	Tags     []string          `json:"tags,omitempty"`
+	Notes    []hashing.Note    `json:"notes,omitempty"`
+	Approval *hashing.Approval `json:"approval,omitempty"`
+}
+
+// Finding is a drift finding as returned by the status endpoint
+type Finding struct {
+	hashing.Change
+	Severity string `json:"severity"`
+	Reason   string `json:"reason"`
+	Rule     string `json:"rule,omitempty"` // The matching rule, e.g. "immutable /etc/ssh/**"
+}
+
+// Status is the response of the status endpoint
+type Status struct {
+	Reference  string    `json:"reference"`   // The id of the snapshot the live state was compared against
+	IsBaseline bool      `json:"is_baseline"` // False if the reference is the latest snapshot, no baseline being designated
+	Worst      string    `json:"worst,omitempty"`
+	Findings   []Finding `json:"findings"`
+}
+
+// Handler returns the routes of the API:
+//
+//	GET    /v1/snapshots            list the snapshots, oldest first
+//	POST   /v1/snapshots            take a snapshot, {"tags": [...]}
+//	GET    /v1/snapshots/{ref}      get a snapshot, ?path= returns the subtree of a path
+//	GET    /v1/status               drift of the live state against the baseline
+//	GET    /v1/diff?from=&to=       changes between two snapshots, the live state when to is omitted
+//	GET    /v1/track                list the tracked paths
+//	POST   /v1/track                track a path, {"path": "..."}, 409 if it already is
+//	DELETE /v1/track?path=          stop tracking a path
+//	GET    /metrics                 Prometheus metrics, when the server has them
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/snapshots", s.listSnapshots)
+	mux.HandleFunc("POST /v1/snapshots", s.createSnapshot)
+	mux.HandleFunc("GET /v1/snapshots/{ref}", s.getSnapshot)
+	mux.HandleFunc("GET /v1/status", s.status)
+	mux.HandleFunc("GET /v1/diff", s.diff)
+	mux.HandleFunc("GET /v1/track", s.listTrack)
+	mux.HandleFunc("POST /v1/track", s.addTrack)
+	mux.HandleFunc("DELETE /v1/track", s.removeTrack)
+	if s.Metrics != nil {
+		mux.Handle("GET /metrics", s.Metrics)
+	}
+	return mux
+}
+
+func (s *Server) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := hashing.ListSnapshots(s.SnapshotsDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	summaries := []Summary{}
+	for _, snapshot := range snapshots {
+		summaries = append(summaries, Summarize(snapshot))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Tags []string `json:"tags"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	for _, t := range request.Tags {
+		if err := tag.Validate(t); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	opts := daemon.Options{
+		Tags:         request.Tags,
+		UniqueTags:   s.UniqueTags,
+		TrackFile:    s.TrackFile,
+		SnapshotsDir: s.SnapshotsDir,
+		PolicyFile:   s.PolicyFile,
+		Metrics:      s.Metrics,
+		TextfileDir:  s.TextfileDir,
+	}
+	start := time.Now()
+	snapshot, taken, err := daemon.Tick(opts)
+	daemon.Observe(opts, snapshot, taken, time.Since(start), err)
+	if err != nil && !taken {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// the snapshot is written, what failed after it is a warning
+	if err != nil {
+		w.Header().Add("X-Magma-Warning", err.Error())
+	}
+	if s.AfterSnapshot != nil {
+		s.AfterSnapshot(snapshot)
+	}
+	writeJSON(w, http.StatusCreated, Summarize(snapshot))
+}
+
+func (s *Server) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := hashing.FindSnapshot(s.SnapshotsDir, r.PathValue("ref"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+
+	node, found := findNode(snapshot.Node, filepath.Clean(path))
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not in snapshot %s", path, snapshot.ID))
+		return
+	}
+	writeJSON(w, http.StatusOK, node)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	entries, err := parsing.ReadTrackFile(s.TrackFile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rules, err := policy.ReadPolicy(s.PolicyFile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	report, err := drift.Check(s.SnapshotsDir, entries, rules)
+	if errors.Is(err, drift.ErrNoSnapshot) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := Status{Reference: report.Reference.ID, IsBaseline: report.IsBaseline, Findings: []Finding{}}
+	if worst, found := report.Worst(); found {
+		status.Worst = worst.String()
+	}
+	for _, finding := range report.Findings {
+		result := Finding{Change: finding.Change, Severity: finding.Severity.String(), Reason: finding.Reason}
+		if finding.Rule != nil {
+			result.Rule = finding.Rule.Kind + " " + finding.Rule.Pattern
+		}
+		status.Findings = append(status.Findings, result)
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// from defaults to the baseline, or the latest snapshot without one
+	var from hashing.Snapshot
+	var err error
+	if ref := query.Get("from"); ref != "" {
+		from, err = hashing.FindSnapshot(s.SnapshotsDir, ref)
+	} else {
+		from, _, err = drift.Reference(s.SnapshotsDir)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// to defaults to the live state
+	var to hashing.Snapshot
+	if ref := query.Get("to"); ref != "" {
+		to, err = hashing.FindSnapshot(s.SnapshotsDir, ref)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+	} else {
+		entries, err := parsing.ReadTrackFile(s.TrackFile)
+		if err == nil {
+			to, err = hashing.BuildSnapshot(entries)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	changes := hashing.Diff(from.Node, to.Node)
+	if changes == nil {
+		changes = []hashing.Change{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+func (s *Server) listTrack(w http.ResponseWriter, r *http.Request) {
+	paths, err := parsing.ReadMagmaFile(s.TrackFile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	writeJSON(w, http.StatusOK, paths)
+}
+
+func (s *Server) addTrack(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Path == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("expected a body like {\"path\": \"/etc/nginx\"}"))
+		return
+	}
+	if !filepath.IsAbs(request.Path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s is not an absolute path", request.Path))
+		return
+	}
+
+	warnings, err := track.AddPath(request.Path, s.TrackFile)
+	if errors.Is(err, track.ErrAlreadyTracked) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, warning := range warnings {
+		w.Header().Add("X-Magma-Warning", warning)
+	}
+	s.listTrack(w, r)
+}
+
+func (s *Server) removeTrack(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+
+	if !filepath.IsAbs(path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%q is not an absolute path", path))
+		return
+	}
+
+	err := track.RemovePath(path, s.TrackFile)
+	if errors.Is(err, track.ErrNotTracked) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.listTrack(w, r)
+}
+
+// Summarize returns the metadata of a snapshot
+func Summarize(snapshot hashing.Snapshot) Summary {
+	return Summary{
+		ID:       snapshot.ID,
+		Created:  snapshot.Created,
+		Hash:     snapshot.Hash,
+		Tags:     snapshot.Tags,
+		Notes:    snapshot.Notes,
+		Approval: snapshot.Approval,
+	}
+}
+
+// findNode returns the node of a path in a tree
+func findNode(node hashing.Node, path string) (hashing.Node, bool) {
+	if node.Path == path {
+		return node, true
+	}
+	for _, child := range node.Children {
+		if child.Path == path || hashing.IsUnder(path, child.Path) {
+			return findNode(child, path)
+		}
+	}
+	return hashing.Node{}, false
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response, {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/metrics"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newServer creates a tracked directory, a track file and a snapshots directory
+func newServer(t *testing.T) (*Server, string) {
+	root := t.TempDir()
+	tracked := filepath.Join(root, "tracked")
+	server := &Server{
+		SnapshotsDir: filepath.Join(root, "snapshots"),
+		TrackFile:    filepath.Join(root, "track"),
+		PolicyFile:   filepath.Join(root, "policy"),
+	}
+	for _, dir := range []string{tracked, filepath.Join(tracked, "sub"), server.SnapshotsDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tracked, "sub", "a.conf"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(server.TrackFile, []byte(tracked+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return server, tracked
+}
+
+// request sends a request to the handler and decodes the JSON response into out
+func request(t *testing.T, handler http.Handler, method string, target string, body any, out any) int {
+	t.Helper()
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, target, &reader))
+	if out != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, target, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestSnapshots(t *testing.T) {
+	server, tracked := newServer(t)
+	var after []string
+	server.AfterSnapshot = func(snapshot hashing.Snapshot) { after = append(after, snapshot.ID) }
+	server.Metrics = metrics.New()
+	handler := server.Handler()
+
+	var summaries []Summary
+	if code := request(t, handler, "GET", "/v1/snapshots", nil, &summaries); code != http.StatusOK || len(summaries) != 0 {
+		t.Fatalf("Expected an empty list, got %d %+v", code, summaries)
+	}
+
+	var created Summary
+	if code := request(t, handler, "POST", "/v1/snapshots", map[string][]string{"tags": {"api"}}, &created); code != http.StatusCreated {
+		t.Fatalf("Expected the snapshot to be created, got %d", code)
+	}
+	if !slices.Equal(created.Tags, []string{"api"}) {
+		t.Errorf("Expected the snapshot to be tagged api, got %v", created.Tags)
+	}
+	if !slices.Equal(after, []string{created.ID}) {
+		t.Errorf("Expected AfterSnapshot to be called with %s, got %v", created.ID, after)
+	}
+
+	// snapshots taken through the API are runs like the scheduled ones
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if body := recorder.Body.String(); !strings.Contains(body, "magma_snapshot_runs_total 1\n") || !strings.Contains(body, "magma_snapshot_success 1\n") {
+		t.Errorf("Expected the API snapshot in the metrics, got:\n%s", body)
+	}
+
+	if code := request(t, handler, "POST", "/v1/snapshots", map[string][]string{"tags": {"bad tag"}}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid tag to be rejected, got %d", code)
+	}
+
+	if code := request(t, handler, "GET", "/v1/snapshots", nil, &summaries); code != http.StatusOK || len(summaries) != 1 || summaries[0].ID != created.ID {
+		t.Fatalf("Expected the created snapshot to be listed, got %d %+v", code, summaries)
+	}
+
+	var snapshot hashing.Snapshot
+	if code := request(t, handler, "GET", "/v1/snapshots/api", nil, &snapshot); code != http.StatusOK || snapshot.ID != created.ID || len(snapshot.Children) != 1 {
+		t.Errorf("Expected the snapshot to be found by tag, got %d %+v", code, snapshot)
+	}
+
+	sub := filepath.Join(tracked, "sub")
+	var node hashing.Node
+	if code := request(t, handler, "GET", "/v1/snapshots/"+created.ID+"?path="+sub, nil, &node); code != http.StatusOK || node.Path != sub || len(node.Children) != 1 {
+		t.Errorf("Expected the subtree of %s, got %d %+v", sub, code, node)
+	}
+
+	if code := request(t, handler, "GET", "/v1/snapshots/"+created.ID+"?path=/nowhere", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected a path outside the snapshot to be not found, got %d", code)
+	}
+	if code := request(t, handler, "GET", "/v1/snapshots/missing", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected a missing snapshot to be not found, got %d", code)
+	}
+}
+
+func TestStatusAndDiff(t *testing.T) {
+	server, tracked := newServer(t)
+	handler := server.Handler()
+
+	if code := request(t, handler, "GET", "/v1/status", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected no status without a snapshot, got %d", code)
+	}
+
+	if code := request(t, handler, "POST", "/v1/snapshots", nil, nil); code != http.StatusCreated {
+		t.Fatalf("Expected the snapshot to be created, got %d", code)
+	}
+
+	file := filepath.Join(tracked, "b.conf")
+	if err := os.WriteFile(file, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var status Status
+	if code := request(t, handler, "GET", "/v1/status", nil, &status); code != http.StatusOK {
+		t.Fatalf("Expected a status, got %d", code)
+	}
+	if len(status.Findings) != 1 || status.Findings[0].Path != file || status.Worst != "warning" {
+		t.Errorf("Expected a single warning for %s, got %+v", file, status)
+	}
+
+	var changes []hashing.Change
+	if code := request(t, handler, "GET", "/v1/diff", nil, &changes); code != http.StatusOK {
+		t.Fatalf("Expected a diff, got %d", code)
+	}
+	if len(changes) != 1 || changes[0].Path != file || changes[0].Kind != hashing.Added {
+		t.Errorf("Expected %s to be added, got %+v", file, changes)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	server, tracked := newServer(t)
+	handler := server.Handler()
+	other := t.TempDir()
+
+	var paths []string
+	if code := request(t, handler, "POST", "/v1/track", map[string]string{"path": other}, &paths); code != http.StatusOK {
+		t.Fatalf("Expected the path to be tracked, got %d", code)
+	}
+	if !slices.Equal(paths, []string{tracked, other}) {
+		t.Errorf("Expected %v, got %v", []string{tracked, other}, paths)
+	}
+
+	if code := request(t, handler, "POST", "/v1/track", map[string]string{"path": other}, nil); code != http.StatusConflict {
+		t.Errorf("Expected a path tracked twice to be a conflict, got %d", code)
+	}
+
+	if code := request(t, handler, "POST", "/v1/track", map[string]string{"path": "relative"}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected a relative path to be rejected, got %d", code)
+	}
+
+	if code := request(t, handler, "DELETE", "/v1/track?path="+tracked, nil, &paths); code != http.StatusOK || !slices.Equal(paths, []string{other}) {
+		t.Errorf("Expected %s to be untracked, got %d %v", tracked, code, paths)
+	}
+	if code := request(t, handler, "DELETE", "/v1/track?path="+tracked, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected an untracked path to be not found, got %d", code)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	server, _ := newServer(t)
+	handler := RequireToken("secret", server.Handler())
+
+	for header, expected := range map[string]int{"": http.StatusUnauthorized, "Bearer wrong": http.StatusUnauthorized, "Bearer secret": http.StatusOK} {
+		r := httptest.NewRequest("GET", "/v1/track", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		if recorder.Code != expected {
+			t.Errorf("Authorization %q: expected %d, got %d", header, expected, recorder.Code)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	server, _ := newServer(t)
+	socket := filepath.Join(t.TempDir(), "magma.sock")
+
+	if err := Serve(context.Background(), server, config.APIConfig{Socket: socket, Listen: "127.0.0.1:0"}); err == nil {
+		t.Error("Expected a TCP address without a token to be refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, server, config.APIConfig{Socket: socket})
+	}()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	// wait for the socket to be created
+	var response *http.Response
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if response, err = client.Get("http://magma/v1/track"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Failed to query the API over the socket: %v", err)
+	}
+	response.Body.Close()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the socket to be only accessible to root, got %v, %v", info, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", response.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned an error: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "magma.sock")
+	umask := syscall.Umask(0)
+	defer syscall.Umask(umask)
+
+	listener, err := listenUnix(socket)
+	if err != nil {
+		t.Fatalf("listenUnix returned an error: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the socket to be created 0600 under any umask, got %v", info.Mode().Perm())
+	}
+	if current := syscall.Umask(0); current != 0 {
+		t.Errorf("Expected the umask to be left alone, got %o", current)
+	}
+
+	// the socket is reachable at its path, the private directory it was created in is gone
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Expected the socket to accept connections: %v", err)
+	}
+	conn.Close()
+	if entries, err := os.ReadDir(filepath.Dir(socket)); err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the socket next to it, got %v, %v", entries, err)
+	}
+}
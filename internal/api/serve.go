@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"magma/internal/config"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Serve serves the API on the unix socket and, if configured, on a TCP address until the context
+// is cancelled. The socket is only accessible to root, requests on the TCP address must carry the
+// configured token as "Authorization: Bearer <token>".
+//
+// Parameters:
+//   - ctx: cancelling the context shuts the listeners down.
+//   - server: the API to serve.
+//   - api: the listen addresses and the token.
+//
+// Returns:
+//   - error: an error if a listener cannot be created or fails, nil once the context is cancelled.
+func Serve(ctx context.Context, server *Server, api config.APIConfig) error {
+	if api.Listen != "" && api.Token == "" {
+		return fmt.Errorf("api.listen requires api.token to be set in %s", config.ConfigFile)
+	}
+
+	socket := api.Socket
+	if socket == "" {
+		socket = config.APISocket
+	}
+
+	// a socket left behind by a daemon that did not shut down cleanly
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+	unixListener, err := listenUnix(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	handler := server.Handler()
+	servers := []*http.Server{{Handler: handler}}
+	listeners := []net.Listener{unixListener}
+	log.Println("API listening on", socket)
+
+	if api.Listen != "" {
+		tcpListener, err := net.Listen("tcp", api.Listen)
+		if err != nil {
+			unixListener.Close()
+			return err
+		}
+		servers = append(servers, &http.Server{Handler: RequireToken(api.Token, handler)})
+		listeners = append(listeners, tcpListener)
+		log.Println("API listening on", tcpListener.Addr())
+	}
+
+	errs := make(chan error, len(servers))
+	for i := range servers {
+		go func() {
+			errs <- servers[i].Serve(listeners[i])
+		}()
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errs:
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range servers {
+		s.Shutdown(shutdown)
+	}
+
+	if errors.Is(serveErr, http.ErrServerClosed) {
+		return nil
+	}
+	return serveErr
+}
+
+// RequireToken rejects the requests that do not carry the token as a bearer token
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenUnix listens on a unix socket only root can connect to. The socket is created in a
+// private directory next to it and made 0600 before it is moved into place, so it is never
+// accessible to other users and the umask of the process is left alone.
+func listenUnix(socket string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socket), ".magma-api-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "api.sock")
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed from its final path by Serve
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(private, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(private, socket); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
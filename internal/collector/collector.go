@@ -0,0 +1,321 @@
+package collector
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"magma/internal/api"
+	"magma/internal/hashing"
+	"magma/internal/push"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoToken is returned by ListenAndServe when the collector has no token and is not insecure
+var ErrNoToken = errors.New("a token is required, set --token or $MAGMA_COLLECTOR_TOKEN, or pass --insecure to accept any device")
+
+// maxSnapshotSize bounds the size of a pushed snapshot
+const maxSnapshotSize = 256 << 20
+
+// validName restricts device and snapshot ids to names that are safe as file names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Collector receives the snapshots pushed by devices and stores them per device:
+//
+//	<dir>/<device_id>/key.pub                  public key of the device, trusted on first push
+//	<dir>/<device_id>/snapshots/<id>.json      the snapshot, as pushed
+//	<dir>/<device_id>/snapshots/<id>.json.sig  its signature
+type Collector struct {
+	Dir      string
+	Token    string // Bearer token required from the devices, none when empty
+	Insecure bool   // Lets ListenAndServe accept devices without a token
+
+	mu sync.Mutex
+}
+
+// Handler returns the routes of the collector:
+//
+//	PUT /v1/devices/{device}/snapshots/{id}   store a signed snapshot
+//	GET /v1/devices                           list the devices
+//	GET /v1/devices/{device}/snapshots        list the snapshots of a device, oldest first
+//	GET /v1/devices/{device}/snapshots/{ref}  get a snapshot of a device by id, tag or prefix
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/devices/{device}/snapshots/{id}", c.store)
+	mux.HandleFunc("GET /v1/devices", c.listDevices)
+	mux.HandleFunc("GET /v1/devices/{device}/snapshots", c.listSnapshots)
+	mux.HandleFunc("GET /v1/devices/{device}/snapshots/{ref}", c.getSnapshot)
+
+	if c.Token == "" {
+		return mux
+	}
+	return api.RequireToken(c.Token, mux)
+}
+
+// ListenAndServe serves the collector on a TCP address until the context is cancelled. Without a
+// token anyone reaching the address could push snapshots, so it refuses to start unless Insecure
+// is set.
+//
+// Parameters:
+//   - ctx: cancelling the context shuts the collector down.
+//   - addr: the address to listen on, e.g. ":7080".
+//
+// Returns:
+//   - error: an error if there is no token, or if the address cannot be listened on, nil once the
+//     context is cancelled.
+func (c *Collector) ListenAndServe(ctx context.Context, addr string) error {
+	if c.Token == "" && !c.Insecure {
+		return ErrNoToken
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: addr, Handler: c.Handler()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	log.Println("Collector listening on", addr, "storing snapshots in", c.Dir)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// DeviceSnapshotsDir returns the directory holding the snapshots of a device
+func DeviceSnapshotsDir(dir string, device string) string {
+	return filepath.Join(dir, device, "snapshots")
+}
+
+// Devices lists the devices that pushed snapshots to the collector directory, sorted.
+//
+// Parameters:
+//   - dir: the collector directory.
+//
+// Returns:
+//   - []string: the device ids.
+//   - error: an error if the directory cannot be read.
+func Devices(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && validName.MatchString(entry.Name()) {
+			devices = append(devices, entry.Name())
+		}
+	}
+	slices.Sort(devices)
+	return devices, nil
+}
+
+func (c *Collector) store(w http.ResponseWriter, r *http.Request) {
+	device, id := r.PathValue("device"), r.PathValue("id")
+	if !validName.MatchString(device) || !validName.MatchString(id) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid device or snapshot id"))
+		return
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(r.Header.Get(push.PublicKeyHeader))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing or invalid %s header", push.PublicKeyHeader))
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(push.SignatureHeader))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s header", push.SignatureHeader))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSnapshotSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxSnapshotSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("snapshot larger than %d bytes", maxSnapshotSize))
+		return
+	}
+	if !push.Verify(publicKey, device, id, body, signature) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("invalid signature"))
+		return
+	}
+	var snapshot hashing.Snapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot: %v", err))
+		return
+	}
+	// the file is named after the id of the URL, legacy snapshots have none and are named by it
+	if snapshot.ID != "" && snapshot.ID != id {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("snapshot id %s does not match %s", snapshot.ID, id))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the first key a device pushes with is the only one accepted from it afterwards
+	keyFile := filepath.Join(c.Dir, device, "key.pub")
+	encodedKey := base64.StdEncoding.EncodeToString(publicKey)
+	trusted, err := os.ReadFile(keyFile)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(DeviceSnapshotsDir(c.Dir, device), 0755); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := os.WriteFile(keyFile, []byte(encodedKey+"\n"), 0644); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Println("Trusting the key of new device", device)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	case strings.TrimSpace(string(trusted)) != encodedKey:
+		writeError(w, http.StatusForbidden, fmt.Errorf("device %s pushed with a different key before, remove %s to trust the new one", device, keyFile))
+		return
+	}
+
+	path := filepath.Join(DeviceSnapshotsDir(c.Dir, device), id+".json")
+	_, statErr := os.Stat(path)
+	if err := writeFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(signature)+"\n")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := writeFile(path, body); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if statErr == nil {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, api.Summarize(snapshot))
+}
+
+func (c *Collector) listDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := Devices(c.Dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (c *Collector) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	device := r.PathValue("device")
+	if !validName.MatchString(device) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid device id"))
+		return
+	}
+
+	snapshots, ok := readSnapshots(w, c.Dir, device)
+	if !ok {
+		return
+	}
+
+	summaries := []api.Summary{}
+	for _, snapshot := range snapshots {
+		summaries = append(summaries, api.Summarize(snapshot))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (c *Collector) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	device := r.PathValue("device")
+	if !validName.MatchString(device) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid device id"))
+		return
+	}
+
+	snapshots, ok := readSnapshots(w, c.Dir, device)
+	if !ok {
+		return
+	}
+	snapshot, err := hashing.MatchSnapshot(snapshots, r.PathValue("ref"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// readSnapshots reads the snapshots of a device, writing the error response if it fails. A file
+// that cannot be read does not hide the others, it is logged and reported in an X-Magma-Warning
+// header.
+func readSnapshots(w http.ResponseWriter, dir string, device string) ([]hashing.Snapshot, bool) {
+	snapshots, unreadable, err := hashing.ReadSnapshots(DeviceSnapshotsDir(dir, device))
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown device %s", device))
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	for _, err := range unreadable {
+		log.Println("Skipping unreadable snapshot", err)
+		w.Header().Add("X-Magma-Warning", "skipping unreadable snapshot "+err.Error())
+	}
+	return snapshots, true
+}
+
+// writeFile atomically replaces a file
+func writeFile(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error response, {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
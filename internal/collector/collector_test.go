@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"magma/internal/hashing"
+	"magma/internal/push"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDevice writes a snapshot for a device and returns the options pushing it to the url
+func newDevice(t *testing.T, url string, device string) push.Options {
+	root := t.TempDir()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := push.Options{URL: url, Token: "secret", DeviceID: device, Key: key, SnapshotsDir: filepath.Join(root, "snapshots"), StateFile: filepath.Join(root, "pushed")}
+	if err := os.Mkdir(opts.SnapshotsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := hashing.Snapshot{Node: hashing.Node{Path: "root", Hash: "aaaa"}, Created: time.Now().UTC(), Tags: []string{"nightly"}}
+	snapshot.ID = hashing.NewSnapshotID(snapshot.Created, snapshot.Hash)
+	if _, err := hashing.WriteSnapshot(opts.SnapshotsDir, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestPushToCollector(t *testing.T) {
+	collector := &Collector{Dir: t.TempDir(), Token: "secret"}
+	server := httptest.NewServer(collector.Handler())
+	defer server.Close()
+
+	for _, device := range []string{"box-1", "box-2"} {
+		opts := newDevice(t, server.URL, device)
+		if pushed, err := push.Push(opts); err != nil || pushed != 1 {
+			t.Fatalf("%s: expected one snapshot to be pushed, got %d, %v", device, pushed, err)
+		}
+	}
+
+	devices, err := Devices(collector.Dir)
+	if err != nil || !slices.Equal(devices, []string{"box-1", "box-2"}) {
+		t.Errorf("Expected both devices to be stored, got %v, %v", devices, err)
+	}
+
+	snapshot, err := hashing.FindSnapshot(DeviceSnapshotsDir(collector.Dir, "box-1"), "nightly")
+	if err != nil || snapshot.Hash != "aaaa" {
+		t.Errorf("Expected the snapshot of box-1 to be stored, got %+v, %v", snapshot, err)
+	}
+	if _, err := os.Stat(snapshot.File + ".sig"); err != nil {
+		t.Errorf("Expected the signature to be stored: %v", err)
+	}
+
+	// a device pushing with another key is rejected
+	impostor := newDevice(t, server.URL, "box-1")
+	if _, err := push.Push(impostor); err == nil {
+		t.Error("Expected a push with another key to be rejected")
+	}
+
+	// so are devices without the token
+	anonymous := newDevice(t, server.URL, "box-3")
+	anonymous.Token = ""
+	if _, err := push.Push(anonymous); err == nil {
+		t.Error("Expected a push without the token to be rejected")
+	}
+}
+
+func TestStore_InvalidSignature(t *testing.T) {
+	collector := &Collector{Dir: t.TempDir()}
+	handler := collector.Handler()
+
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"path":"root","hash":"aaaa","children":null,"created":"2024-01-31T12:00:00Z"}`)
+	mismatched := []byte(`{"path":"root","hash":"aaaa","children":null,"id":"snap-2","created":"2024-01-31T12:00:00Z"}`)
+
+	tests := []struct {
+		target    string
+		body      []byte
+		signature []byte
+		status    int
+	}{
+		{"/v1/devices/box-1/snapshots/snap-1", body, push.Sign(key, "box-1", "other", body), http.StatusForbidden},
+		{"/v1/devices/..hidden/snapshots/snap-1", body, push.Sign(key, "..hidden", "snap-1", body), http.StatusBadRequest},
+		{"/v1/devices/box-1/snapshots/snap-1", mismatched, push.Sign(key, "box-1", "snap-1", mismatched), http.StatusBadRequest},
+		{"/v1/devices/box-1/snapshots/snap-1", body, push.Sign(key, "box-1", "snap-1", body), http.StatusCreated},
+		{"/v1/devices/box-1/snapshots/snap-1", body, push.Sign(key, "box-1", "snap-1", body), http.StatusOK},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("PUT", test.target, bytes.NewReader(test.body))
+		request.Header.Set(push.PublicKeyHeader, base64.StdEncoding.EncodeToString(public))
+		request.Header.Set(push.SignatureHeader, base64.StdEncoding.EncodeToString(test.signature))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("PUT %s: expected %d, got %d %s", test.target, test.status, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestListSnapshots_Unreadable(t *testing.T) {
+	collector := &Collector{Dir: t.TempDir(), Token: "secret"}
+	server := httptest.NewServer(collector.Handler())
+	defer server.Close()
+
+	if _, err := push.Push(newDevice(t, server.URL, "box-1")); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(DeviceSnapshotsDir(collector.Dir, "box-1"), "broken.json")
+	if err := os.WriteFile(broken, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the readable snapshots are still served, the broken file is reported
+	for _, target := range []string{"/v1/devices/box-1/snapshots", "/v1/devices/box-1/snapshots/nightly"} {
+		request, _ := http.NewRequest("GET", server.URL+target, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK || !strings.Contains(response.Header.Get("X-Magma-Warning"), broken) {
+			t.Errorf("GET %s: expected 200 with a warning about %s, got %d %q", target, broken, response.StatusCode, response.Header.Get("X-Magma-Warning"))
+		}
+	}
+}
+
+func TestListenAndServe_RequiresToken(t *testing.T) {
+	collector := &Collector{Dir: t.TempDir()}
+	if err := collector.ListenAndServe(context.Background(), "127.0.0.1:0"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Expected a collector without a token to refuse to start, got %v", err)
+	}
+
+	// insecure, it starts until the context is cancelled
+	collector.Insecure = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := collector.ListenAndServe(ctx, "127.0.0.1:0"); err != nil {
+		t.Errorf("Expected an insecure collector to start, got %v", err)
+	}
+}
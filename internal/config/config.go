@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,6 +19,13 @@ const (
 	IgnoreFile   = "/etc/magma/ignore"
 	SnapshotsDir = "/etc/magma/snapshots"
 	ConfigFile   = "/etc/magma/config.yaml"
+	PolicyFile   = "/etc/magma/policy"
+	APISocket    = "/run/magma.sock"
+	SigningKey   = "/etc/magma/signing.key"
+	PushState    = "/etc/magma/pushed"
+	NotifyState  = "/etc/magma/notified"
+	HooksDir     = "/etc/magma/hooks"
+	ObjectsDir   = "/etc/magma/objects"
 )
 
 // VariableConfig holds the dynamically loaded configuration
@@ -25,7 +33,95 @@ var VariableConfig variableConfig
 
 // variableConfig defines the structure of the YAML configuration
 type variableConfig struct {
-	DeviceID string `yaml:"device_id"`
+	DeviceID   string          `yaml:"device_id"`
+	UniqueTags []string        `yaml:"unique_tags"` // Tags that move when set on another snapshot
+	Retention  RetentionConfig `yaml:"retention"`
+	Schedule   ScheduleConfig  `yaml:"schedule"`
+	API        APIConfig       `yaml:"api"`
+	Push       PushConfig      `yaml:"push"`
+	Notify     NotifyConfig    `yaml:"notify"`
+	Hooks      HooksConfig     `yaml:"hooks"`
+	Metrics    MetricsConfig   `yaml:"metrics"`
+	Mounts     MountsConfig    `yaml:"mounts"`
+}
+
+// RetentionConfig defines which snapshots 'magma prune' keeps, a zero value disables the rule
+type RetentionConfig struct {
+	KeepLast    int      `yaml:"keep_last"`    // Keep the most recent N snapshots
+	KeepDaily   int      `yaml:"keep_daily"`   // Keep the newest snapshot of each of the last N days
+	KeepWeekly  int      `yaml:"keep_weekly"`  // Keep the newest snapshot of each of the last N weeks
+	KeepMonthly int      `yaml:"keep_monthly"` // Keep the newest snapshot of each of the last N months
+	PinnedTags  []string `yaml:"pinned_tags"`  // Snapshots with any of these tags are never pruned
+}
+
+// ScheduleConfig defines when 'magma daemon' takes snapshots, either Cron or Interval must be set
+type ScheduleConfig struct {
+	Cron            string        `yaml:"cron"`              // Five field cron expression, e.g. "0 */6 * * *"
+	Interval        time.Duration `yaml:"interval"`          // Time between snapshots, e.g. "6h"
+	Jitter          time.Duration `yaml:"jitter"`            // Random delay added to each run, spreads the load of a fleet
+	SkipIfUnchanged bool          `yaml:"skip_if_unchanged"` // Do not write a snapshot identical to the latest one
+	Tags            []string      `yaml:"tags"`              // Tags of the scheduled snapshots
+}
+
+// APIConfig defines where the API served by 'magma daemon' listens
+type APIConfig struct {
+	Socket string `yaml:"socket"` // Unix socket, APISocket when empty
+	Listen string `yaml:"listen"` // Optional TCP address, e.g. 127.0.0.1:7070
+	Token  string `yaml:"token"`  // Bearer token required on the TCP address
+}
+
+// PushConfig defines the collector snapshots are pushed to
+type PushConfig struct {
+	URL           string        `yaml:"url"`            // Base URL of the collector, e.g. http://collector:7080
+	Token         string        `yaml:"token"`          // Bearer token expected by the collector, if any
+	Auto          bool          `yaml:"auto"`           // Push after every snapshot taken by 'magma daemon'
+	RetryInterval time.Duration `yaml:"retry_interval"` // Delay before retrying a failed push from the daemon
+}
+
+// NotifyConfig defines where drift is reported
+type NotifyConfig struct {
+	RateLimit time.Duration   `yaml:"rate_limit"` // Minimum delay between two notifications on a channel
+	Channels  []ChannelConfig `yaml:"channels"`
+}
+
+// ChannelConfig defines a single notification channel
+type ChannelConfig struct {
+	Name        string            `yaml:"name"`         // Identifies the channel in logs and rate limiting, defaults to its type and position
+	Type        string            `yaml:"type"`         // webhook, slack or smtp
+	MinSeverity string            `yaml:"min_severity"` // Lowest severity reported on the channel: info, warning or critical
+	RateLimit   time.Duration     `yaml:"rate_limit"`   // Overrides the rate limit of the notify section
+	URL         string            `yaml:"url"`          // Webhook and slack: where the payload is posted
+	Headers     map[string]string `yaml:"headers"`      // Webhook: extra request headers, e.g. for authentication
+	SMTP        SMTPConfig        `yaml:"smtp"`         // SMTP: the mail server and recipients
+}
+
+// SMTPConfig defines how drift is mailed
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"` // 25 when zero
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// HooksConfig defines how the pre-snap.d and post-snap.d hooks are run
+type HooksConfig struct {
+	Timeout     time.Duration `yaml:"timeout"`      // Time a hook may run before it is killed, one minute when zero
+	PreFailure  string        `yaml:"pre_failure"`  // abort (default), warn or ignore
+	PostFailure string        `yaml:"post_failure"` // warn (default), abort or ignore
+}
+
+// MetricsConfig defines where the Prometheus metrics are written for node_exporter
+type MetricsConfig struct {
+	TextfileDir string `yaml:"textfile_dir"` // The textfile collector directory of node_exporter, disabled when empty
+}
+
+// MountsConfig defines which file systems mounted under tracked paths are skipped, on top of the
+// pseudo, memory backed and network file systems skipped by default
+type MountsConfig struct {
+	SkipFSTypes []string `yaml:"skip_fs_types"` // More file system types to skip, e.g. fuse.rclone
+	HashFSTypes []string `yaml:"hash_fs_types"` // Types skipped by default that are hashed anyway, e.g. tmpfs
 }
 
 // init initializes the package by reading the configuration file
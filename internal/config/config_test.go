@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -16,6 +17,15 @@ func TestReadConfig(t *testing.T) {
 	// Write test data to the temp file
 	configData := `
 device_id: test-device-id
+retention:
+  keep_last: 5
+  keep_daily: 7
+  pinned_tags: [baseline]
+schedule:
+  cron: "0 */6 * * *"
+  jitter: 5m
+  skip_if_unchanged: true
+  tags: [scheduled]
 `
 	if _, err := tempFile.Write([]byte(configData)); err != nil {
 		t.Fatalf("Failed to write to temp file: %v", err)
@@ -35,6 +45,18 @@ device_id: test-device-id
 	if config.DeviceID != expectedDeviceID {
 		t.Errorf("Expected DeviceID %s, got %s", expectedDeviceID, config.DeviceID)
 	}
+	if config.Retention.KeepLast != 5 || config.Retention.KeepDaily != 7 || config.Retention.KeepWeekly != 0 {
+		t.Errorf("Unexpected retention config %+v", config.Retention)
+	}
+	if len(config.Retention.PinnedTags) != 1 || config.Retention.PinnedTags[0] != "baseline" {
+		t.Errorf("Expected pinned tags [baseline], got %v", config.Retention.PinnedTags)
+	}
+	if config.Schedule.Cron != "0 */6 * * *" || config.Schedule.Jitter != 5*time.Minute || !config.Schedule.SkipIfUnchanged {
+		t.Errorf("Unexpected schedule config %+v", config.Schedule)
+	}
+	if len(config.Schedule.Tags) != 1 || config.Schedule.Tags[0] != "scheduled" {
+		t.Errorf("Expected schedule tags [scheduled], got %v", config.Schedule.Tags)
+	}
 }
 
 func TestReadConfig_FileNotFound(t *testing.T) {
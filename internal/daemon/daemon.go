@@ -0,0 +1,186 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"magma/internal/drift"
+	"magma/internal/hashing"
+	"magma/internal/metrics"
+	"magma/internal/parsing"
+	"magma/internal/policy"
+	"magma/internal/schedule"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// snapshots triggered by the schedule and through the API are written one at a time, so unique
+// tags move consistently
+var mu sync.Mutex
+
+// Options configures the scheduled snapshots
+type Options struct {
+	Schedule        schedule.Schedule // When snapshots are due
+	Jitter          time.Duration     // Random delay added to each run, up to this duration
+	SkipIfUnchanged bool              // Do not write a snapshot identical to the latest one
+	Tags            []string          // Tags of the scheduled snapshots
+	UniqueTags      []string          // Tags that move from older snapshots, see config.VariableConfig.UniqueTags
+	TrackFile       string
+	SnapshotsDir    string
+	PolicyFile      string // Rules the drift reported by the metrics is evaluated against
+
+	// Records each run and its drift when set, see Observe
+	Metrics     *metrics.Registry
+	TextfileDir string // node_exporter textfile collector directory the metrics are written to, if set
+
+	// Called by Run after each snapshot written, e.g. to push it
+	AfterSnapshot func(snapshot hashing.Snapshot)
+}
+
+// Run takes a snapshot every time the schedule is due until the context is cancelled. A snapshot
+// being written when the context is cancelled is completed first. Failed snapshots are logged and
+// do not stop the daemon.
+//
+// Parameters:
+//   - ctx: cancelling the context stops the daemon, e.g. on SIGTERM.
+//   - opts: the schedule and the snapshots to take.
+//
+// Returns:
+//   - error: an error if the schedule never fires, nil once the context is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	for {
+		next := opts.Schedule.Next(time.Now())
+		if next.IsZero() {
+			return errors.New("the schedule never fires")
+		}
+		if opts.Jitter > 0 {
+			next = next.Add(rand.N(opts.Jitter))
+		}
+		log.Println("Next snapshot at", next.Local().Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Stopping")
+			return nil
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		snapshot, taken, err := Tick(opts)
+		Observe(opts, snapshot, taken, time.Since(start), err)
+		switch {
+		case taken:
+			// a written snapshot is taken, whatever failed after it
+			log.Println("Snapshot saved to", snapshot.File)
+			if err != nil {
+				log.Println("Warning:", err)
+			}
+			if opts.AfterSnapshot != nil {
+				opts.AfterSnapshot(snapshot)
+			}
+		case err != nil:
+			log.Println("Error taking scheduled snapshot:", err)
+		default:
+			log.Println("Tracked paths unchanged since", snapshot.ID+", snapshot skipped")
+		}
+	}
+}
+
+// Tick takes a single scheduled snapshot with hashing.TakeSnapshot, like snap does. The track
+// file is read on every tick so changes to it apply without restarting the daemon.
+//
+// Parameters:
+//   - opts: the snapshot to take, the schedule is not used.
+//
+// Returns:
+//   - hashing.Snapshot: the new snapshot, or the latest snapshot if it was skipped.
+//   - bool: true once the snapshot is written, even if an error follows, see hashing.TakeSnapshot.
+//   - error: an error if the track file cannot be read or the snapshot cannot be taken.
+func Tick(opts Options) (hashing.Snapshot, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	entries, err := parsing.ReadTrackFile(opts.TrackFile)
+	if err != nil {
+		return hashing.Snapshot{}, false, err
+	}
+	if len(entries) == 0 {
+		return hashing.Snapshot{}, false, fmt.Errorf("no paths to track")
+	}
+
+	return hashing.TakeSnapshot(opts.SnapshotsDir, entries, hashing.SnapshotOptions{
+		Tags:            opts.Tags,
+		UniqueTags:      opts.UniqueTags,
+		SkipIfUnchanged: opts.SkipIfUnchanged,
+	})
+}
+
+// Observe records a run in the metrics of the options, along with the drift of its snapshot
+// against the reference, and writes the textfiles. A written snapshot counts as a successful run,
+// whatever failed after it. It does nothing without metrics.
+//
+// Parameters:
+//   - opts: the metrics and the files the drift is evaluated with.
+//   - snapshot: the snapshot returned by Tick.
+//   - taken: whether Tick wrote the snapshot.
+//   - duration: how long Tick took.
+//   - err: the error returned by Tick.
+func Observe(opts Options, snapshot hashing.Snapshot, taken bool, duration time.Duration, err error) {
+	if opts.Metrics == nil {
+		return
+	}
+	if taken {
+		err = nil
+	}
+	opts.Metrics.ObserveSnapshot(snapshot, duration, err)
+
+	// the snapshot is the live state, compared to the baseline like 'magma status' would
+	if err == nil {
+		findings, err := findings(opts, snapshot, taken)
+		if err != nil {
+			log.Println("Error checking drift for the metrics:", err)
+		} else {
+			opts.Metrics.ObserveDrift(findings)
+		}
+	}
+
+	if opts.TextfileDir != "" {
+		if err := opts.Metrics.WriteTextfiles(opts.TextfileDir); err != nil {
+			log.Println("Error writing the metrics:", err)
+		}
+	}
+}
+
+// findings evaluates the changes of the live state against the baseline. Without a baseline the
+// reference is the latest snapshot written before the run: a skipped run returns that snapshot,
+// the live tree being identical to it, while a taken one is compared to the snapshot before it,
+// and the first snapshot has no findings.
+func findings(opts Options, snapshot hashing.Snapshot, taken bool) ([]policy.Finding, error) {
+	reference, isBaseline, err := drift.Reference(opts.SnapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	if !isBaseline && taken {
+		snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(snapshots, func(s hashing.Snapshot) bool { return s.ID == snapshot.ID })
+		switch {
+		case i == 0:
+			return nil, nil
+		case i > 0:
+			reference = snapshots[i-1]
+		}
+	}
+	rules, err := policy.ReadPolicy(opts.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	return policy.Evaluate(rules, hashing.Diff(reference.Node, snapshot.Node)), nil
+}
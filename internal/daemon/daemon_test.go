@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/metrics"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newOptions creates a tracked directory, a track file and a snapshots directory
+func newOptions(t *testing.T) (Options, string) {
+	root := t.TempDir()
+	tracked := filepath.Join(root, "tracked")
+	opts := Options{
+		Tags:         []string{"scheduled"},
+		TrackFile:    filepath.Join(root, "track"),
+		SnapshotsDir: filepath.Join(root, "snapshots"),
+	}
+	for _, dir := range []string{tracked, opts.SnapshotsDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opts.TrackFile, []byte(tracked+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return opts, tracked
+}
+
+func TestTick(t *testing.T) {
+	opts, tracked := newOptions(t)
+
+	snapshot, taken, err := Tick(opts)
+	if err != nil || !taken {
+		t.Fatalf("Expected a snapshot to be taken, got %v, %v", taken, err)
+	}
+	if !snapshot.HasTag("scheduled") {
+		t.Errorf("Expected the snapshot to be tagged scheduled, got %v", snapshot.Tags)
+	}
+
+	// without skip-if-unchanged, every tick writes a snapshot
+	if _, taken, err := Tick(opts); err != nil || !taken {
+		t.Fatalf("Expected a second snapshot to be taken, got %v, %v", taken, err)
+	}
+
+	opts.SkipIfUnchanged = true
+	if _, taken, err := Tick(opts); err != nil || taken {
+		t.Fatalf("Expected an unchanged snapshot to be skipped, got %v, %v", taken, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, taken, err := Tick(opts); err != nil || !taken {
+		t.Fatalf("Expected a changed snapshot to be taken, got %v, %v", taken, err)
+	}
+
+	snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first two snapshots share an id when taken within the same second
+	if len(snapshots) < 2 || snapshots[len(snapshots)-1].Hash == snapshot.Hash {
+		t.Errorf("Expected the changed snapshot to be the latest, got %+v", snapshots)
+	}
+}
+
+func TestTick_UniqueTag(t *testing.T) {
+	opts, tracked := newOptions(t)
+	opts.Tags = []string{"nightly"}
+	opts.UniqueTags = []string{"nightly"}
+
+	for _, content := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Tick(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].HasTag("nightly") || !snapshots[1].HasTag("nightly") {
+		t.Errorf("Expected the unique tag to move to the newest snapshot, got %+v", snapshots)
+	}
+}
+
+func TestTick_CorruptSnapshot(t *testing.T) {
+	opts, _ := newOptions(t)
+	opts.SkipIfUnchanged = true
+	if err := os.WriteFile(filepath.Join(opts.SnapshotsDir, "broken.json"), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, taken, err := Tick(opts); err != nil || !taken {
+		t.Fatalf("Expected a snapshot to be taken next to a corrupt file, got %v, %v", taken, err)
+	}
+	if _, taken, err := Tick(opts); err != nil || taken {
+		t.Errorf("Expected the unchanged snapshot to be skipped, got %v, %v", taken, err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	opts, tracked := newOptions(t)
+	opts.Tags = []string{"baseline"}
+	opts.PolicyFile = filepath.Join(t.TempDir(), "policy")
+	opts.Metrics = metrics.New()
+	opts.TextfileDir = t.TempDir()
+
+	if _, _, err := Tick(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scheduled snapshot drifts from the baseline
+	if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Tags = []string{"scheduled"}
+	snapshot, _, err := Tick(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Observe(opts, snapshot, true, time.Second, nil)
+
+	content, err := os.ReadFile(filepath.Join(opts.TextfileDir, metrics.DriftTextfile))
+	if err != nil {
+		t.Fatalf("Expected the drift textfile to be written: %v", err)
+	}
+	if strings.Contains(string(content), `severity="warning"} 0`) {
+		t.Errorf("Expected the modified file to be reported as drift, got:\n%s", content)
+	}
+	content, err = os.ReadFile(filepath.Join(opts.TextfileDir, metrics.SnapshotTextfile))
+	if err != nil || !strings.Contains(string(content), "magma_snapshot_files_hashed 1\n") {
+		t.Errorf("Expected the snapshot textfile to count the hashed file, got %q, %v", content, err)
+	}
+}
+
+func TestObserve_NoBaseline(t *testing.T) {
+	opts, tracked := newOptions(t)
+	opts.PolicyFile = filepath.Join(t.TempDir(), "policy")
+	opts.Metrics = metrics.New()
+	opts.TextfileDir = t.TempDir()
+
+	// the first snapshot has nothing to drift from
+	snapshot, _, err := Tick(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Observe(opts, snapshot, true, time.Second, nil)
+	content, err := os.ReadFile(filepath.Join(opts.TextfileDir, metrics.DriftTextfile))
+	if err != nil || !strings.Contains(string(content), `severity="warning"} 0`) {
+		t.Errorf("Expected no drift for the first snapshot, got %q, %v", content, err)
+	}
+
+	// without a baseline, the snapshot is compared to the one before it, not to itself
+	if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, _, err = Tick(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Observe(opts, snapshot, true, time.Second, nil)
+	content, err = os.ReadFile(filepath.Join(opts.TextfileDir, metrics.DriftTextfile))
+	if err != nil || !strings.Contains(string(content), `severity="warning"} 1`) {
+		t.Errorf("Expected the modified file to be reported as drift, got %q, %v", content, err)
+	}
+
+	// a skipped run is the live state, identical to the latest snapshot
+	opts.SkipIfUnchanged = true
+	snapshot, taken, err := Tick(opts)
+	if err != nil || taken {
+		t.Fatalf("Expected the unchanged snapshot to be skipped, got %v, %v", taken, err)
+	}
+	Observe(opts, snapshot, taken, time.Second, nil)
+	content, err = os.ReadFile(filepath.Join(opts.TextfileDir, metrics.DriftTextfile))
+	if err != nil || !strings.Contains(string(content), `severity="warning"} 0`) {
+		t.Errorf("Expected no drift for a skipped run, got %q, %v", content, err)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	units, enable, err := Units(ServiceOptions{Binary: "/usr/local/bin/magma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enable != "magma.service" || !strings.Contains(units["magma.service"], "ExecStart=/usr/local/bin/magma daemon\n") {
+		t.Errorf("Unexpected daemon unit %q: %q", enable, units)
+	}
+
+	schedule := config.ScheduleConfig{Cron: "30 2 * * *", Jitter: 10 * time.Minute}
+	units, enable, err = Units(ServiceOptions{Binary: "/usr/local/bin/magma", Timer: true, Schedule: schedule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enable != "magma-snap.timer" {
+		t.Errorf("Expected the timer to be enabled, got %q", enable)
+	}
+	for _, line := range []string{"OnCalendar=*-*-* 02:30:00", "RandomizedDelaySec=600s"} {
+		if !strings.Contains(units["magma-snap.timer"], line+"\n") {
+			t.Errorf("Expected %q in the timer, got %q", line, units["magma-snap.timer"])
+		}
+	}
+	if !strings.Contains(units["magma-snap.service"], "ExecStart=/usr/local/bin/magma daemon --once\n") {
+		t.Errorf("Unexpected oneshot service %q", units["magma-snap.service"])
+	}
+
+	units, _, err = Units(ServiceOptions{Binary: "/usr/local/bin/magma", Timer: true, Schedule: config.ScheduleConfig{Interval: 6 * time.Hour}})
+	if err != nil || !strings.Contains(units["magma-snap.timer"], "OnUnitActiveSec=21600s\n") {
+		t.Errorf("Unexpected interval timer %q, %v", units["magma-snap.timer"], err)
+	}
+
+	// systemd can't match either a day of month or a day of week
+	if _, _, err := Units(ServiceOptions{Timer: true, Schedule: config.ScheduleConfig{Cron: "0 0 1 * 1"}}); err == nil {
+		t.Error("Expected an error for a schedule systemd cannot express")
+	}
+}
+
+func TestInstallService(t *testing.T) {
+	dir := t.TempDir()
+	written, _, err := InstallService(ServiceOptions{Dir: dir, Binary: "/usr/local/bin/magma", Timer: true, Schedule: config.ScheduleConfig{Interval: time.Hour}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join(dir, "magma-snap.service"), filepath.Join(dir, "magma-snap.timer")}
+	if !slices.Equal(written, expected) {
+		t.Errorf("Expected %v, got %v", expected, written)
+	}
+	for _, path := range written {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be written: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/schedule"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SystemdDir is where systemd units installed by the administrator live
+const SystemdDir = "/etc/systemd/system"
+
+// ServiceOptions describes the systemd units to install
+type ServiceOptions struct {
+	Dir      string                // Directory the units are written to, usually SystemdDir
+	Binary   string                // Absolute path of the magma binary
+	Timer    bool                  // Install a timer running 'magma daemon --once' instead of a long running daemon
+	Schedule config.ScheduleConfig // The schedule, only used by the timer
+}
+
+// Units returns the systemd units to install, by file name. By default a single service runs
+// 'magma daemon', which reads its schedule from config.yaml. With Timer, a oneshot service takes
+// one snapshot and a timer triggers it on the configured schedule, with the jitter applied by
+// systemd.
+//
+// Parameters:
+//   - opts: the units to generate.
+//
+// Returns:
+//   - map[string]string: the content of each unit, by file name.
+//   - string: the name of the unit to enable.
+//   - error: an error if the schedule cannot be expressed as a systemd timer.
+func Units(opts ServiceOptions) (map[string]string, string, error) {
+	if !opts.Timer {
+		service := strings.Join([]string{
+			"[Unit]",
+			"Description=magma scheduled snapshots",
+			"After=local-fs.target",
+			"",
+			"[Service]",
+			"Type=simple",
+			"ExecStart=" + opts.Binary + " daemon",
+			"Restart=on-failure",
+			"# let a snapshot being written complete",
+			"KillSignal=SIGTERM",
+			"TimeoutStopSec=5min",
+			"",
+			"[Install]",
+			"WantedBy=multi-user.target",
+			"",
+		}, "\n")
+		return map[string]string{"magma.service": service}, "magma.service", nil
+	}
+
+	if _, err := schedule.FromConfig(opts.Schedule); err != nil {
+		return nil, "", err
+	}
+
+	var trigger []string
+	if opts.Schedule.Cron != "" {
+		cron, err := schedule.ParseCron(opts.Schedule.Cron)
+		if err != nil {
+			return nil, "", err
+		}
+		calendar, err := cron.OnCalendar()
+		if err != nil {
+			return nil, "", err
+		}
+		trigger = []string{"OnCalendar=" + calendar, "Persistent=true"}
+	} else {
+		interval := fmt.Sprintf("%ds", int64(opts.Schedule.Interval.Seconds()))
+		trigger = []string{"OnBootSec=" + interval, "OnUnitActiveSec=" + interval}
+	}
+	if opts.Schedule.Jitter > 0 {
+		trigger = append(trigger, fmt.Sprintf("RandomizedDelaySec=%ds", int64(opts.Schedule.Jitter.Seconds())))
+	}
+
+	service := strings.Join([]string{
+		"[Unit]",
+		"Description=magma scheduled snapshot",
+		"After=local-fs.target",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"ExecStart=" + opts.Binary + " daemon --once",
+		"",
+	}, "\n")
+
+	timer := strings.Join(slices.Concat(
+		[]string{"[Unit]", "Description=magma scheduled snapshots", "", "[Timer]"},
+		trigger,
+		[]string{"", "[Install]", "WantedBy=timers.target", ""},
+	), "\n")
+
+	return map[string]string{"magma-snap.service": service, "magma-snap.timer": timer}, "magma-snap.timer", nil
+}
+
+// InstallService writes the systemd units to the units directory, see Units.
+//
+// Parameters:
+//   - opts: the units to install.
+//
+// Returns:
+//   - []string: the paths of the written units, sorted.
+//   - string: the name of the unit to enable.
+//   - error: an error if the units cannot be generated or written.
+func InstallService(opts ServiceOptions) ([]string, string, error) {
+	units, enable, err := Units(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var written []string
+	for name, content := range units {
+		path := filepath.Join(opts.Dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return written, "", err
+		}
+		written = append(written, path)
+	}
+	slices.Sort(written)
+	return written, enable, nil
+}
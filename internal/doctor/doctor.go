@@ -0,0 +1,376 @@
+package doctor
+
+import (
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/hooks"
+	"magma/internal/ignore"
+	"magma/internal/parsing"
+	"magma/internal/policy"
+	"magma/internal/track"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Status is the outcome of a single check
+type Status int
+
+const (
+	OK Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Warn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// Result is the outcome of a single environment check, with a suggested fix when it did not pass.
+type Result struct {
+	Name    string // Short name of the check
+	Status  Status // Outcome of the check
+	Message string // What was found
+	Fix     string // How to fix it, empty when the check passed
+}
+
+// Options describes the environment to check, DefaultOptions points at the standard /etc/magma layout.
+type Options struct {
+	AppRoot      string
+	TrackFile    string
+	IgnoreFile   string
+	ConfigFile   string
+	PolicyFile   string
+	SnapshotsDir string
+	Now          time.Time // Current time, used for the clock check
+}
+
+// DefaultOptions returns the options pointing at the standard /etc/magma layout.
+func DefaultOptions() Options {
+	return Options{
+		AppRoot:      config.AppRoot,
+		TrackFile:    config.TrackFile,
+		IgnoreFile:   config.IgnoreFile,
+		ConfigFile:   config.ConfigFile,
+		PolicyFile:   config.PolicyFile,
+		SnapshotsDir: config.SnapshotsDir,
+		Now:          time.Now(),
+	}
+}
+
+const (
+	// below this amount of free space in the snapshots directory the check fails
+	minFreeBytes = 100 << 20
+	// below this amount of free space the check warns
+	lowFreeBytes = 1 << 30
+	// snapshots can't have been taken before magma existed, an earlier clock is not set
+	earliestSaneYear = 2024
+)
+
+// Run performs every environment check and returns their results in a fixed order.
+//
+// Parameters:
+//   - opts: the environment to check.
+//
+// Returns:
+//   - []Result: the result of every check.
+func Run(opts Options) []Result {
+	var results []Result
+	results = append(results, checkPrivileges(opts))
+	results = append(results, checkConfig(opts))
+	results = append(results, checkTrack(opts)...)
+	results = append(results, checkIgnore(opts)...)
+	results = append(results, checkPolicy(opts))
+	results = append(results, checkDiskSpace(opts))
+	results = append(results, checkClock(opts))
+	return results
+}
+
+// checkPrivileges verifies magma runs with enough permissions to read tracked files and write snapshots
+func checkPrivileges(opts Options) Result {
+	result := Result{Name: "privileges"}
+
+	if os.Geteuid() == 0 {
+		result.Message = "running as root"
+		return result
+	}
+
+	// W_OK, the syscall package does not export the access mode constants
+	const writable = 0x2
+	if err := syscall.Access(opts.SnapshotsDir, writable); err != nil {
+		result.Status = Fail
+		result.Message = fmt.Sprintf("cannot write to %s: %v", opts.SnapshotsDir, err)
+		result.Fix = "run magma as root, e.g. 'sudo magma snap'"
+		return result
+	}
+
+	result.Status = Warn
+	result.Message = fmt.Sprintf("running as uid %d, files not readable by this user will fail to hash", os.Geteuid())
+	result.Fix = "run magma as root, e.g. 'sudo magma snap'"
+	return result
+}
+
+// checkConfig verifies the config file exists, parses and has a device id
+func checkConfig(opts Options) Result {
+	result := Result{Name: "config"}
+
+	variableConfig, err := config.ReadConfig(opts.ConfigFile)
+	if os.IsNotExist(err) {
+		result.Status = Fail
+		result.Message = opts.ConfigFile + " does not exist"
+		result.Fix = "run 'magma init'"
+		return result
+	}
+	if err != nil {
+		result.Status = Fail
+		result.Message = fmt.Sprintf("%s is not valid YAML: %v", opts.ConfigFile, err)
+		result.Fix = "fix the syntax error in " + opts.ConfigFile
+		return result
+	}
+
+	for _, policy := range []string{variableConfig.Hooks.PreFailure, variableConfig.Hooks.PostFailure} {
+		if err := hooks.ValidatePolicy(policy); err != nil {
+			result.Status = Fail
+			result.Message = err.Error()
+			result.Fix = "set hooks.pre_failure and hooks.post_failure to abort, warn or ignore in " + opts.ConfigFile
+			return result
+		}
+	}
+
+	if variableConfig.DeviceID == "" {
+		result.Status = Warn
+		result.Message = "device_id is empty"
+		result.Fix = "set device_id in " + opts.ConfigFile
+		return result
+	}
+
+	result.Message = "device_id is " + variableConfig.DeviceID
+	return result
+}
+
+// checkTrack verifies the track file exists and that every tracked path still exists
+func checkTrack(opts Options) []Result {
+	entries, err := parsing.ReadTrackFile(opts.TrackFile)
+	if err != nil {
+		return []Result{{
+			Name:    "track file",
+			Status:  Fail,
+			Message: fmt.Sprintf("cannot read %s: %v", opts.TrackFile, err),
+			Fix:     "run 'magma init' to create it, or fix the invalid line",
+		}}
+	}
+
+	paths := parsing.Paths(entries)
+	if len(paths) == 0 {
+		return []Result{{
+			Name:    "track file",
+			Status:  Warn,
+			Message: "no paths are tracked",
+			Fix:     "add a path with 'magma track <path>'",
+		}}
+	}
+
+	// invalid patterns are reported by the ignore check
+	rules, _ := ignore.ReadFile(opts.IgnoreFile)
+
+	results := []Result{{Name: "track file", Message: fmt.Sprintf("%d path(s) tracked", len(paths))}}
+	scopes := hashing.NewIgnoreScopes()
+	for i, path := range paths {
+		result := Result{Name: "tracked path", Message: path + " exists"}
+
+		if (parsing.TrackEntry{Path: path}).IsPattern() {
+			results = append(results, checkPattern(path, scopes))
+			continue
+		}
+
+		if !filepath.IsAbs(path) {
+			result.Status = Warn
+			result.Message = path + " is relative and depends on the directory magma runs from"
+			result.Fix = fmt.Sprintf("untrack it and track the absolute path with 'magma untrack %s'", path)
+		} else if _, err := os.Lstat(path); err != nil {
+			result.Status = Fail
+			result.Message = fmt.Sprintf("%s: %v", path, err)
+			result.Fix = fmt.Sprintf("restore the path or stop tracking it with 'magma untrack %s'", path)
+		} else if clean := filepath.Clean(path); clean != path {
+			result.Status = Warn
+			result.Message = fmt.Sprintf("%s is not in canonical form, snapshots record it as %s", path, clean)
+			result.Fix = fmt.Sprintf("untrack it with 'magma untrack %s' and track it again", path)
+		} else if outer := outerEntry(entries, i); outer != "" {
+			result.Status = Warn
+			result.Message = fmt.Sprintf("%s is inside %s and hashed twice", path, outer)
+			result.Fix = fmt.Sprintf("stop tracking it with 'magma untrack %s' unless its options differ", path)
+		} else if info, err := os.Stat(path); err == nil && ignoredRoot(rules, path, info.IsDir()) {
+			result.Status = Warn
+			result.Message = path + " is ignored by the ignore list, snapshots skip it"
+			result.Fix = fmt.Sprintf("remove the matching pattern from %s or stop tracking it", opts.IgnoreFile)
+		}
+		results = append(results, result)
+	}
+
+	// .magmaignore files the patterns could not be expanded with
+	for _, warning := range scopes.Warnings() {
+		results = append(results, Result{
+			Name:    "ignore file",
+			Status:  Warn,
+			Message: warning,
+			Fix:     "fix its invalid patterns, and make sure it is a regular file owned by root and not writable by others",
+		})
+	}
+
+	return results
+}
+
+// outerEntry returns the path of another entry already tracking the path of entries[i], if any
+func outerEntry(entries []parsing.TrackEntry, i int) string {
+	for j, entry := range entries {
+		if j != i && filepath.Clean(entry.Path) != filepath.Clean(entries[i].Path) && track.Overlaps(entry, entries[i]) {
+			return entry.Path
+		}
+	}
+	return ""
+}
+
+// checkPattern verifies a tracked pattern is valid and matches something
+func checkPattern(pattern string, scopes *hashing.IgnoreScopes) Result {
+	matches, err := scopes.Expand(pattern)
+	switch {
+	case err != nil || !filepath.IsAbs(pattern):
+		return Result{
+			Name:    "tracked path",
+			Status:  Fail,
+			Message: pattern + " is not a valid absolute pattern",
+			Fix:     fmt.Sprintf("untrack it with 'magma untrack %s' and track a valid pattern", pattern),
+		}
+	case len(matches) == 0:
+		if rule, _ := scopes.IgnoredBy(pattern); rule != nil {
+			return Result{
+				Name:    "tracked path",
+				Status:  Warn,
+				Message: fmt.Sprintf("every path %s matches is ignored by %s", pattern, rule),
+				Fix:     fmt.Sprintf("re-include them with a !%s line after that rule, or stop tracking the pattern", pattern),
+			}
+		}
+		return Result{
+			Name:    "tracked path",
+			Status:  Warn,
+			Message: pattern + " matches nothing",
+			Fix:     fmt.Sprintf("check the pattern or stop tracking it with 'magma untrack %s'", pattern),
+		}
+	}
+	return Result{Name: "tracked path", Message: fmt.Sprintf("%s matches %d path(s)", pattern, len(matches))}
+}
+
+// checkIgnore verifies the ignore file exists and that every pattern is valid
+func checkIgnore(opts Options) []Result {
+	patterns, err := parsing.ReadMagmaFile(opts.IgnoreFile)
+	if err != nil {
+		return []Result{{
+			Name:    "ignore file",
+			Status:  Fail,
+			Message: fmt.Sprintf("cannot read %s: %v", opts.IgnoreFile, err),
+			Fix:     "run 'magma init' to recreate it with the default patterns",
+		}}
+	}
+
+	var results []Result
+	for _, pattern := range patterns {
+		if _, _, err := ignore.Parse("/", pattern); err != nil {
+			results = append(results, Result{
+				Name:    "ignore pattern",
+				Status:  Fail,
+				Message: err.Error() + " and never matches",
+				Fix:     "fix or remove the pattern in " + opts.IgnoreFile,
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, Result{Name: "ignore file", Message: fmt.Sprintf("%d valid pattern(s)", len(patterns))})
+	}
+	return results
+}
+
+// checkPolicy verifies the policy file, if any, only holds valid rules
+func checkPolicy(opts Options) Result {
+	rules, err := policy.ReadPolicy(opts.PolicyFile)
+	if err != nil {
+		return Result{
+			Name:    "policy file",
+			Status:  Fail,
+			Message: err.Error(),
+			Fix:     "fix or remove the line in " + opts.PolicyFile,
+		}
+	}
+	return Result{Name: "policy file", Message: fmt.Sprintf("%d valid rule(s)", len(rules))}
+}
+
+// checkDiskSpace verifies there is room left for new snapshots
+func checkDiskSpace(opts Options) Result {
+	result := Result{Name: "disk space"}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(opts.SnapshotsDir, &stat); err != nil {
+		result.Status = Fail
+		result.Message = fmt.Sprintf("cannot stat %s: %v", opts.SnapshotsDir, err)
+		result.Fix = "run 'magma init' to create the snapshots directory"
+		return result
+	}
+
+	free := stat.Bavail * uint64(stat.Bsize)
+	result.Message = fmt.Sprintf("%d MiB free under %s", free>>20, opts.SnapshotsDir)
+
+	switch {
+	case free < minFreeBytes:
+		result.Status = Fail
+		result.Fix = "free up disk space or remove old snapshots"
+	case free < lowFreeBytes:
+		result.Status = Warn
+		result.Fix = "free up disk space or remove old snapshots"
+	}
+	return result
+}
+
+// checkClock verifies the system clock is set and has not gone back in time since the last snapshot
+func checkClock(opts Options) Result {
+	result := Result{Name: "clock", Message: "current time is " + opts.Now.Format(time.RFC3339)}
+
+	if opts.Now.Year() < earliestSaneYear {
+		result.Status = Fail
+		result.Fix = "set the system clock, e.g. enable NTP with 'timedatectl set-ntp true'"
+		return result
+	}
+
+	// snapshot files should never be newer than the current time
+	entries, err := os.ReadDir(opts.SnapshotsDir)
+	if err != nil {
+		return result
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(opts.Now.Add(time.Minute)) {
+			result.Status = Warn
+			result.Message = fmt.Sprintf("snapshot %s is dated %s, in the future", entry.Name(), info.ModTime().Format(time.RFC3339))
+			result.Fix = "check the system clock, e.g. enable NTP with 'timedatectl set-ntp true'"
+			return result
+		}
+	}
+	return result
+}
+
+// ignoredRoot reports whether the rules ignore a tracked path, the directories above it aside
+func ignoredRoot(rules *ignore.Matcher, path string, isDir bool) bool {
+	_, ignored := rules.ExplainUnder(path, path, isDir)
+	return ignored
+}
@@ -0,0 +1,154 @@
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newOptions creates a temporary magma directory layout and returns the options pointing at it
+func newOptions(t *testing.T) Options {
+	root := t.TempDir()
+	opts := Options{
+		AppRoot:      root,
+		TrackFile:    filepath.Join(root, "track"),
+		IgnoreFile:   filepath.Join(root, "ignore"),
+		ConfigFile:   filepath.Join(root, "config.yaml"),
+		PolicyFile:   filepath.Join(root, "policy"),
+		SnapshotsDir: filepath.Join(root, "snapshots"),
+		Now:          time.Now(),
+	}
+	if err := os.Mkdir(opts.SnapshotsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestCheckConfig(t *testing.T) {
+	opts := newOptions(t)
+
+	// missing config file
+	if result := checkConfig(opts); result.Status != Fail || result.Fix == "" {
+		t.Errorf("Expected a failure with a fix for a missing config, got %+v", result)
+	}
+
+	// empty device id
+	if err := os.WriteFile(opts.ConfigFile, []byte("device_id: \"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkConfig(opts); result.Status != Warn {
+		t.Errorf("Expected a warning for an empty device id, got %+v", result)
+	}
+
+	// unknown hook failure policy
+	if err := os.WriteFile(opts.ConfigFile, []byte("device_id: box-1\nhooks:\n  pre_failure: retry\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkConfig(opts); result.Status != Fail {
+		t.Errorf("Expected a failure for an unknown hook failure policy, got %+v", result)
+	}
+
+	if err := os.WriteFile(opts.ConfigFile, []byte("device_id: box-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkConfig(opts); result.Status != OK {
+		t.Errorf("Expected the config check to pass, got %+v", result)
+	}
+}
+
+func TestCheckTrack(t *testing.T) {
+	opts := newOptions(t)
+	existing := t.TempDir()
+	if err := os.WriteFile(filepath.Join(existing, "a.conf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := t.TempDir()
+	for _, dir := range []string{filepath.Join(existing, "sub"), filepath.Join(other, "cache")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(opts.IgnoreFile, []byte("cache/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := existing + "\n/non/existent/path\nrelative/path\n" + existing + "/*.conf\n" + existing + "/*.log\n" +
+		existing + "/sub\n" + other + "/cache\n" + t.TempDir() + "/\n"
+	if err := os.WriteFile(opts.TrackFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := checkTrack(opts)
+	if len(results) != 9 {
+		t.Fatalf("Expected 9 results, got %d: %+v", len(results), results)
+	}
+
+	// the overlapping, ignored and unclean paths are warnings
+	expected := []Status{OK, OK, Fail, Warn, OK, Warn, Warn, Warn, Warn}
+	for i, result := range results {
+		if result.Status != expected[i] {
+			t.Errorf("Result %d: expected status %s, got %+v", i, expected[i], result)
+		}
+	}
+	for i, reason := range map[int]string{6: "inside", 7: "ignored", 8: "canonical"} {
+		if !strings.Contains(results[i].Message, reason) {
+			t.Errorf("Result %d: expected %q in %q", i, reason, results[i].Message)
+		}
+	}
+}
+
+func TestCheckIgnore(t *testing.T) {
+	opts := newOptions(t)
+	if err := os.WriteFile(opts.IgnoreFile, []byte("**/*.log\n/etc/[abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := checkIgnore(opts)
+	if len(results) != 1 || results[0].Status != Fail {
+		t.Fatalf("Expected a single failure for the invalid pattern, got %+v", results)
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	opts := newOptions(t)
+
+	// no policy file is fine
+	if result := checkPolicy(opts); result.Status != OK {
+		t.Errorf("Expected a missing policy to pass, got %+v", result)
+	}
+
+	if err := os.WriteFile(opts.PolicyFile, []byte("immutable /etc/ssh/**\nfrozen /etc/hosts\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkPolicy(opts); result.Status != Fail {
+		t.Errorf("Expected an invalid rule to fail, got %+v", result)
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	opts := newOptions(t)
+
+	opts.Now = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	if result := checkClock(opts); result.Status != Fail {
+		t.Errorf("Expected an unset clock to fail, got %+v", result)
+	}
+
+	// a snapshot written "after" the current time means the clock went backwards
+	opts.Now = time.Now().Add(-24 * time.Hour)
+	if err := os.WriteFile(filepath.Join(opts.SnapshotsDir, "abcd1234.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkClock(opts); result.Status != Warn {
+		t.Errorf("Expected a snapshot from the future to warn, got %+v", result)
+	}
+}
+
+func TestCheckDiskSpace_MissingDirectory(t *testing.T) {
+	opts := newOptions(t)
+	opts.SnapshotsDir = filepath.Join(opts.AppRoot, "missing")
+
+	if result := checkDiskSpace(opts); result.Status != Fail {
+		t.Errorf("Expected a missing snapshots directory to fail, got %+v", result)
+	}
+}
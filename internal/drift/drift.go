@@ -0,0 +1,174 @@
+package drift
+
+import (
+	"errors"
+	"fmt"
+	"magma/internal/hashing"
+	"magma/internal/parsing"
+	"magma/internal/policy"
+	"magma/internal/tag"
+	"path/filepath"
+)
+
+// BaselineTag is the tag of the approved snapshot that tracked paths are compared against
+const BaselineTag = "baseline"
+
+// ErrNoSnapshot is returned when there is no snapshot to compare the tracked paths against
+var ErrNoSnapshot = errors.New("there is no snapshot to compare against, run 'magma snap' first")
+
+// Report is the result of comparing the tracked paths against a reference snapshot
+type Report struct {
+	Reference  hashing.Snapshot // The snapshot the live state was compared against
+	IsBaseline bool             // True if the reference is the approved baseline, false if it is the latest snapshot
+	Live       hashing.Snapshot // The live state of the tracked paths, not written to disk
+	Changes    []hashing.Change // The differences between the reference and the live state
+	Findings   []policy.Finding // The changes evaluated against the policy
+}
+
+// Worst returns the highest severity among the findings, and false if there is no finding
+func (r Report) Worst() (policy.Severity, bool) {
+	if len(r.Findings) == 0 {
+		return policy.Info, false
+	}
+	worst := policy.Info
+	for _, finding := range r.Findings {
+		worst = max(worst, finding.Severity)
+	}
+	return worst, true
+}
+
+// Reference returns the snapshot tracked paths are compared against: the baseline if one has
+// been designated, otherwise the most recent snapshot.
+//
+// Parameters:
+//   - snapshotsDir: the snapshots directory.
+//
+// Returns:
+//   - hashing.Snapshot: the reference snapshot.
+//   - bool: true if the reference is the baseline.
+//   - error: an error if there is no snapshot at all or the snapshots cannot be read.
+func Reference(snapshotsDir string) (hashing.Snapshot, bool, error) {
+	snapshots, err := hashing.ListSnapshots(snapshotsDir)
+	if err != nil {
+		return hashing.Snapshot{}, false, err
+	}
+	if len(snapshots) == 0 {
+		return hashing.Snapshot{}, false, ErrNoSnapshot
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].HasTag(BaselineTag) {
+			return snapshots[i], true, nil
+		}
+	}
+	return snapshots[len(snapshots)-1], false, nil
+}
+
+// Check hashes the tracked paths, compares them against the reference snapshot and evaluates
+// the differences against the policy rules.
+//
+// Parameters:
+//   - snapshotsDir: the snapshots directory.
+//   - entries: the entries of the track file.
+//   - rules: the policy rules, may be empty.
+//
+// Returns:
+//   - Report: the reference, the live state, the differences between them and the findings.
+//   - error: an error if there is no reference or the tracked paths cannot be hashed.
+func Check(snapshotsDir string, entries []parsing.TrackEntry, rules []policy.Rule) (Report, error) {
+	var report Report
+	var err error
+
+	report.Reference, report.IsBaseline, err = Reference(snapshotsDir)
+	if err != nil {
+		return report, err
+	}
+
+	report.Live, err = hashing.BuildSnapshot(entries)
+	if err != nil {
+		return report, err
+	}
+
+	report.Changes = hashing.Diff(report.Reference.Node, report.Live.Node)
+	report.Findings = policy.Evaluate(rules, report.Changes)
+	return report, nil
+}
+
+// SetBaseline designates an existing snapshot as the approved baseline.
+//
+// Parameters:
+//   - snapshotsDir: the snapshots directory.
+//   - ref: a reference to the snapshot, see hashing.FindSnapshot.
+//
+// Returns:
+//   - hashing.Snapshot: the new baseline.
+//   - error: an error if the snapshot cannot be found or written.
+func SetBaseline(snapshotsDir string, ref string) (hashing.Snapshot, error) {
+	return tag.Add(snapshotsDir, ref, BaselineTag, true)
+}
+
+// Accept promotes reviewed changes into a new baseline snapshot. Without paths, the whole live
+// state becomes the baseline. With paths, only those paths (and everything under them) are taken
+// from the live state, the rest of the new baseline is the current baseline.
+//
+// Parameters:
+//   - snapshotsDir: the snapshots directory.
+//   - entries: the entries of the track file.
+//   - paths: the paths to accept, all changes are accepted when empty.
+//   - by: the name of the approver.
+//   - reason: why the changes are accepted.
+//
+// Returns:
+//   - hashing.Snapshot: the new baseline.
+//   - error: an error if the approver or reason is missing, or the baseline cannot be written.
+func Accept(snapshotsDir string, entries []parsing.TrackEntry, paths []string, by string, reason string) (hashing.Snapshot, error) {
+	if by == "" {
+		return hashing.Snapshot{}, fmt.Errorf("an approver name is required")
+	}
+	if reason == "" {
+		return hashing.Snapshot{}, fmt.Errorf("a reason is required")
+	}
+
+	// the very first baseline can be accepted before any snapshot was taken
+	report, err := Check(snapshotsDir, entries, nil)
+	if errors.Is(err, ErrNoSnapshot) && len(paths) == 0 {
+		report.Live, err = hashing.BuildSnapshot(entries)
+	}
+	if err != nil {
+		return hashing.Snapshot{}, err
+	}
+
+	baseline := report.Live
+	if len(paths) > 0 {
+		if !report.IsBaseline {
+			return baseline, fmt.Errorf("there is no baseline yet, accept all changes to create one")
+		}
+
+		var accepted []string
+		for _, path := range paths {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return baseline, err
+			}
+			accepted = append(accepted, abs)
+		}
+		paths = accepted
+		baseline.Node = hashing.Graft(report.Reference.Node, report.Live.Node, paths)
+		baseline.ID = hashing.NewSnapshotID(baseline.Created, baseline.Hash)
+	}
+
+	approval := hashing.Approval{By: by, Reason: reason, Time: baseline.Created, Paths: paths}
+	if report.IsBaseline {
+		approval.Previous = report.Reference.ID
+	}
+	baseline.Approval = &approval
+	baseline.Tags = []string{BaselineTag}
+
+	baseline.File, err = hashing.WriteSnapshot(snapshotsDir, baseline)
+	if err != nil {
+		return baseline, err
+	}
+
+	// the previous baseline keeps its tag until the new one is written
+	return baseline, hashing.ClearTag(snapshotsDir, BaselineTag, baseline.ID)
+}
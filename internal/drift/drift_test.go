@@ -0,0 +1,115 @@
+package drift
+
+import (
+	"errors"
+	"magma/internal/hashing"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setup creates a tracked directory with two files and an empty snapshots directory
+func setup(t *testing.T) (snapshotsDir string, tracked string) {
+	snapshotsDir = t.TempDir()
+	tracked = t.TempDir()
+	write(t, filepath.Join(tracked, "a.conf"), "a")
+	write(t, filepath.Join(tracked, "b.conf"), "b")
+	return snapshotsDir, tracked
+}
+
+func write(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck_NoSnapshot(t *testing.T) {
+	snapshotsDir, tracked := setup(t)
+
+	if _, err := Check(snapshotsDir, parsing.Entries(tracked), nil); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("Expected ErrNoSnapshot, got %v", err)
+	}
+}
+
+func TestCheck_AgainstLatestAndBaseline(t *testing.T) {
+	snapshotsDir, tracked := setup(t)
+
+	if _, err := hashing.SnapShot(snapshotsDir, parsing.Entries(tracked)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(snapshotsDir, parsing.Entries(tracked), nil)
+	if err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+	if report.IsBaseline || len(report.Changes) != 0 {
+		t.Errorf("Expected no drift against the latest snapshot, got %+v", report.Changes)
+	}
+
+	// designate it as baseline and change a file
+	if _, err := SetBaseline(snapshotsDir, report.Reference.ID); err != nil {
+		t.Fatalf("SetBaseline returned an error: %v", err)
+	}
+	write(t, filepath.Join(tracked, "a.conf"), "changed")
+
+	report, err = Check(snapshotsDir, parsing.Entries(tracked), nil)
+	if err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+	if !report.IsBaseline {
+		t.Errorf("Expected the report to compare against the baseline")
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Path != filepath.Join(tracked, "a.conf") {
+		t.Errorf("Expected a.conf to have drifted, got %+v", report.Changes)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	snapshotsDir, tracked := setup(t)
+
+	if _, err := Accept(snapshotsDir, parsing.Entries(tracked), nil, "", "initial"); err == nil {
+		t.Errorf("Expected an error without an approver")
+	}
+
+	// the first baseline can be accepted without any snapshot
+	first, err := Accept(snapshotsDir, parsing.Entries(tracked), nil, "alice", "initial state")
+	if err != nil {
+		t.Fatalf("Accept returned an error: %v", err)
+	}
+	if first.Approval == nil || first.Approval.By != "alice" || !first.HasTag(BaselineTag) {
+		t.Fatalf("Expected an approved baseline, got %+v", first)
+	}
+
+	write(t, filepath.Join(tracked, "a.conf"), "a2")
+	write(t, filepath.Join(tracked, "b.conf"), "b2")
+
+	// only accept a.conf
+	second, err := Accept(snapshotsDir, parsing.Entries(tracked), []string{filepath.Join(tracked, "a.conf")}, "bob", "reviewed in CHG-42")
+	if err != nil {
+		t.Fatalf("Accept returned an error: %v", err)
+	}
+	if second.Approval.Previous != first.ID {
+		t.Errorf("Expected the approval to reference the previous baseline %s, got %s", first.ID, second.Approval.Previous)
+	}
+
+	// the baseline tag moved and only b.conf is left drifting
+	report, err := Check(snapshotsDir, parsing.Entries(tracked), nil)
+	if err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+	if report.Reference.ID != second.ID {
+		t.Errorf("Expected the new baseline %s to be the reference, got %s", second.ID, report.Reference.ID)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Path != filepath.Join(tracked, "b.conf") {
+		t.Errorf("Expected only b.conf to drift, got %+v", report.Changes)
+	}
+
+	old, err := hashing.ReadSnapshot(first.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.HasTag(BaselineTag) {
+		t.Errorf("Expected the baseline tag to be removed from the previous baseline")
+	}
+}
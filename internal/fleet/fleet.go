@@ -0,0 +1,242 @@
+package fleet
+
+import (
+	"fmt"
+	"magma/internal/collector"
+	"magma/internal/hashing"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Device is the snapshot of one device taking part in a comparison
+type Device struct {
+	Name     string
+	Snapshot hashing.Snapshot
+}
+
+// Absent is the state of a file a device tracks the parent of but does not have
+const Absent = ""
+
+// Deviation is a device whose file differs from the expected state
+type Deviation struct {
+	Device string
+	Hash   string // The hash of the file on the device, Absent if the device does not have it
+}
+
+// FileReport lists the devices that deviate on a single file
+type FileReport struct {
+	Path       string
+	Expected   string      // The expected hash, Absent if the file is expected not to exist
+	Tie        bool        // No state is held by a strict majority, Deviations lists every device
+	Agreeing   int         // The number of devices in the expected state
+	Compared   int         // The number of devices tracking the file
+	Deviations []Deviation // Sorted by device name
+}
+
+// LoadCollector reads one snapshot per device from a collector directory.
+//
+// Parameters:
+//   - dir: the collector directory, see collector.Collector.
+//   - ref: the snapshot to compare on each device, see hashing.FindSnapshot. The latest
+//     snapshot of each device when empty.
+//
+// Returns:
+//   - []Device: the devices having a matching snapshot, sorted by name.
+//   - []string: a warning for each device skipped because it has no matching snapshot, and for
+//     each snapshot file that cannot be read.
+//   - error: an error if the collector directory cannot be read.
+func LoadCollector(dir string, ref string) ([]Device, []string, error) {
+	names, err := collector.Devices(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var devices []Device
+	var warnings []string
+	for _, name := range names {
+		snapshotsDir := collector.DeviceSnapshotsDir(dir, name)
+
+		// a file that cannot be read does not hide the other snapshots of the device
+		snapshots, unreadable, err := hashing.ReadSnapshots(snapshotsDir)
+		for _, err := range unreadable {
+			warnings = append(warnings, fmt.Sprintf("skipping a snapshot of %s: %v", name, err))
+		}
+
+		var snapshot hashing.Snapshot
+		switch {
+		case err != nil:
+		case ref != "":
+			snapshot, err = hashing.MatchSnapshot(snapshots, ref)
+		case len(snapshots) == 0:
+			err = fmt.Errorf("no snapshot")
+		default:
+			snapshot = snapshots[len(snapshots)-1]
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping %s: %v", name, err))
+			continue
+		}
+		devices = append(devices, Device{Name: name, Snapshot: snapshot})
+	}
+	return devices, warnings, nil
+}
+
+// LoadFiles reads one snapshot file per device. Each argument is either "<device>=<file>" or a
+// file, the device then being named after the file without its .json extension.
+//
+// Parameters:
+//   - args: the snapshot files.
+//
+// Returns:
+//   - []Device: the devices, in argument order.
+//   - error: an error if a file cannot be read or two files have the same device name.
+func LoadFiles(args []string) ([]Device, error) {
+	var devices []Device
+	for _, arg := range args {
+		name, path, found := strings.Cut(arg, "=")
+		if !found {
+			path = arg
+			name = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		if slices.ContainsFunc(devices, func(device Device) bool { return device.Name == name }) {
+			return nil, fmt.Errorf("device %s is given twice, name them with <device>=<file>", name)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		snapshot, err := hashing.ReadSnapshot(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		devices = append(devices, Device{Name: name, Snapshot: snapshot})
+	}
+	return devices, nil
+}
+
+// Compare lines up the files of the devices by path and reports the files on which some devices
+// deviate. A device only takes part in the comparison of the files under the paths it tracks.
+// Without a golden device, the expected state of a file is the state held by more than half of
+// the devices tracking it, and every device is listed when no state is; with one, it is the state
+// of the golden device.
+//
+// Directories are not compared themselves, their differences show in the files under them.
+//
+// Parameters:
+//   - devices: the devices to compare.
+//   - golden: the name of the golden device, empty to compare against the majority.
+//
+// Returns:
+//   - []FileReport: the files on which some devices deviate, sorted by path.
+//   - error: an error if the golden device is not one of the devices.
+func Compare(devices []Device, golden string) ([]FileReport, error) {
+	if golden != "" && !slices.ContainsFunc(devices, func(device Device) bool { return device.Name == golden }) {
+		return nil, fmt.Errorf("golden device %s is not among the compared devices", golden)
+	}
+
+	// the hash of every file of every device
+	files := make([]map[string]string, len(devices))
+	paths := map[string]bool{}
+	for i, device := range devices {
+		files[i] = map[string]string{}
+		collectFiles(device.Snapshot.Node, files[i])
+		for path := range files[i] {
+			paths[path] = true
+		}
+	}
+
+	var reports []FileReport
+	for _, path := range sortedKeys(paths) {
+		// the state of the file on each device tracking it
+		states := map[string]string{}
+		for i, device := range devices {
+			if !tracks(device.Snapshot.Node, path) {
+				continue
+			}
+			states[device.Name] = files[i][path]
+		}
+
+		report := FileReport{Path: path, Compared: len(states)}
+		if golden != "" {
+			expected, tracked := states[golden]
+			if !tracked {
+				continue
+			}
+			report.Expected = expected
+		} else {
+			report.Expected, report.Tie = majority(states)
+		}
+
+		for _, name := range sortedKeys(states) {
+			if !report.Tie && states[name] == report.Expected {
+				report.Agreeing++
+				continue
+			}
+			report.Deviations = append(report.Deviations, Deviation{Device: name, Hash: states[name]})
+		}
+
+		if len(report.Deviations) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// majority returns the state held by a strict majority of the devices, or true if there is none
+func majority(states map[string]string) (string, bool) {
+	counts := map[string]int{}
+	for _, state := range states {
+		counts[state]++
+	}
+
+	// more than half the devices, the most common state of A,A,B,C,D is not a majority
+	for state, count := range counts {
+		if count*2 > len(states) {
+			return state, false
+		}
+	}
+	return Absent, true
+}
+
+// collectFiles records the hash of every file and symlink of a tree
+func collectFiles(node hashing.Node, files map[string]string) {
+	for _, child := range node.Children {
+		if isDir(child) {
+			collectFiles(child, files)
+			continue
+		}
+		if child.Hash != "skipped" {
+			files[child.Path] = child.Hash
+		}
+	}
+}
+
+// isDir reports whether a node is a directory, snapshots without metadata only show it through children
+func isDir(node hashing.Node) bool {
+	if node.Mode != "" {
+		return strings.HasPrefix(node.Mode, "d")
+	}
+	return len(node.Children) > 0
+}
+
+// tracks reports whether a path is one of the tracked paths of a snapshot or under one of them
+func tracks(root hashing.Node, path string) bool {
+	for _, tracked := range root.Children {
+		if tracked.Path == path || hashing.IsUnder(path, tracked.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+package fleet
+
+import (
+	"fmt"
+	"magma/internal/collector"
+	"magma/internal/hashing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// device builds a device tracking /etc/app with the given files, by name and hash
+func device(name string, files map[string]string) Device {
+	app := hashing.Node{Path: "/etc/app", Mode: "drwxr-xr-x"}
+	for _, file := range sortedKeys(files) {
+		app.Children = append(app.Children, hashing.Node{Path: "/etc/app/" + file, Hash: files[file], Mode: "-rw-r--r--"})
+	}
+	return Device{Name: name, Snapshot: hashing.Snapshot{Node: hashing.Node{Path: "root", Children: []hashing.Node{app}}}}
+}
+
+func TestCompare_Majority(t *testing.T) {
+	devices := []Device{
+		device("box-1", map[string]string{"a.conf": "aaaa", "b.conf": "bbbb"}),
+		device("box-2", map[string]string{"a.conf": "aaaa", "b.conf": "bbbb"}),
+		device("box-3", map[string]string{"a.conf": "ffff", "b.conf": "bbbb", "c.conf": "cccc"}),
+	}
+
+	reports, err := Compare(devices, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 deviating files, got %+v", reports)
+	}
+
+	a := reports[0]
+	if a.Path != "/etc/app/a.conf" || a.Expected != "aaaa" || a.Agreeing != 2 || len(a.Deviations) != 1 || a.Deviations[0] != (Deviation{"box-3", "ffff"}) {
+		t.Errorf("Unexpected report for a.conf: %+v", a)
+	}
+
+	// the majority does not have c.conf
+	c := reports[1]
+	if c.Path != "/etc/app/c.conf" || c.Expected != Absent || len(c.Deviations) != 1 || c.Deviations[0].Device != "box-3" {
+		t.Errorf("Unexpected report for c.conf: %+v", c)
+	}
+}
+
+func TestCompare_Golden(t *testing.T) {
+	devices := []Device{
+		device("box-1", map[string]string{"a.conf": "ffff"}),
+		device("box-2", map[string]string{"a.conf": "ffff"}),
+		device("golden", map[string]string{"a.conf": "aaaa"}),
+	}
+
+	reports, err := Compare(devices, "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Expected != "aaaa" || len(reports[0].Deviations) != 2 {
+		t.Errorf("Expected both boxes to deviate from the golden device, got %+v", reports)
+	}
+
+	if _, err := Compare(devices, "missing"); err == nil {
+		t.Error("Expected an unknown golden device to be rejected")
+	}
+}
+
+func TestCompare_TieAndUntracked(t *testing.T) {
+	other := device("box-3", map[string]string{})
+	other.Snapshot.Children[0].Path = "/etc/other"
+
+	devices := []Device{
+		device("box-1", map[string]string{"a.conf": "aaaa"}),
+		device("box-2", map[string]string{"a.conf": "ffff"}),
+		other,
+	}
+
+	reports, err := Compare(devices, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// box-3 does not track /etc/app so it does not take part
+	if len(reports) != 1 || !reports[0].Tie || reports[0].Compared != 2 || len(reports[0].Deviations) != 2 {
+		t.Errorf("Expected a tie between the two tracking devices, got %+v", reports)
+	}
+}
+
+func TestCompare_NoStrictMajority(t *testing.T) {
+	// a,a,b,c,d: the most common state is held by 2 of 5 devices, not a majority
+	var devices []Device
+	for i, hash := range []string{"aaaa", "aaaa", "bbbb", "cccc", "dddd"} {
+		devices = append(devices, device(fmt.Sprintf("box-%d", i+1), map[string]string{"a.conf": hash}))
+	}
+
+	reports, err := Compare(devices, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || !reports[0].Tie || reports[0].Agreeing != 0 || len(reports[0].Deviations) != 5 {
+		t.Errorf("Expected no majority among the 5 devices, got %+v", reports)
+	}
+
+	// a,a,a,b,c: 3 of 5 is
+	devices[2] = device("box-3", map[string]string{"a.conf": "aaaa"})
+	reports, err = Compare(devices, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Tie || reports[0].Agreeing != 3 || len(reports[0].Deviations) != 2 {
+		t.Errorf("Expected 3 of 5 devices to be the majority, got %+v", reports)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := hashing.Snapshot{Node: hashing.Node{Path: "root", Hash: "aaaa"}, Created: time.Now().UTC()}
+	snapshot.ID = hashing.NewSnapshotID(snapshot.Created, snapshot.Hash)
+	path, err := hashing.WriteSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named := filepath.Join(dir, "box-2.json")
+	if err := os.Link(path, named); err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := LoadFiles([]string{"box-1=" + path, named})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[0].Name != "box-1" || devices[1].Name != "box-2" {
+		t.Errorf("Unexpected devices %+v", devices)
+	}
+
+	if _, err := LoadFiles([]string{named, named}); err == nil {
+		t.Error("Expected a device given twice to be rejected")
+	}
+}
+
+func TestLoadCollector_Unreadable(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"box-1", "box-2"} {
+		snapshotsDir := collector.DeviceSnapshotsDir(dir, name)
+		if err := os.MkdirAll(snapshotsDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		snapshot := hashing.Snapshot{Node: hashing.Node{Path: "root", Hash: "aaaa"}, Created: time.Now().UTC(), Tags: []string{"nightly"}}
+		snapshot.ID = hashing.NewSnapshotID(snapshot.Created, snapshot.Hash)
+		if _, err := hashing.WriteSnapshot(snapshotsDir, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broken := filepath.Join(collector.DeviceSnapshotsDir(dir, "box-2"), "broken.json")
+	if err := os.WriteFile(broken, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the device with a broken file is still compared, the file is reported
+	for _, ref := range []string{"", "nightly"} {
+		devices, warnings, err := LoadCollector(dir, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 2 || len(warnings) != 1 || !strings.Contains(warnings[0], broken) {
+			t.Errorf("ref %q: expected both devices and a warning about %s, got %+v, %v", ref, broken, devices, warnings)
+		}
+	}
+}
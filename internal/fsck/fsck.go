@@ -0,0 +1,377 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/initialize"
+	"magma/internal/objects"
+	"magma/internal/parsing"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Problem describes a single inconsistency found while checking the magma directory.
+type Problem struct {
+	Path     string // The file or snapshot the problem was found in
+	Message  string // A human readable description of the problem
+	Repaired bool   // True if the problem was fixed during the check
+}
+
+func (p Problem) String() string {
+	status := ""
+	if p.Repaired {
+		status = " (repaired)"
+	}
+	return fmt.Sprintf("%s: %s%s", p.Path, p.Message, status)
+}
+
+// Options controls which magma directory is checked and whether problems are repaired.
+type Options struct {
+	AppRoot      string
+	TrackFile    string
+	IgnoreFile   string
+	ConfigFile   string
+	SnapshotsDir string
+	ObjectsDir   string
+	Repair       bool // Try to fix the problems that can be fixed safely
+}
+
+// DefaultOptions returns the options pointing at the standard /etc/magma layout.
+func DefaultOptions() Options {
+	return Options{
+		AppRoot:      config.AppRoot,
+		TrackFile:    config.TrackFile,
+		IgnoreFile:   config.IgnoreFile,
+		ConfigFile:   config.ConfigFile,
+		SnapshotsDir: config.SnapshotsDir,
+		ObjectsDir:   config.ObjectsDir,
+	}
+}
+
+// Run checks the magma directory described by opts. It verifies that the track, ignore and
+// config files exist and parse, validates every snapshot in the snapshots directory and re-hashes
+// the stored content of the object store.
+//
+// Parameters:
+//   - opts: the directory layout to check and whether to repair problems.
+//
+// Returns:
+//   - []Problem: every problem found, repaired or not.
+//   - error: an error if the check itself could not be carried out.
+func Run(opts Options) ([]Problem, error) {
+	var problems []Problem
+
+	info, err := os.Stat(opts.AppRoot)
+	if err != nil || !info.IsDir() {
+		// nothing else can be checked, the directory has to be created by 'magma init'
+		problems = append(problems, Problem{Path: opts.AppRoot, Message: "magma directory is missing, run 'magma init'"})
+		return problems, nil
+	}
+
+	trackProblems := checkLineFile(opts.TrackFile, nil, opts.Repair)
+	if len(trackProblems) == 0 {
+		if _, err := parsing.ReadTrackFile(opts.TrackFile); err != nil {
+			trackProblems = append(trackProblems, Problem{Path: opts.TrackFile, Message: err.Error()})
+		}
+	}
+	problems = append(problems, trackProblems...)
+	problems = append(problems, checkLineFile(opts.IgnoreFile, initialize.DefaultIgnore, opts.Repair)...)
+
+	if _, err := config.ReadConfig(opts.ConfigFile); err != nil {
+		problems = append(problems, Problem{Path: opts.ConfigFile, Message: fmt.Sprintf("cannot read config: %v", err)})
+	}
+
+	entries, err := os.ReadDir(opts.SnapshotsDir)
+	if os.IsNotExist(err) {
+		problem := Problem{Path: opts.SnapshotsDir, Message: "snapshots directory is missing"}
+		if opts.Repair {
+			if err := os.MkdirAll(opts.SnapshotsDir, 0755); err == nil {
+				problem.Repaired = true
+			}
+		}
+		return append(problems, problem), nil
+	}
+	if err != nil {
+		return problems, err
+	}
+
+	stored := map[string]bool{}
+	for _, entry := range entries {
+		path := filepath.Join(opts.SnapshotsDir, entry.Name())
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			problems = append(problems, Problem{Path: path, Message: "unexpected entry in snapshots directory"})
+			continue
+		}
+
+		snapshotProblems, readable, err := checkSnapshot(path, opts.Repair, stored)
+		if err != nil {
+			return problems, err
+		}
+
+		// snapshots that cannot be parsed at all are moved out of the way
+		if !readable && opts.Repair {
+			if err := quarantine(path, filepath.Join(opts.AppRoot, "lost+found")); err == nil {
+				snapshotProblems[0].Repaired = true
+				snapshotProblems[0].Message += ", moved to lost+found"
+			}
+		}
+		problems = append(problems, snapshotProblems...)
+	}
+
+	objectProblems, err := checkObjects(opts.ObjectsDir, stored, opts.Repair)
+	problems = append(problems, objectProblems...)
+	return problems, err
+}
+
+// checkObjects re-hashes every object of the store and makes sure the stored content of every
+// snapshot is there. When repairing, corrupted objects and the leftovers of interrupted writes are
+// removed, the next snapshot of the file stores its content again.
+func checkObjects(dir string, stored map[string]bool, repair bool) ([]Problem, error) {
+	var problems []Problem
+	found := map[string]bool{}
+
+	err := objects.Walk(dir, func(hash string, path string) error {
+		message := "not an object of the store"
+		if hash != "" {
+			valid, err := objects.Verify(path, hash)
+			if err != nil {
+				return err
+			}
+			if valid {
+				found[hash] = true
+				return nil
+			}
+			message = "content does not match its hash"
+		}
+
+		problem := Problem{Path: path, Message: message}
+		if repair && os.Remove(path) == nil {
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+		return nil
+	})
+	if err != nil {
+		return problems, err
+	}
+
+	for _, hash := range slices.Sorted(maps.Keys(stored)) {
+		if !found[hash] {
+			problems = append(problems, Problem{Path: objects.Path(dir, hash), Message: "stored content referenced by a snapshot is missing"})
+		}
+	}
+	return problems, nil
+}
+
+// checkLineFile makes sure a line based magma file (track, ignore) exists and can be read.
+// When repairing, a missing file is recreated with the given default lines.
+func checkLineFile(path string, defaults []string, repair bool) []Problem {
+	_, err := parsing.ReadMagmaFile(path)
+	if err == nil {
+		return nil
+	}
+
+	problem := Problem{Path: path, Message: fmt.Sprintf("cannot read file: %v", err)}
+	if os.IsNotExist(err) && repair {
+		if err := parsing.WriteTrack(defaults, path); err == nil {
+			problem.Repaired = true
+		}
+	}
+	return []Problem{problem}
+}
+
+// CheckSnapshot validates a single snapshot file. It checks that the JSON matches the snapshot
+// schema, that every hash is well formed, that child paths sit under their parent and re-derives
+// every directory hash from its children. When repair is true, wrong directory hashes are rewritten
+// and the snapshot is renamed if its id or file name no longer matches the root hash.
+//
+// Parameters:
+//   - path: the path of the snapshot JSON file.
+//   - repair: whether to rewrite the snapshot to fix derived hashes.
+//
+// Returns:
+//   - []Problem: the problems found in the snapshot.
+//   - error: an error if the file could not be read or rewritten.
+func CheckSnapshot(path string, repair bool) ([]Problem, error) {
+	problems, _, err := checkSnapshot(path, repair, map[string]bool{})
+	return problems, err
+}
+
+// checkSnapshot implements CheckSnapshot and additionally reports whether the file could be parsed.
+// The hashes of the stored content of the snapshot are added to stored.
+func checkSnapshot(path string, repair bool, stored map[string]bool) ([]Problem, bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var root hashing.Snapshot
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&root); err != nil {
+		return []Problem{{Path: path, Message: fmt.Sprintf("snapshot does not match the snapshot schema: %v", err)}}, false, nil
+	}
+
+	storedHashes(root.Node, stored)
+
+	var problems []Problem
+	if root.Path != "root" {
+		problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("root node has path %q, expected \"root\"", root.Path)})
+	}
+
+	changed := checkNode(path, &root.Node, true, repair, &problems)
+
+	// snapshot ids end with the first 8 characters of the root hash, legacy file names start with them
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	expected := name
+	if len(root.Hash) >= 8 {
+		if created, _, ok := hashing.ParseSnapshotID(root.ID); ok {
+			expected = hashing.NewSnapshotID(created, root.Hash)
+			if root.ID != expected {
+				problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("snapshot id %s does not match root hash %s", root.ID, root.Hash[:8]), Repaired: repair})
+			}
+			if name != root.ID {
+				problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("file name does not match snapshot id %s", root.ID), Repaired: repair})
+			}
+		} else {
+			// legacy snapshots are named <hash>_<tag1>_<tag2>, their id (if any) is the file name
+			_, tags, _ := strings.Cut(name, "_")
+			expected = root.Hash[:8]
+			if tags != "" {
+				expected += "_" + tags
+			}
+			if name != expected {
+				problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("file name does not match root hash %s", root.Hash[:8]), Repaired: repair})
+			}
+		}
+	}
+
+	if !repair {
+		return problems, true, nil
+	}
+
+	if _, _, ok := hashing.ParseSnapshotID(root.ID); ok && root.ID != expected {
+		root.ID = expected
+		changed = true
+	}
+
+	// a renamed snapshot is written to its new name before the old file is removed
+	target := path
+	if name != expected {
+		if root.ID != "" && root.ID != expected {
+			// a rewritten legacy snapshot records its file name as id
+			root.ID = expected
+			changed = true
+		}
+		target = filepath.Join(filepath.Dir(path), expected+".json")
+		if _, err := os.Stat(target); err == nil {
+			return problems, true, fmt.Errorf("cannot rename %s, %s already exists", path, target)
+		}
+	}
+
+	switch {
+	case changed:
+		if err := hashing.WriteSnapshotFile(target, root); err != nil {
+			return problems, true, err
+		}
+		if target != path {
+			if err := os.Remove(path); err != nil {
+				return problems, true, err
+			}
+		}
+	case target != path:
+		if err := os.Rename(path, target); err != nil {
+			return problems, true, err
+		}
+	}
+
+	return problems, true, nil
+}
+
+// checkNode validates a node and its children, returning true if a hash was rewritten.
+func checkNode(file string, node *hashing.Node, isRoot bool, repair bool, problems *[]Problem) bool {
+	report := func(message string, repaired bool) {
+		*problems = append(*problems, Problem{Path: file, Message: message, Repaired: repaired})
+	}
+
+	if node.Hash == "skipped" {
+		if len(node.Children) > 0 {
+			report(fmt.Sprintf("skipped node %s has children", node.Path), false)
+		}
+		return false
+	}
+
+	if !isRoot && !filepath.IsAbs(node.Path) {
+		report(fmt.Sprintf("node path %q is not absolute", node.Path), false)
+	}
+	if !isHash(node.Hash) {
+		report(fmt.Sprintf("node %s has malformed hash %q", node.Path, node.Hash), false)
+	}
+
+	changed := false
+	for i := range node.Children {
+		child := &node.Children[i]
+		if !isRoot && child.Hash != "skipped" && filepath.Dir(child.Path) != node.Path {
+			report(fmt.Sprintf("node %s is not a child of %s", child.Path, node.Path), false)
+		}
+		if checkNode(file, child, false, repair, problems) {
+			changed = true
+		}
+	}
+
+	// files and symlinks have no children, only directories (and the root) can be re-derived. An
+	// empty directory is told from an empty file by its mode, legacy snapshots have none
+	if isRoot || len(node.Children) > 0 || strings.HasPrefix(node.Mode, "d") {
+		expected := hashing.HashChildren(node.Children)
+		// a directory behind a link followed with symlinks=both also hashes the link target
+		if !isRoot && node.Target != "" && node.Hash == hashing.HashLink(node.Target, expected) {
+			expected = node.Hash
+		}
+		if node.Hash != expected {
+			report(fmt.Sprintf("hash of %s does not match its children", node.Path), repair)
+			if repair {
+				node.Hash = expected
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// storedHashes adds the hashes of the files of a node whose content is stored
+func storedHashes(node hashing.Node, hashes map[string]bool) {
+	if node.Stored && isHash(node.Hash) {
+		hashes[node.Hash] = true
+	}
+	for _, child := range node.Children {
+		storedHashes(child, hashes)
+	}
+}
+
+// isHash reports whether s looks like a hex encoded SHA-256 hash.
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// quarantine moves a file into the given directory so it no longer gets in the way.
+func quarantine(path string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+}
@@ -0,0 +1,257 @@
+package fsck
+
+import (
+	"encoding/json"
+	"magma/internal/hashing"
+	"magma/internal/objects"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newMagmaDir creates a temporary magma directory layout and returns the options pointing at it
+func newMagmaDir(t *testing.T) Options {
+	root := t.TempDir()
+	opts := Options{
+		AppRoot:      root,
+		TrackFile:    filepath.Join(root, "track"),
+		IgnoreFile:   filepath.Join(root, "ignore"),
+		ConfigFile:   filepath.Join(root, "config.yaml"),
+		SnapshotsDir: filepath.Join(root, "snapshots"),
+		ObjectsDir:   filepath.Join(root, "objects"),
+	}
+
+	if err := os.Mkdir(opts.SnapshotsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{opts.TrackFile, opts.IgnoreFile} {
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(opts.ConfigFile, []byte("device_id: \"test\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// writeSnapshot takes a snapshot of a small directory tree and returns the snapshot file path
+func writeSnapshot(t *testing.T, snapshotsDir string) string {
+	tracked := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tracked, "a.conf"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(tracked, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tracked, "sub", "b.conf"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := hashing.SnapShot(snapshotsDir, parsing.Entries(tracked)); err != nil {
+		t.Fatalf("SnapShot returned an error: %v", err)
+	}
+
+	files, err := os.ReadDir(snapshotsDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected exactly one snapshot, got %d (%v)", len(files), err)
+	}
+	return filepath.Join(snapshotsDir, files[0].Name())
+}
+
+func TestRun_Clean(t *testing.T) {
+	opts := newMagmaDir(t)
+	writeSnapshot(t, opts.SnapshotsDir)
+
+	problems, err := Run(opts)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestRun_MissingFiles(t *testing.T) {
+	opts := newMagmaDir(t)
+	os.Remove(opts.IgnoreFile)
+
+	problems, err := Run(opts)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Path != opts.IgnoreFile || problems[0].Repaired {
+		t.Fatalf("Expected one unrepaired problem for the ignore file, got %v", problems)
+	}
+
+	// repairing recreates the ignore file
+	opts.Repair = true
+	problems, err = Run(opts)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if len(problems) != 1 || !problems[0].Repaired {
+		t.Fatalf("Expected the ignore file to be repaired, got %v", problems)
+	}
+	if _, err := os.Stat(opts.IgnoreFile); err != nil {
+		t.Errorf("Ignore file was not recreated: %v", err)
+	}
+}
+
+func TestCheckSnapshot_TamperedDirectoryHash(t *testing.T) {
+	opts := newMagmaDir(t)
+	path := writeSnapshot(t, opts.SnapshotsDir)
+
+	// change the hash of a file without updating the directories above it
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root hashing.Snapshot
+	if err := json.Unmarshal(content, &root); err != nil {
+		t.Fatal(err)
+	}
+	root.Children[0].Children[0].Hash = hashing.HashChildren(nil)
+	content, _ = json.Marshal(root)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := CheckSnapshot(path, false)
+	if err != nil {
+		t.Fatalf("CheckSnapshot returned an error: %v", err)
+	}
+	if len(problems) == 0 {
+		t.Fatal("Expected the tampered hash to be detected")
+	}
+
+	// repair re-derives the hashes and renames the file to match the new root hash
+	if _, err := CheckSnapshot(path, true); err != nil {
+		t.Fatalf("CheckSnapshot returned an error: %v", err)
+	}
+	files, _ := os.ReadDir(opts.SnapshotsDir)
+	if len(files) != 1 {
+		t.Fatalf("Expected one snapshot after repair, got %d", len(files))
+	}
+	if files[0].Name() == filepath.Base(path) {
+		t.Errorf("Expected the snapshot to be renamed after its new root hash")
+	}
+	problems, err = CheckSnapshot(filepath.Join(opts.SnapshotsDir, files[0].Name()), false)
+	if err != nil {
+		t.Fatalf("CheckSnapshot returned an error: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems after repair, got %v", problems)
+	}
+}
+
+func TestCheckSnapshot_EmptyDirectory(t *testing.T) {
+	opts := newMagmaDir(t)
+	tracked := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tracked, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := hashing.SnapShot(opts.SnapshotsDir, parsing.Entries(tracked))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty directory has no children to re-derive its hash from, but must hash as empty
+	snapshot.Children[0].Children[0].Hash = hashing.HashChildren([]hashing.Node{{Hash: "x"}})
+	if err := hashing.WriteSnapshotFile(snapshot.File, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := CheckSnapshot(snapshot.File, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) == 0 || !strings.Contains(problems[0].Message, filepath.Join(tracked, "empty")+" ") {
+		t.Fatalf("Expected the hash of the empty directory to be reported, got %v", problems)
+	}
+
+	// the repair is written through a temporary file, nothing else is left in the directory
+	if _, err := CheckSnapshot(snapshot.File, true); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(opts.SnapshotsDir)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".json" {
+		t.Fatalf("Expected a single snapshot after repair, got %v", files)
+	}
+	problems, err = CheckSnapshot(filepath.Join(opts.SnapshotsDir, files[0].Name()), false)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected no problems after repair, got %v, %v", problems, err)
+	}
+}
+
+func TestRun_InvalidSnapshot(t *testing.T) {
+	opts := newMagmaDir(t)
+	path := filepath.Join(opts.SnapshotsDir, "deadbeef.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Repair = true
+	problems, err := Run(opts)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if len(problems) != 1 || !problems[0].Repaired {
+		t.Fatalf("Expected the invalid snapshot to be quarantined, got %v", problems)
+	}
+	if _, err := os.Stat(filepath.Join(opts.AppRoot, "lost+found", "deadbeef.json")); err != nil {
+		t.Errorf("Invalid snapshot was not moved to lost+found: %v", err)
+	}
+}
+
+func TestCheckObjects(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "objects")
+
+	var hashes []string
+	for _, content := range []string{"valid", "corrupted"} {
+		file := filepath.Join(dir, content)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := objects.Put(store, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	corrupted := objects.Path(store, hashes[1])
+	if err := os.Chmod(corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(corrupted, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store, ".object-123"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := strings.Repeat("0", 64)
+
+	stored := map[string]bool{hashes[0]: true, missing: true}
+	problems, err := checkObjects(store, stored, false)
+	if err != nil {
+		t.Fatalf("checkObjects returned an error: %v", err)
+	}
+	if len(problems) != 3 {
+		t.Fatalf("Expected the corrupted, leftover and missing objects, got %v", problems)
+	}
+
+	problems, err = checkObjects(store, stored, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if problem.Path != objects.Path(store, missing) && !problem.Repaired {
+			t.Errorf("Expected %s to be repaired", problem)
+		}
+	}
+	if !objects.Has(store, hashes[0]) || objects.Has(store, hashes[1]) {
+		t.Errorf("Expected only the valid object to remain")
+	}
+}
@@ -0,0 +1,307 @@
+package hashing
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangeKind describes how a node differs between two snapshots
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+	Metadata ChangeKind = "metadata" // Same content, different permissions, ownership, modification time, link target or hardlink
+)
+
+// Change is a single difference between two snapshots
+type Change struct {
+	Path    string     `json:"path"`
+	Kind    ChangeKind `json:"kind"`
+	OldHash string     `json:"old_hash,omitempty"`
+	NewHash string     `json:"new_hash,omitempty"`
+
+	// The metadata that changed: mode, owner, group, size and/or mtime
+	Attributes []string `json:"attributes,omitempty"`
+
+	Old *Node `json:"-"` // The node in the old snapshot, without its children
+	New *Node `json:"-"` // The node in the new snapshot, without its children
+}
+
+// emptyHash is the hash of an empty directory (and of an empty file)
+var emptyHash = hashString("")
+
+// Diff compares two snapshot trees and returns the differences, sorted by path.
+// Added and removed nodes are reported individually, including every node under an added or
+// removed directory. Directories are never reported as modified since their hash is derived
+// from their children, only files and symlinks are. Nodes whose content did not change but whose
+// permissions, ownership or modification time did are reported as metadata changes.
+//
+// Parameters:
+//   - old: the root node of the reference snapshot.
+//   - new: the root node of the snapshot compared to it.
+//
+// Returns:
+//   - []Change: the differences between the two trees.
+func Diff(old Node, new Node) []Change {
+	oldNodes := flatten(old)
+	newNodes := flatten(new)
+
+	var changes []Change
+	for path, oldNode := range oldNodes {
+		newNode, found := newNodes[path]
+		if !found {
+			changes = append(changes, Change{Path: path, Kind: Removed, OldHash: oldNode.Hash, Old: oldNode})
+			continue
+		}
+
+		change := Change{Path: path, OldHash: oldNode.Hash, NewHash: newNode.Hash, Old: oldNode, New: newNode}
+		change.Attributes = metadataChanges(oldNode, newNode)
+
+		oldIsDir := len(oldNode.Children) > 0
+		newIsDir := len(newNode.Children) > 0
+		switch {
+		case oldNode.Hash == newNode.Hash:
+			change.Kind = Metadata
+		case oldIsDir && newIsDir:
+			// derived from the children, which are reported themselves
+			change.Kind = Metadata
+		case !oldIsDir && newIsDir && oldNode.Hash == emptyHash, oldIsDir && !newIsDir && newNode.Hash == emptyHash:
+			// a directory that was or became empty
+			change.Kind = Metadata
+		default:
+			change.Kind = Modified
+		}
+
+		if change.Kind == Metadata && len(change.Attributes) == 0 {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for path, newNode := range newNodes {
+		if _, found := oldNodes[path]; !found {
+			changes = append(changes, Change{Path: path, Kind: Added, NewHash: newNode.Hash, New: newNode})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	// strip the children, callers only need the node itself
+	for i := range changes {
+		changes[i].Old = shallow(changes[i].Old)
+		changes[i].New = shallow(changes[i].New)
+	}
+
+	return changes
+}
+
+// metadataChanges lists the metadata attributes that differ between two versions of a node.
+// Nothing is reported when one of them was recorded without metadata. The size and modification
+// time of directories change with their entries and are not reported.
+func metadataChanges(old *Node, new *Node) []string {
+	if old.Mode == "" || new.Mode == "" {
+		return nil
+	}
+
+	var attributes []string
+	if old.Mode != new.Mode {
+		attributes = append(attributes, "mode")
+	}
+	if old.UID != new.UID {
+		attributes = append(attributes, "owner")
+	}
+	if old.GID != new.GID {
+		attributes = append(attributes, "group")
+	}
+	// a followed link pointing elsewhere may hold the same content, snapshots taken before
+	// targets were recorded have none
+	if old.Target != "" && new.Target != "" && old.Target != new.Target {
+		attributes = append(attributes, "target")
+	}
+	if strings.HasPrefix(new.Mode, "d") {
+		return attributes
+	}
+	// a file linked to another path of the snapshot, or no longer, see Node.Hardlink
+	if old.Inode != 0 && new.Inode != 0 && old.Hardlink != new.Hardlink {
+		attributes = append(attributes, "hardlink")
+	}
+	if old.Size != new.Size {
+		attributes = append(attributes, "size")
+	}
+	if old.MTime != new.MTime {
+		attributes = append(attributes, "mtime")
+	}
+	return attributes
+}
+
+// flatten indexes every node of a tree by path, skipped nodes and the root are left out
+func flatten(root Node) map[string]*Node {
+	nodes := map[string]*Node{}
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		for i := range node.Children {
+			child := &node.Children[i]
+			// a path starting or ceasing to be skipped shows as added or removed
+			if child.Path != "" && child.Hash != "skipped" {
+				nodes[child.Path] = child
+			}
+			walk(child)
+		}
+	}
+	walk(&root)
+	return nodes
+}
+
+// shallow returns a copy of the node without its children
+func shallow(node *Node) *Node {
+	if node == nil {
+		return nil
+	}
+	stripped := *node
+	stripped.Children = nil
+	return &stripped
+}
+
+// Graft builds a new tree from base in which the given paths are replaced by their version in
+// live: a path (and everything under it) that only exists in live is added, one that only exists
+// in base is removed. Everything else keeps its base version and directory hashes are re-derived.
+//
+// Parameters:
+//   - base: the root node of the tree to start from.
+//   - live: the root node of the tree holding the accepted changes.
+//   - paths: the absolute paths to take from live.
+//
+// Returns:
+//   - Node: the root node of the grafted tree.
+func Graft(base Node, live Node, paths []string) Node {
+	cleaned := make([]string, len(paths))
+	for i, path := range paths {
+		cleaned[i] = filepath.Clean(path)
+	}
+
+	root := graftChildren(base, live, cleaned)
+	root.Path = base.Path
+	return root
+}
+
+// graftNode merges a node present in base, live or both, it returns false if the node is dropped
+func graftNode(base *Node, live *Node, paths []string) (Node, bool) {
+	path := ""
+	if base != nil {
+		path = base.Path
+	} else {
+		path = live.Path
+	}
+
+	if selected(path, paths) {
+		if live == nil {
+			return Node{}, false
+		}
+		return *live, true
+	}
+
+	if !hasSelectedDescendant(path, paths) {
+		if base == nil {
+			return Node{}, false
+		}
+		return *base, true
+	}
+
+	var b, l Node
+	if base != nil {
+		b = *base
+	}
+	if live != nil {
+		l = *live
+	}
+	node := graftChildren(b, l, paths)
+	node.Path = path
+	return node, true
+}
+
+// graftChildren merges the children of two versions of the same directory, the other fields are
+// those of the base version, or of the live one when the directory is new
+func graftChildren(base Node, live Node, paths []string) Node {
+	baseChildren := map[string]*Node{}
+	liveChildren := map[string]*Node{}
+	var order []string
+
+	for i := range base.Children {
+		child := &base.Children[i]
+		if _, found := baseChildren[child.Path]; !found {
+			order = append(order, child.Path)
+		}
+		baseChildren[child.Path] = child
+	}
+	for i := range live.Children {
+		child := &live.Children[i]
+		if _, found := baseChildren[child.Path]; !found {
+			if _, found := liveChildren[child.Path]; !found {
+				order = append(order, child.Path)
+			}
+		}
+		liveChildren[child.Path] = child
+	}
+
+	// entries of a directory are hashed in name order, tracked roots in track file order
+	if base.Path != "root" {
+		sort.Strings(order)
+	}
+
+	// the directory keeps its base metadata, label and mount, only its content is grafted
+	node := base
+	if base.Path == "" {
+		node = live
+	}
+	node.Children = nil
+	for _, path := range order {
+		if path == "" {
+			// skipped entries of legacy snapshots can't be matched, keep the base ones
+			for _, child := range base.Children {
+				if child.Path == "" {
+					node.Children = append(node.Children, child)
+				}
+			}
+			continue
+		}
+		child, keep := graftNode(baseChildren[path], liveChildren[path], paths)
+		if keep {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	node.Hash = hashNodeList(node.Children)
+	return node
+}
+
+// selected reports whether the path is one of the given paths or under one of them
+func selected(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || IsUnder(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSelectedDescendant reports whether one of the given paths is under the path
+func hasSelectedDescendant(path string, paths []string) bool {
+	for _, p := range paths {
+		if IsUnder(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnder reports whether path is strictly inside dir
+func IsUnder(path string, dir string) bool {
+	if dir == "/" {
+		return path != "/" && strings.HasPrefix(path, "/")
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
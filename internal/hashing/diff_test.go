@@ -0,0 +1,273 @@
+package hashing
+
+import (
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// file returns a file node with the hash of the given content
+func file(path string, content string) Node {
+	return Node{Path: path, Hash: hashString(content)}
+}
+
+// dir returns a directory node with its hash derived from its children
+func dir(path string, children ...Node) Node {
+	return Node{Path: path, Hash: hashNodeList(children), Children: children}
+}
+
+func TestDiff(t *testing.T) {
+	old := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a"),
+			file("/etc/app/b.conf", "b"),
+			dir("/etc/app/old", file("/etc/app/old/x", "x")),
+		),
+	)
+	new := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a2"),
+			file("/etc/app/b.conf", "b"),
+			file("/etc/app/c.conf", "c"),
+		),
+	)
+
+	changes := Diff(old, new)
+
+	expected := []struct {
+		path string
+		kind ChangeKind
+	}{
+		{"/etc/app/a.conf", Modified},
+		{"/etc/app/c.conf", Added},
+		{"/etc/app/old", Removed},
+		{"/etc/app/old/x", Removed},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d: %+v", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.Path != expected[i].path || change.Kind != expected[i].kind {
+			t.Errorf("Change %d: expected %s %s, got %s %s", i, expected[i].kind, expected[i].path, change.Kind, change.Path)
+		}
+	}
+
+	if changes[0].Old == nil || changes[0].New == nil || changes[0].Old.Hash != hashString("a") {
+		t.Errorf("Expected the modified change to carry both nodes, got %+v", changes[0])
+	}
+}
+
+func TestDiff_NoChanges(t *testing.T) {
+	tree := dir("root", dir("/etc/app", file("/etc/app/a.conf", "a")))
+
+	if changes := Diff(tree, tree); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+}
+
+func TestDiff_Skipped(t *testing.T) {
+	old := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a"),
+			Node{Path: "/etc/app/app.log", Hash: "skipped", Reason: "ignored by *.log"},
+		),
+	)
+	new := dir("root",
+		dir("/etc/app",
+			Node{Path: "/etc/app/a.conf", Hash: "skipped", Reason: "ignored by *.conf"},
+			file("/etc/app/app.log", "log"),
+		),
+	)
+
+	changes := Diff(old, new)
+	if len(changes) != 2 ||
+		changes[0].Path != "/etc/app/a.conf" || changes[0].Kind != Removed ||
+		changes[1].Path != "/etc/app/app.log" || changes[1].Kind != Added {
+		t.Errorf("Expected skipped entries to show as removed and added, got %+v", changes)
+	}
+}
+
+func TestDiff_EmptyDirectoryFilled(t *testing.T) {
+	old := dir("root", dir("/etc/app"))
+	new := dir("root", dir("/etc/app", file("/etc/app/a.conf", "a")))
+
+	changes := Diff(old, new)
+	if len(changes) != 1 || changes[0].Kind != Added || changes[0].Path != "/etc/app/a.conf" {
+		t.Errorf("Expected only the new file to be reported, got %+v", changes)
+	}
+}
+
+func TestGraft(t *testing.T) {
+	base := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a"),
+			file("/etc/app/b.conf", "b"),
+		),
+	)
+	live := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a2"),
+			file("/etc/app/b.conf", "b2"),
+			file("/etc/app/c.conf", "c"),
+		),
+	)
+
+	// only accept the change to a.conf and the new c.conf
+	grafted := Graft(base, live, []string{"/etc/app/a.conf", "/etc/app/c.conf"})
+
+	expected := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a2"),
+			file("/etc/app/b.conf", "b"),
+			file("/etc/app/c.conf", "c"),
+		),
+	)
+	if grafted.Hash != expected.Hash {
+		t.Errorf("Grafted tree does not match the expected tree: %+v", Diff(expected, grafted))
+	}
+	if grafted.Path != "root" {
+		t.Errorf("Expected the root path to be kept, got %s", grafted.Path)
+	}
+
+	// the remaining drift is the change that was not accepted
+	changes := Diff(grafted, live)
+	if len(changes) != 1 || changes[0].Path != "/etc/app/b.conf" {
+		t.Errorf("Expected only b.conf to differ from live, got %+v", changes)
+	}
+}
+
+func TestGraft_Removal(t *testing.T) {
+	base := dir("root",
+		dir("/etc/app",
+			file("/etc/app/a.conf", "a"),
+			file("/etc/app/b.conf", "b"),
+		),
+	)
+	live := dir("root", dir("/etc/app", file("/etc/app/a.conf", "a")))
+
+	grafted := Graft(base, live, []string{"/etc/app/b.conf"})
+	if grafted.Hash != live.Hash {
+		t.Errorf("Expected the accepted removal to produce the live tree, got %+v", Diff(live, grafted))
+	}
+}
+
+func TestGraft_MatchesLiveHash(t *testing.T) {
+	// accepting every change of a real directory must produce the same hashes as hashing it again
+	tmpdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpdir, "b"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := HashPath(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpdir, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := HashPath(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grafted := Graft(dir("root", before), dir("root", after), []string{filepath.Join(tmpdir, "a")})
+	if grafted.Hash != dir("root", after).Hash {
+		t.Errorf("Expected the grafted tree to hash like the live tree")
+	}
+}
+
+func TestGraft_KeepsMetadata(t *testing.T) {
+	tmpdir := t.TempDir()
+	sub := filepath.Join(tmpdir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "a"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entry := parsing.TrackEntry{Path: tmpdir, Label: "app"}
+	before, err := BuildSnapshot([]parsing.TrackEntry{entry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "b"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := BuildSnapshot([]parsing.TrackEntry{entry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// accepting a single path keeps the metadata of the directories above it
+	grafted := Graft(before.Node, after.Node, []string{filepath.Join(sub, "b")})
+	nodes := nodesByPath(grafted)
+	if nodes[tmpdir].Label != "app" || nodes[tmpdir].Mode == "" || nodes[sub].Mode == "" || nodes[sub].MTime == 0 {
+		t.Errorf("Expected the grafted directories to keep their metadata, got %+v and %+v", nodes[tmpdir], nodes[sub])
+	}
+
+	// so a later chmod is still reported against the grafted baseline
+	if err := os.Chmod(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	live, err := BuildSnapshot([]parsing.TrackEntry{entry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(grafted, live.Node)
+	if len(changes) != 1 || changes[0].Path != sub || changes[0].Kind != Metadata {
+		t.Errorf("Expected the mode change of %s, got %+v", sub, changes)
+	}
+}
+
+func TestDiff_Metadata(t *testing.T) {
+	oldFile := file("/etc/app/a.conf", "a")
+	oldFile.Mode, oldFile.MTime = "-rw-r--r--", 100
+	newFile := oldFile
+	newFile.Mode, newFile.UID = "-rw-rw-rw-", 1000
+
+	// directories only report mode and ownership changes
+	oldDir := dir("/etc/app", oldFile)
+	oldDir.Mode, oldDir.MTime = "drwxr-xr-x", 100
+	newDir := dir("/etc/app", newFile)
+	newDir.Mode, newDir.MTime = "drwxr-xr-x", 200
+
+	changes := Diff(dir("root", oldDir), dir("root", newDir))
+	if len(changes) != 1 {
+		t.Fatalf("Expected a single change, got %+v", changes)
+	}
+	if changes[0].Kind != Metadata || strings.Join(changes[0].Attributes, ",") != "mode,owner" {
+		t.Errorf("Expected a mode and owner change, got %+v", changes[0])
+	}
+
+	// snapshots without metadata never report metadata changes
+	legacy := file("/etc/app/a.conf", "a")
+	if changes := Diff(dir("root", dir("/etc/app", legacy)), dir("root", newDir)); len(changes) != 0 {
+		t.Errorf("Expected no change against a snapshot without metadata, got %+v", changes)
+	}
+
+	// a followed link pointing to another file with the same content
+	oldLink := file("/etc/alternatives/editor", "vim")
+	oldLink.Mode, oldLink.Target = "-rwxr-xr-x", "/usr/bin/vim.basic"
+	newLink := oldLink
+	newLink.Target = "/usr/bin/vim.tiny"
+	changes = Diff(dir("root", oldLink), dir("root", newLink))
+	if len(changes) != 1 || changes[0].Kind != Metadata || strings.Join(changes[0].Attributes, ",") != "target" {
+		t.Errorf("Expected a target change, got %+v", changes)
+	}
+
+	// a hardlink broken, snapshots without inodes never report one
+	oldCopy := file("/etc/app/b.conf", "a")
+	oldCopy.Mode, oldCopy.Inode, oldCopy.Hardlink = "-rw-r--r--", 12, "/etc/app/a.conf"
+	newCopy := oldCopy
+	newCopy.Inode, newCopy.Hardlink = 13, ""
+	changes = Diff(dir("root", oldCopy), dir("root", newCopy))
+	if len(changes) != 1 || changes[0].Kind != Metadata || strings.Join(changes[0].Attributes, ",") != "hardlink" {
+		t.Errorf("Expected a hardlink change, got %+v", changes)
+	}
+	oldCopy.Inode = 0
+	if changes := Diff(dir("root", oldCopy), dir("root", newCopy)); len(changes) != 0 {
+		t.Errorf("Expected no change against a snapshot without inodes, got %+v", changes)
+	}
+}
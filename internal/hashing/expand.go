@@ -0,0 +1,143 @@
+package hashing
+
+import (
+	"magma/internal/ignore"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ExpandEntries replaces the entries whose path is a glob pattern by an entry per matching path,
+// with the options of the pattern and the pattern recorded, so the files created since the last
+// snapshot are picked up. Ignored paths, and paths inside another match of the same pattern, are
+// left out. A pattern matching nothing expands to no entry.
+//
+// Parameters:
+//   - entries: the entries of the track file.
+//
+// Returns:
+//   - []parsing.TrackEntry: the entries with the patterns expanded, in the order of the track file.
+//   - error: an error if a pattern is not valid.
+func (s *IgnoreScopes) ExpandEntries(entries []parsing.TrackEntry) ([]parsing.TrackEntry, error) {
+	var expanded []parsing.TrackEntry
+	for _, entry := range entries {
+		if !entry.IsPattern() {
+			expanded = append(expanded, entry)
+			continue
+		}
+
+		matches, err := s.Expand(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			matched := entry
+			matched.Path = match
+			matched.Pattern = entry.Path
+			expanded = append(expanded, matched)
+		}
+	}
+	return expanded, nil
+}
+
+// Expand returns the paths matching a tracked pattern, sorted. Directories that cannot be read
+// are skipped.
+//
+// Parameters:
+//   - pattern: the absolute doublestar pattern.
+//
+// Returns:
+//   - []string: the matching paths that are not ignored and not inside another match.
+//   - error: an error if the pattern is not valid.
+func (s *IgnoreScopes) Expand(pattern string) ([]string, error) {
+	matches, base, err := globSorted(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, match := range matches {
+		info, err := os.Lstat(match)
+		if err != nil || s.IsIgnored(base, match, info.IsDir()) || inside(match, paths) {
+			continue
+		}
+		paths = append(paths, match)
+	}
+	return paths, nil
+}
+
+// IgnoredBy tells why a tracked pattern expands to nothing although it matches paths: every match
+// is ignored, like /home/*/.bashrc with the default **/.* rule.
+//
+// Parameters:
+//   - pattern: the absolute doublestar pattern.
+//
+// Returns:
+//   - *ignore.Rule: the rule ignoring the first match, nil if a match is kept or nothing matches.
+//   - error: an error if the pattern is not valid.
+func (s *IgnoreScopes) IgnoredBy(pattern string) (*ignore.Rule, error) {
+	matches, base, err := globSorted(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var first *ignore.Rule
+	for _, match := range matches {
+		info, err := os.Lstat(match)
+		if err != nil {
+			continue
+		}
+		rule, ignored := s.Scope(filepath.Dir(match)).ExplainUnder(base, match, info.IsDir())
+		if !ignored {
+			return nil, nil
+		}
+		if first == nil {
+			first = rule
+		}
+	}
+	return first, nil
+}
+
+// globSorted returns the paths matching a pattern, parent directories before their content, and
+// the directory the pattern starts with. That directory is tracked explicitly, the directories
+// the pattern matches may be ignored.
+func globSorted(pattern string) ([]string, string, error) {
+	matches, err := doublestar.FilepathGlob(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	slices.Sort(matches)
+	base, _ := doublestar.SplitPattern(filepath.ToSlash(pattern))
+	return matches, base, nil
+}
+
+// Tracks reports whether a path is the path of an entry, or under it. For a pattern, the path or
+// one of its parent directories must match the pattern.
+func Tracks(entry parsing.TrackEntry, path string) bool {
+	if !entry.IsPattern() {
+		root := filepath.Clean(entry.Path)
+		return path == root || strings.HasPrefix(path, root+"/") || root == "/"
+	}
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if match, _ := doublestar.PathMatch(entry.Path, dir); match {
+			return true
+		}
+		if dir == "/" || dir == "." {
+			return false
+		}
+	}
+}
+
+// inside reports whether a path is one of the given directories or under one of them
+func inside(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package hashing
+
+import (
+	"magma/internal/initialize"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandEntries(t *testing.T) {
+	tmpdir := t.TempDir()
+	for _, path := range []string{"alice/.bashrc", "bob/.bashrc", "carol/.profile", "etc/a.conf", "etc/sub/b.conf", "etc/sub/c.log"} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setIgnore(t, "bob/")
+
+	entries, err := NewIgnoreScopes().ExpandEntries([]parsing.TrackEntry{
+		{Path: filepath.Join(tmpdir, "*", ".bashrc"), Label: "shell"},
+		{Path: filepath.Join(tmpdir, "etc", "**", "*.conf")},
+		{Path: filepath.Join(tmpdir, "etc", "**")},
+		{Path: filepath.Join(tmpdir, "*.missing")},
+		{Path: filepath.Join(tmpdir, "carol")},
+	})
+	if err != nil {
+		t.Fatalf("ExpandEntries returned an error: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(tmpdir, "alice", ".bashrc"), // bob is ignored
+		filepath.Join(tmpdir, "etc", "a.conf"),
+		filepath.Join(tmpdir, "etc", "sub", "b.conf"),
+		filepath.Join(tmpdir, "etc"), // ** matches etc itself, its content is hashed with it
+		filepath.Join(tmpdir, "carol"),
+	}
+	if paths := parsing.Paths(entries); !slices.Equal(paths, expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+	if entries[0].Label != "shell" || entries[0].Pattern != filepath.Join(tmpdir, "*", ".bashrc") {
+		t.Errorf("Expected the options and the pattern on the expanded entry, got %+v", entries[0])
+	}
+	if entries[4].Pattern != "" {
+		t.Errorf("Expected no pattern on a plain path, got %q", entries[4].Pattern)
+	}
+
+	if _, err := NewIgnoreScopes().ExpandEntries(parsing.Entries("/etc/[abc")); err == nil {
+		t.Errorf("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestIgnoredBy_DefaultIgnore(t *testing.T) {
+	setIgnore(t, initialize.DefaultIgnore...)
+	tmpdir := t.TempDir()
+	for _, user := range []string{"alice", "bob"} {
+		path := filepath.Join(tmpdir, "home", user, ".bashrc")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(user), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pattern := filepath.Join(tmpdir, "home", "*", ".bashrc")
+
+	// **/.* swallows every match, the rule is reported
+	if matches, err := NewIgnoreScopes().Expand(pattern); err != nil || len(matches) != 0 {
+		t.Errorf("Expected the default ignore file to leave out every match, got %v, %v", matches, err)
+	}
+	rule, err := NewIgnoreScopes().IgnoredBy(pattern)
+	if err != nil {
+		t.Fatalf("IgnoredBy returned an error: %v", err)
+	}
+	if rule == nil || rule.Pattern != "**/.*" {
+		t.Errorf("Expected **/.* to be reported, got %v", rule)
+	}
+
+	// re-included, the matches are kept and no rule is reported
+	setIgnore(t, append(slices.Clone(initialize.DefaultIgnore), "!"+pattern)...)
+	if matches, err := NewIgnoreScopes().Expand(pattern); err != nil || len(matches) != 2 {
+		t.Errorf("Expected the re-included matches, got %v, %v", matches, err)
+	}
+	if rule, err := NewIgnoreScopes().IgnoredBy(pattern); err != nil || rule != nil {
+		t.Errorf("Expected no rule once a match is kept, got %v, %v", rule, err)
+	}
+	if rule, err := NewIgnoreScopes().IgnoredBy(filepath.Join(tmpdir, "*.missing")); err != nil || rule != nil {
+		t.Errorf("Expected no rule for a pattern matching nothing, got %v, %v", rule, err)
+	}
+}
+
+func TestBuildSnapshot_Pattern(t *testing.T) {
+	setIgnore(t)
+	tmpdir := t.TempDir()
+	pattern := filepath.Join(tmpdir, "*.conf")
+
+	snapshot, err := BuildSnapshot(parsing.Entries(pattern))
+	if err != nil {
+		t.Fatalf("BuildSnapshot returned an error: %v", err)
+	}
+	if len(snapshot.Children) != 0 {
+		t.Errorf("Expected no root without a match, got %+v", snapshot.Children)
+	}
+
+	// files created later are picked up by the next snapshot
+	file := filepath.Join(tmpdir, "a.conf")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err = BuildSnapshot(parsing.Entries(pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Children) != 1 || snapshot.Children[0].Path != file || snapshot.Children[0].Pattern != pattern {
+		t.Errorf("Expected a root for %s recording %s, got %+v", file, pattern, snapshot.Children)
+	}
+}
+
+func TestTracks(t *testing.T) {
+	tests := []struct {
+		entry   string
+		path    string
+		tracked bool
+	}{
+		{"/etc/nginx", "/etc/nginx/nginx.conf", true},
+		{"/etc/nginx", "/etc/nginx-old", false},
+		{"/home/*/.bashrc", "/home/alice/.bashrc", true},
+		{"/home/*/.bashrc", "/home/alice/.profile", false},
+		{"/home/*", "/home/alice/.profile", true},
+		{"/etc/**/*.conf", "/etc/a/b/c.conf", true},
+		{"/etc/**/*.conf", "/etc/a/b", false},
+	}
+	for _, test := range tests {
+		if tracked := Tracks(parsing.TrackEntry{Path: test.entry}, test.path); tracked != test.tracked {
+			t.Errorf("%s on %s: expected %v, got %v", test.entry, test.path, test.tracked, tracked)
+		}
+	}
+}
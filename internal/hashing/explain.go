@@ -0,0 +1,153 @@
+package hashing
+
+import (
+	"magma/internal/ignore"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exclusion is a rule of the ignore files and the number of files it excludes from snapshots
+type Exclusion struct {
+	Rule  ignore.Rule
+	Files int // Files and symlinks skipped because of the rule, those inside ignored directories included
+}
+
+// ExplainIgnored tells which rule decides whether a path is skipped when hashing: a pattern of the
+// ignore file, of a .magmaignore file or of the track entry holding the path. The rules apply in
+// the order they do when a snapshot is taken, see HashEntry.
+//
+// Parameters:
+//   - path: the absolute, clean path.
+//   - isDir: whether the path is a directory.
+//   - entries: the entries of the track file, the first one holding the path applies its patterns.
+//
+// Returns:
+//   - *ignore.Rule: the deciding rule, nil when no rule matches the path.
+//   - bool: true if the path is skipped.
+//   - error: an error if a pattern of the track file or of its entries is not valid.
+func ExplainIgnored(path string, isDir bool, entries []parsing.TrackEntry) (*ignore.Rule, bool, error) {
+	scopes := NewIgnoreScopes()
+	defer printWarnings(scopes)
+	entries, err := scopes.ExpandEntries(entries)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, entry := range entries {
+		root := filepath.Clean(entry.Path)
+		if path != root && !strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			continue
+		}
+
+		rules, err := entryRules(entry, scopes)
+		if err != nil {
+			return nil, false, err
+		}
+		// the .magmaignore files from the tracked path down to the parent of the path
+		if path != root {
+			rel, _ := filepath.Rel(root, filepath.Dir(path))
+			dir := root
+			rules = scopes.extend(rules, dir)
+			for _, name := range strings.Split(rel, "/") {
+				if name == "." {
+					continue
+				}
+				dir = filepath.Join(dir, name)
+				rules = scopes.extend(rules, dir)
+			}
+		}
+		rule, ignored := rules.ExplainUnder(root, path, isDir)
+		return rule, ignored, nil
+	}
+
+	// not tracked, only the ignore files apply
+	rule, ignored := scopes.Scope(filepath.Dir(path)).Explain(path, isDir)
+	return rule, ignored, nil
+}
+
+// PreviewIgnore walks the tracked paths, ignored directories included, and counts the files each
+// rule excludes. Every rule of the ignore file is listed, excluding files or not, followed by the
+// rules of the .magmaignore files and of the track entries that exclude files.
+//
+// Parameters:
+//   - entries: the entries of the track file.
+//
+// Returns:
+//   - []Exclusion: the rules and the files they exclude.
+//   - error: an error if a pattern of the track file or of its entries is not valid.
+func PreviewIgnore(entries []parsing.TrackEntry) ([]Exclusion, error) {
+	scopes := NewIgnoreScopes()
+	defer printWarnings(scopes)
+	entries, err := scopes.ExpandEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &preview{index: map[string]int{}, scopes: scopes}
+	for _, rule := range ignoreRules.Rules() {
+		p.add(rule)
+	}
+	for _, entry := range entries {
+		rules, err := entryRules(entry, scopes)
+		if err != nil {
+			return nil, err
+		}
+		p.root = filepath.Clean(entry.Path)
+		p.walk(entry.Path, rules, nil)
+	}
+	return p.exclusions, nil
+}
+
+// preview counts the files excluded by each rule
+type preview struct {
+	exclusions []Exclusion
+	index      map[string]int // The position of each rule in exclusions
+	root       string         // The tracked path being walked
+	scopes     *IgnoreScopes  // Where the .magmaignore files that cannot be honoured are reported
+}
+
+// add returns the position of a rule in the exclusions, adding it if needed
+func (p *preview) add(rule ignore.Rule) int {
+	key := rule.String() + "\x00" + rule.Base
+	i, ok := p.index[key]
+	if !ok {
+		i = len(p.exclusions)
+		p.index[key] = i
+		p.exclusions = append(p.exclusions, Exclusion{Rule: rule})
+	}
+	return i
+}
+
+// walk counts the files under a path, excludedBy is the rule that ignored one of its parents
+func (p *preview) walk(path string, rules *ignore.Matcher, excludedBy *ignore.Rule) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+
+	if excludedBy == nil {
+		if rule, ignored := rules.ExplainUnder(p.root, path, info.IsDir()); ignored {
+			excludedBy = rule
+		}
+	}
+
+	if !info.IsDir() {
+		if excludedBy != nil {
+			p.exclusions[p.add(*excludedBy)].Files++
+		}
+		return
+	}
+
+	if excludedBy == nil {
+		rules = p.scopes.extend(rules, path)
+	}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		p.walk(filepath.Join(path, file.Name()), rules, excludedBy)
+	}
+}
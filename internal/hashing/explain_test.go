@@ -0,0 +1,92 @@
+package hashing
+
+import (
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the files of a tree under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExplainIgnored(t *testing.T) {
+	setIgnore(t, "*.log")
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"app/.magmaignore": "!keep.log\n",
+		"app/keep.log":     "a",
+		"app/x.bak":        "b",
+		"app/sub/y.log":    "c",
+	})
+	entries := []parsing.TrackEntry{{Path: filepath.Join(dir, "app"), Ignore: []string{"*.bak"}}}
+
+	tests := []struct {
+		path    string
+		source  string
+		ignored bool
+	}{
+		{"app/keep.log", filepath.Join(dir, "app", ".magmaignore"), false},
+		{"app/x.bak", "track entry " + filepath.Join(dir, "app"), true},
+		{"app/sub/y.log", "", true},
+		{"other.bak", "", false},
+	}
+	for _, test := range tests {
+		rule, ignored, err := ExplainIgnored(filepath.Join(dir, test.path), false, entries)
+		if err != nil {
+			t.Fatalf("ExplainIgnored returned an error: %v", err)
+		}
+		source := ""
+		if rule != nil {
+			source = rule.Source
+		}
+		if ignored != test.ignored || source != test.source {
+			t.Errorf("%s: expected ignored %v by %q, got %v by %+v", test.path, test.ignored, test.source, ignored, rule)
+		}
+	}
+}
+
+func TestPreviewIgnore(t *testing.T) {
+	setIgnore(t, "*.log", "cache/", "*.unused")
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.log":         "a",
+		"b.conf":        "b",
+		"cache/c.conf":  "c",
+		"cache/d.log":   "d",
+		"sub/e.log":     "e",
+		"sub/f.bak":     "f",
+		"sub/deep/h.ok": "h",
+	})
+
+	exclusions, err := PreviewIgnore([]parsing.TrackEntry{{Path: dir, Ignore: []string{"*.bak"}}})
+	if err != nil {
+		t.Fatalf("PreviewIgnore returned an error: %v", err)
+	}
+
+	files := map[string]int{}
+	for _, exclusion := range exclusions {
+		files[exclusion.Rule.Pattern] = exclusion.Files
+	}
+	// the files of an ignored directory count for the rule ignoring it
+	expected := map[string]int{"*.log": 2, "cache/": 2, "*.unused": 0, "*.bak": 1}
+	if len(files) != len(expected) {
+		t.Errorf("Expected %d rules, got %v", len(expected), files)
+	}
+	for pattern, count := range expected {
+		if files[pattern] != count {
+			t.Errorf("%s: expected %d file(s), got %d", pattern, count, files[pattern])
+		}
+	}
+}
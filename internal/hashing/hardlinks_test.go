@@ -0,0 +1,70 @@
+package hashing
+
+import (
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashEntry_Hardlinks(t *testing.T) {
+	setIgnore(t)
+	tmpdir := t.TempDir()
+	etc := filepath.Join(tmpdir, "etc")
+	srv := filepath.Join(tmpdir, "srv")
+	for _, dir := range []string{etc, srv} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{"a.conf": "shared", "c.conf": "shared"} {
+		if err := os.WriteFile(filepath.Join(etc, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, link := range []string{filepath.Join(etc, "b.conf"), filepath.Join(srv, "a.conf")} {
+		if err := os.Link(filepath.Join(etc, "a.conf"), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node, err := HashEntry(parsing.TrackEntry{Path: etc})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes := nodesByPath(node)
+	a, b, c := nodes[filepath.Join(etc, "a.conf")], nodes[filepath.Join(etc, "b.conf")], nodes[filepath.Join(etc, "c.conf")]
+	if a.Hardlink != "" || a.Inode == 0 {
+		t.Errorf("Expected the first path of the file to be hashed, got %+v", a)
+	}
+	if b.Hardlink != a.Path || b.Hash != a.Hash || b.Inode != a.Inode {
+		t.Errorf("Expected b.conf to refer to a.conf, got %+v", b)
+	}
+	if c.Hardlink != "" || c.Inode == a.Inode {
+		t.Errorf("Expected a copy not to be a hardlink, got %+v", c)
+	}
+
+	// links are found across the tracked paths of a snapshot, a path tracked twice is not its own link
+	snapshot, err := BuildSnapshot(parsing.Entries(etc, srv, etc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link := nodesByPath(snapshot.Children[1])[filepath.Join(srv, "a.conf")]; link.Hardlink != a.Path || link.Hash != a.Hash {
+		t.Errorf("Expected srv/a.conf to refer to etc/a.conf, got %+v", link)
+	}
+	if again := nodesByPath(snapshot.Children[2])[a.Path]; again.Hardlink != "" {
+		t.Errorf("Expected a path tracked twice not to link to itself, got %+v", again)
+	}
+
+	// the smallest path is the one referred to, whatever the order the paths are tracked in
+	snapshot, err = BuildSnapshot(parsing.Entries(srv, etc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link := nodesByPath(snapshot.Children[0])[filepath.Join(srv, "a.conf")]; link.Hardlink != a.Path {
+		t.Errorf("Expected srv/a.conf to refer to etc/a.conf when tracked first, got %+v", link)
+	}
+	if first := nodesByPath(snapshot.Children[1])[a.Path]; first.Hardlink != "" {
+		t.Errorf("Expected etc/a.conf not to refer to another path, got %+v", first)
+	}
+}
@@ -2,15 +2,19 @@ package hashing
 
 import (
 	"crypto/sha256"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"magma/internal/config"
+	"magma/internal/ignore"
+	"magma/internal/objects"
 	"magma/internal/parsing"
 	"os"
 	"path/filepath"
-
-	"github.com/bmatcuk/doublestar/v4"
+	"slices"
+	"syscall"
+	"time"
 )
 
 // Node represents a node in the file tree, the root node is returned by the HashPath function
@@ -19,13 +23,43 @@ type Node struct {
 	Path     string `json:"path"`     // The path of the file or directory
 	Hash     string `json:"hash"`     // The hash value of this node
 	Children []Node `json:"children"` // Child nodes
+
+	// metadata, not part of the hash. Snapshots taken before it was recorded have no mode
+	Mode  string `json:"mode,omitempty"`  // Type and permission bits, e.g. -rw-r--r--
+	UID   int    `json:"uid,omitempty"`   // Owner user id
+	GID   int    `json:"gid,omitempty"`   // Owner group id
+	Size  int64  `json:"size,omitempty"`  // Size in bytes
+	MTime int64  `json:"mtime,omitempty"` // Modification time, in seconds since the epoch
+
+	Label   string `json:"label,omitempty"`   // The label of the track entry, on the root of a tracked path
+	Pattern string `json:"pattern,omitempty"` // The pattern of the track entry that matched the root of a tracked path
+	Stored  bool   `json:"stored,omitempty"`  // The content is in the object store, see objects.Path
+
+	// on nodes with the hash "skipped", which are hashed as the string "skipped" in their parent
+	Reason string `json:"reason,omitempty"` // Why the path was skipped, e.g. "ignored by /etc/magma/ignore:3: *.log"
+	Device string `json:"device,omitempty"` // The major:minor number of a device node
+
+	// on symlinks, see parsing.SymlinkMode. A followed link carries what it points to
+	Target   string `json:"target,omitempty"`   // The path the link points to, made absolute
+	Dangling bool   `json:"dangling,omitempty"` // The link points to nothing, or loops through other links
+	Outside  bool   `json:"outside,omitempty"`  // The link points outside the tracked paths of the snapshot
+
+	Mount *Mount `json:"mount,omitempty"` // The file system mounted on the path, if it is a mount point
+
+	// on regular files. Snapshots taken before hardlinks were recorded have no inode
+	Inode    uint64 `json:"inode,omitempty"`    // The inode of the file on its device
+	Hardlink string `json:"hardlink,omitempty"` // The lexically smallest path of the snapshot linked to the same file
 }
 
-var ignoreList []string
+// objectsDir holds the content of the files of the entries with store-content
+var objectsDir = config.ObjectsDir
+
+// ignoreRules holds the patterns of the ignore file
+var ignoreRules = ignore.New()
 
 func init() {
 	var err error
-	ignoreList, err = parsing.ReadMagmaFile(config.IgnoreFile)
+	ignoreRules, err = ignore.ReadFile(config.IgnoreFile)
 	if err != nil {
 		fmt.Println("Error reading ignore file:", err)
 	}
@@ -87,6 +121,18 @@ func hashNodeList(nodes []Node) string {
 
 }
 
+// HashChildren returns the hash a directory node should carry given its children.
+// It is used to re-derive directory hashes when validating an existing snapshot.
+//
+// Parameters:
+//   - children: the child nodes of the directory, in the order they were hashed.
+//
+// Returns:
+//   - string: the expected hash of the directory node.
+func HashChildren(children []Node) string {
+	return hashNodeList(children)
+}
+
 func hashString(input string) string {
 	hasher := sha256.New()
 	hasher.Write([]byte(input))
@@ -97,11 +143,16 @@ func hashString(input string) string {
 // HashPath computes a hash for the given file or directory path.
 // It returns a Node struct containing the hash and any child nodes.
 //
-// If the path is a symlink, it resolves the symlink and hashes the resolved path.
+// If the path is a symlink, it resolves the symlink and hashes the resolved path, HashEntry can
+// hash what it points to instead, see parsing.SymlinkMode.
 // If the path is a directory, it recursively hashes all files and directories within it.
-// If the path is a file, it hashes the file content.
+// If the path is a file, it hashes the file content, once for all the hardlinks of the file.
 //
-// The function also checks if the path is in the ignore list and skips hashing if it is.
+// The function also checks if the path is in the ignore list and skips hashing if it is. The
+// .magmaignore files of the directories above the path and of those hashed extend the ignore list
+// for their subtree. Pseudo, memory backed and network file systems mounted under the path are
+// skipped, see SkipsFSType. Skipped paths, sockets, named pipes and devices included, get a node
+// with the hash "skipped" and the reason they were skipped, see Node.
 //
 // Parameters:
 //   - path: The file or directory path to hash.
@@ -110,47 +161,238 @@ func hashString(input string) string {
 //   - Node: A Node struct containing the hash and any child nodes.
 //   - error: An error if any occurred during hashing.
 func HashPath(path string) (node Node, error error) {
+	scopes := NewIgnoreScopes()
+	defer printWarnings(scopes)
+	node, err := newWalker(parsing.TrackEntry{Path: path}, scopes).hashPath(path, scopes.Scope(filepath.Dir(path)), 0)
+	return canonicalHardlinks(node), err
+}
 
-	var localNode Node
+// HashEntry hashes the path of a track entry with its options, see parsing.TrackEntry. The
+// ignore patterns of the entry apply after those of the ignore file and of the .magmaignore files
+// above the path, and before those of the .magmaignore files under it.
+//
+// Parameters:
+//   - entry: the track entry.
+//
+// Returns:
+//   - Node: the root node of the path, carrying the label and the pattern of the entry.
+//   - error: an error if the path cannot be hashed or a pattern of the entry is invalid.
+func HashEntry(entry parsing.TrackEntry) (Node, error) {
+	scopes := NewIgnoreScopes()
+	defer printWarnings(scopes)
+	node, err := hashEntry(entry, map[fileID]Node{}, scopes)
+	return canonicalHardlinks(node), err
+}
 
-	// check if the path is a file
-	fileInfo, err := os.Lstat(path)
+// hashEntry hashes a track entry, files holds the files with several hardlinks already hashed
+// and scopes the rules of the directories above the tracked paths, shared by the entries of a
+// snapshot
+func hashEntry(entry parsing.TrackEntry, files map[fileID]Node, scopes *IgnoreScopes) (Node, error) {
+	rules, err := entryRules(entry, scopes)
 	if err != nil {
-		return localNode, err
+		return Node{}, err
 	}
 
-	// check if the path is in the ignore list
-	for _, ignore := range ignoreList {
-		if match, _ := doublestar.Match(ignore, path); match {
-			localNode.Hash = "skipped"
-			return localNode, nil
+	w := newWalker(entry, scopes)
+	w.files = files
+	if entry.OneFileSystem {
+		info, err := os.Stat(entry.Path)
+		if err != nil {
+			return Node{}, err
 		}
+		w.device = deviceOf(info)
 	}
 
-	// Check if the path is a symlink
-	if fileInfo.Mode()&os.ModeSymlink != 0 {
+	node, err := w.hashPath(entry.Path, rules, 0)
+	node.Label = entry.Label
+	node.Pattern = entry.Pattern
+	return node, err
+}
+
+// entryRules returns the rules applying to the path of a track entry: those of the ignore file
+// and of the .magmaignore files above the path, followed by the ignore patterns of the entry
+func entryRules(entry parsing.TrackEntry, scopes *IgnoreScopes) (*ignore.Matcher, error) {
+	rules := scopes.Scope(filepath.Dir(entry.Path))
+	if len(entry.Ignore) == 0 {
+		return rules, nil
+	}
+
+	rules = rules.Clone()
+	for _, pattern := range entry.Ignore {
+		if err := rules.AddFrom("track entry "+entry.Path, 0, entry.Path, pattern); err != nil {
+			return nil, fmt.Errorf("track entry %s: %w", entry.Path, err)
+		}
+	}
+	return rules, nil
+}
+
+// walker hashes the tree of a track entry
+type walker struct {
+	entry  parsing.TrackEntry
+	root   string           // The tracked path, the directories above it cannot ignore what is under it
+	device uint64           // The device of the tracked path, for one-file-system
+	dirs   map[fileID]bool  // The directories being hashed, so followed symlinks cannot loop
+	files  map[fileID]Node  // The first node of each file with several hardlinks, so it is read once
+	mounts map[string]Mount // The mounts of the process, by mount point
+	links  int              // The followed symlinks above the path being hashed
+	scopes *IgnoreScopes    // Where the .magmaignore files that cannot be honoured are reported
+}
+
+// newWalker returns a walker for a track entry, knowing the mounts of the process
+func newWalker(entry parsing.TrackEntry, scopes *IgnoreScopes) *walker {
+	mounts, err := readMounts(mountInfo)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Warning: mount points unknown:", err)
+	}
+	return &walker{entry: entry, root: filepath.Clean(entry.Path), dirs: map[fileID]bool{}, files: map[fileID]Node{}, mounts: mounts, scopes: scopes}
+}
 
-		// Handle the symlink
-		resolvedPath, err := os.Readlink(path)
+// CountFiles counts the regular files under the path of a track entry and their size without
+// reading them. Like HashEntry, it does not descend into the pseudo, memory backed and network
+// file systems mounted under the path, see SkipsFSType, nor with one-file-system into other file
+// systems. The ignore list does not apply, symlinks are not followed and unreadable directories
+// are skipped.
+//
+// Parameters:
+//   - entry: the track entry, its path is counted.
+//
+// Returns:
+//   - int: the number of regular files.
+//   - int64: their size in bytes.
+func CountFiles(entry parsing.TrackEntry) (int, int64) {
+	info, err := os.Lstat(entry.Path)
+	if err != nil {
+		return 0, 0
+	}
+	w := newWalker(entry, nil)
+	w.device = deviceOf(info)
+
+	files, size := 0, int64(0)
+	filepath.WalkDir(w.root, func(path string, dirEntry fs.DirEntry, err error) error {
 		if err != nil {
-			return localNode, fmt.Errorf("failed to resolve symlink: %w", err)
+			// an unreadable directory is skipped, the walk goes on
+			return nil
+		}
+		if dirEntry.IsDir() {
+			if path == w.root {
+				return nil
+			}
+			if mount := w.mountAt(path, false); mount != nil && SkipsFSType(mount.FSType) {
+				return filepath.SkipDir
+			}
+			if info, err := dirEntry.Info(); err == nil && entry.OneFileSystem && deviceOf(info) != w.device {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		// If the resolved path is relative, make it absolute based on the symlink's parent directory
-		if !filepath.IsAbs(resolvedPath) {
-			resolvedPath = filepath.Join(filepath.Dir(path), resolvedPath)
+		if !dirEntry.Type().IsRegular() {
+			return nil
 		}
+		if info, err := dirEntry.Info(); err == nil {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
 
-		// hash the resolved path string
-		hash := hashString(resolvedPath)
-		localNode.Hash = hash
-		localNode.Children = nil
-		localNode.Path = path
-		return localNode, nil
+// fileID identifies a file across paths
+type fileID struct {
+	device uint64
+	inode  uint64
+}
 
+// hashPath hashes a path with the ignore rules of its parent directory, depth is its level under
+// the tracked path
+func (w *walker) hashPath(path string, rules *ignore.Matcher, depth int) (Node, error) {
+
+	// check if the path is a file
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		return Node{}, err
 	}
 
+	// symlinks record the path they point to, and with follow or both, what is there is hashed
+	var target string
+	dangling := false
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		if target, err = readTarget(path); err != nil {
+			return Node{}, err
+		}
+		// a link to nothing, or looping through other links, is dangling and kept as a link
+		pointed, err := os.Stat(path)
+		dangling = err != nil
+		if !dangling && w.entry.Follows() {
+			fileInfo = pointed
+		}
+	}
+
+	// check if the path is in the ignore list
+	if rule, ignored := rules.ExplainUnder(w.root, path, fileInfo.IsDir()); ignored {
+		return skipped(path, "ignored by "+rule.String()), nil
+	}
+
+	// a link that is not followed is hashed as the path it points to
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		node := Node{Path: path, Hash: hashString(target), Target: target, Dangling: dangling}
+		recordMetadata(&node, fileInfo)
+		return node, nil
+	}
+
+	// a mount point records what is mounted, pseudo and network file systems mounted under the
+	// tracked path are skipped
+	mount := w.mountAt(path, target != "")
+	if mount != nil && depth > 0 && SkipsFSType(mount.FSType) {
+		node := skipped(path, mount.FSType+" file system")
+		node.Mount = mount
+		return node, nil
+	}
+
+	if target != "" {
+		w.links++
+		defer func() { w.links-- }()
+	}
+	node, err := w.hashContent(path, fileInfo, rules, depth)
+	if err != nil {
+		return node, err
+	}
+	node.Mount = mount
+	if target == "" {
+		return node, nil
+	}
+	// a followed link records where it points, with both it is part of the hash
+	node.Target = target
+	if w.entry.Symlinks == parsing.SymlinksBoth && node.Hash != "skipped" {
+		node.Hash = HashLink(target, node.Hash)
+	}
+	return node, nil
+}
+
+// hashContent hashes a directory, or a file, that is not a symlink
+func (w *walker) hashContent(path string, fileInfo os.FileInfo, rules *ignore.Matcher, depth int) (Node, error) {
+
+	var localNode Node
+
 	if fileInfo.IsDir() {
+		id := fileID{device: deviceOf(fileInfo), inode: inodeOf(fileInfo)}
+
+		// directories past max-depth, on another file system with one-file-system, or already
+		// being hashed through a followed symlink are not descended into
+		switch {
+		case w.entry.MaxDepth > 0 && depth >= w.entry.MaxDepth:
+			return skipped(path, "max-depth"), nil
+		case w.entry.OneFileSystem && id.device != w.device:
+			return skipped(path, "other file system"), nil
+		case w.dirs[id]:
+			return skipped(path, "symlink loop"), nil
+		}
+		w.dirs[id] = true
+		defer delete(w.dirs, id)
+
 		var nodes []Node
+		// the .magmaignore file of the directory applies to its subtree
+		rules = w.scopes.extend(rules, path)
 		// get all files and directories in the given path
 		files, err := os.ReadDir(path)
 		if err != nil {
@@ -158,10 +400,14 @@ func HashPath(path string) (node Node, error error) {
 		}
 		for _, file := range files {
 			// recursively hash all files in the directory
-			child, err := HashPath(path + "/" + file.Name())
+			child, err := w.hashPath(path+"/"+file.Name(), rules, depth+1)
 			if err != nil {
 				return localNode, err
 			}
+			// with omit-skipped, skipped entries are left out as if they did not exist
+			if w.entry.OmitSkipped && child.Hash == "skipped" {
+				continue
+			}
 			nodes = append(nodes, child)
 		}
 		// hash all the hashes of the files in the directory
@@ -169,89 +415,344 @@ func HashPath(path string) (node Node, error error) {
 		localNode.Hash = nodeHash
 		localNode.Children = nodes
 		localNode.Path = path
+		recordMetadata(&localNode, fileInfo)
 		return localNode, nil
 
 	} else if fileInfo.Mode()&os.ModeType != 0 {
-		localNode.Hash = "skipped"
-		return localNode, nil
+		// sockets, named pipes and devices have no content to hash
+		node := skipped(path, fileType(fileInfo.Mode()))
+		if fileInfo.Mode()&os.ModeDevice != 0 {
+			node.Device = deviceNumber(fileInfo)
+		}
+		return node, nil
 
 	}
 
-	// hash the file
-	hash, err := hashFile(path)
+	// a file with several hardlinks is read once, the other paths refer to the first one until
+	// canonicalHardlinks picks the smallest. Paths reached through followed symlinks are not links
+	// of their own
+	id := fileID{device: deviceOf(fileInfo), inode: inodeOf(fileInfo)}
+	linked := w.links == 0 && linksOf(fileInfo) > 1
+	first, seen := w.files[id]
+	if linked && seen && first.Path != path {
+		localNode.Hardlink = first.Path
+	}
+	// the content is stored anyway with store-content if the first path did not store it
+	if linked && seen && (first.Stored || !w.entry.StoreContent) {
+		localNode.Hash = first.Hash
+		localNode.Stored = w.entry.StoreContent
+		localNode.Path = path
+		recordMetadata(&localNode, fileInfo)
+		localNode.Inode = id.inode
+		return localNode, nil
+	}
+
+	// hash the file, keeping its content with store-content
+	var hash string
+	var err error
+	if w.entry.StoreContent {
+		hash, err = objects.Put(objectsDir, path)
+		localNode.Stored = true
+	} else {
+		hash, err = hashFile(path)
+	}
 	if err != nil {
 		return localNode, err
 	}
 	localNode.Hash = hash
 	localNode.Children = nil
 	localNode.Path = path
+	recordMetadata(&localNode, fileInfo)
+	localNode.Inode = id.inode
+	if linked && !seen {
+		w.files[id] = localNode
+	}
 	return localNode, nil
 
 }
 
-// SnapShot creates a snapshot of the given tracked paths and saves it as a JSON file.
-// The snapshot includes the hash of each tracked path and their hierarchical structure.
+// canonicalHardlinks makes the lexically smallest path of each file with several hardlinks the
+// one the other paths refer to, so the recorded link does not depend on the order the paths were
+// tracked or walked in. The hashes are the same whichever path was read.
+func canonicalHardlinks(root Node) Node {
+	byPath := map[string][]*Node{}  // A path tracked twice has several nodes
+	linked := map[string][]string{} // The paths referring to each path read
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if node.Inode != 0 {
+			byPath[node.Path] = append(byPath[node.Path], node)
+		}
+		if node.Hardlink != "" {
+			linked[node.Hardlink] = append(linked[node.Hardlink], node.Path)
+		}
+		for i := range node.Children {
+			walk(&node.Children[i])
+		}
+	}
+	walk(&root)
+
+	for first, paths := range linked {
+		paths = append(paths, first)
+		canonical := slices.Min(paths)
+		for _, path := range paths {
+			for _, node := range byPath[path] {
+				node.Hardlink = canonical
+				if path == canonical {
+					node.Hardlink = ""
+				}
+			}
+		}
+	}
+	return root
+}
+
+// mountAt returns the file system mounted on a path, nil if it is not a mount point. Paths reached
+// through followed symlinks are looked up by their real path
+func (w *walker) mountAt(path string, linked bool) *Mount {
+	if len(w.mounts) == 0 {
+		return nil
+	}
+	if linked || w.links > 0 {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			path = real
+		}
+	}
+	if mount, ok := w.mounts[path]; ok {
+		return &mount
+	}
+	return nil
+}
+
+// readTarget returns the path a symlink points to, made absolute from the directory of the link
+func readTarget(path string) (string, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink: %w", err)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return target, nil
+}
+
+// HashLink returns the hash of a symlink followed with symlinks=both: the path it points to
+// combined with the hash of what is there, the content of a file or the children of a directory.
 //
 // Parameters:
-//   - SnapshotPath: The directory where the snapshot JSON file will be saved.
-//   - trackPaths: A list of paths to be tracked and hashed.
-//   - tags: Optional tags to be appended to the snapshot filename.
+//   - target: the absolute path the link points to, see Node.Target.
+//   - content: the hash of what the link points to.
 //
 // Returns:
-//   - error: An error if any occurs during the snapshot creation or file writing process.
-func SnapShot(SnapshotPath string, trackPaths []string, tags ...string) error {
+//   - string: the hash of the link node.
+func HashLink(target string, content string) string {
+	return hashString(hashString(target) + content)
+}
+
+// skipped returns the node of a path that is not hashed
+func skipped(path string, reason string) Node {
+	return Node{Path: path, Hash: "skipped", Reason: reason}
+}
+
+// fileType names the type of a file that is neither a regular file, a directory nor a symlink
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	default:
+		return "irregular file"
+	}
+}
+
+// deviceNumber returns the major:minor number of a device node, in the encoding of Linux
+func deviceNumber(fileInfo os.FileInfo) string {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	rdev := uint64(stat.Rdev)
+	major := (rdev>>8)&0xfff | (rdev>>32)&^0xfff
+	minor := rdev&0xff | (rdev>>12)&^0xff
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// deviceOf returns the device holding a file
+func deviceOf(fileInfo os.FileInfo) uint64 {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev)
+	}
+	return 0
+}
 
-	// for each tracked path, create a root node
+// inodeOf returns the inode of a file
+func inodeOf(fileInfo os.FileInfo) uint64 {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
+
+// linksOf returns the number of hardlinks of a file
+func linksOf(fileInfo os.FileInfo) uint64 {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
+
+// recordMetadata copies the permissions, ownership, size and modification time of a file into its node
+func recordMetadata(node *Node, fileInfo os.FileInfo) {
+	node.Mode = fileInfo.Mode().String()
+	node.Size = fileInfo.Size()
+	node.MTime = fileInfo.ModTime().Unix()
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		node.UID = int(stat.Uid)
+		node.GID = int(stat.Gid)
+	}
+}
+
+// BuildSnapshot hashes the given tracked paths and returns the resulting snapshot without
+// writing it to disk.
+//
+// Parameters:
+//   - entries: the entries of the track file, each path is hashed with its options.
+//   - tags: Optional tags to be recorded in the snapshot metadata.
+//
+// Returns:
+//   - Snapshot: the snapshot, with its id and creation time set.
+//   - error: An error if any of the tracked paths could not be hashed.
+func BuildSnapshot(entries []parsing.TrackEntry, tags ...string) (Snapshot, error) {
+
+	// patterns are expanded to the paths they match now, the rules of the directories above the
+	// tracked paths are read once for all of them
+	scopes := NewIgnoreScopes()
+	defer printWarnings(scopes)
+	entries, err := scopes.ExpandEntries(entries)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	// for each tracked path, create a root node, a file linked from several tracked paths is read once
 	nodes := []Node{}
+	files := map[fileID]Node{}
 
-	for _, path := range trackPaths {
-		node, err := HashPath(path)
+	for _, entry := range entries {
+		node, err := hashEntry(entry, files, scopes)
 		if err != nil {
-			return err
+			return Snapshot{}, err
 		}
 		nodes = append(nodes, node)
 	}
-
-	root := Node{
-		Path:     "root",
-		Hash:     hashNodeList(nodes),
-		Children: nodes,
+	markOutside(nodes)
+	nodes = canonicalHardlinks(Node{Children: nodes}).Children
+
+	root := Snapshot{
+		Node: Node{
+			Path:     "root",
+			Hash:     hashNodeList(nodes),
+			Children: nodes,
+		},
+		Created: time.Now().UTC(),
+		Tags:    tags,
 	}
+	root.ID = NewSnapshotID(root.Created, root.Hash)
 
-	jsonData, err := json.MarshalIndent(root, "", "  ")
-	if err != nil {
-		return err
+	return root, nil
+}
+
+// SnapShot creates a snapshot of the given tracked paths and saves it as a JSON file, see
+// TakeSnapshot. The tags listed in unique_tags of the config file move to the new snapshot.
+//
+// Parameters:
+//   - SnapshotPath: The directory where the snapshot JSON file will be saved.
+//   - entries: the entries of the track file, each path is hashed with its options.
+//   - tags: Optional tags to be recorded in the snapshot metadata.
+//
+// Returns:
+//   - Snapshot: the snapshot, its File is set once it is written, even if a later step fails.
+//   - error: An error if any occurs during the snapshot creation or file writing process.
+func SnapShot(SnapshotPath string, entries []parsing.TrackEntry, tags ...string) (Snapshot, error) {
+	root, _, err := TakeSnapshot(SnapshotPath, entries, SnapshotOptions{Tags: tags, UniqueTags: config.VariableConfig.UniqueTags})
+	if root.File != "" {
+		fmt.Println("Snapshot saved to", root.File)
 	}
+	return root, err
+}
 
-	// truncate the hash to 8 characters
-	fileName := root.Hash
-	if len(fileName) > 8 {
-		fileName = fileName[:8]
+// SnapshotOptions configures TakeSnapshot
+type SnapshotOptions struct {
+	Tags            []string // Tags recorded in the snapshot metadata
+	UniqueTags      []string // Tags removed from every other snapshot once the new one is written
+	SkipIfUnchanged bool     // Do not write a snapshot identical to the latest one, metadata included
+}
+
+// TakeSnapshot creates a snapshot of the given tracked paths and saves it as a JSON file, the
+// steps shared by snap, watch, the daemon and the API. The pre-snap.d hooks run before the paths
+// are hashed. Once the snapshot is written the unique tags move to it, a warning is printed for
+// each dangling or outside symlink and the post-snap.d hooks run, see RunPreHooks and RunPostHooks.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - entries: the entries of the track file, each path is hashed with its options.
+//   - opts: the tags of the snapshot and whether an unchanged one is skipped.
+//
+// Returns:
+//   - Snapshot: the new snapshot, its File is set once written, or the latest one if skipped.
+//   - bool: true once the snapshot is written, even if an error follows, false if it was skipped
+//     because nothing changed since the latest one or could not be written.
+//   - error: an error if the tracked paths cannot be hashed or the snapshot cannot be written, or,
+//     with a written snapshot, if a unique tag cannot be moved or a post-snap.d hook fails.
+func TakeSnapshot(dir string, entries []parsing.TrackEntry, opts SnapshotOptions) (Snapshot, bool, error) {
+
+	// pre-snap.d hooks may prepare the tracked paths
+	if err := RunPreHooks(dir, opts.Tags); err != nil {
+		return Snapshot{}, false, err
 	}
 
-	// if there are tags, append them to the snapshot filename
-	if len(tags) > 0 {
-		for _, tag := range tags {
-			fileName += "_" + tag
+	// the previous snapshot is only needed to skip an unchanged one and by the post-snap.d hooks
+	var previous *Snapshot
+	if opts.SkipIfUnchanged || HasPostHooks() {
+		var err error
+		if previous, err = LatestSnapshot(dir); err != nil {
+			return Snapshot{}, false, err
 		}
 	}
 
-	savePath := filepath.Join(SnapshotPath, fileName)
-	savePath = savePath + ".json"
-
-	// Write the JSON to a file
-	file, err := os.Create(savePath)
+	root, err := BuildSnapshot(entries, opts.Tags...)
 	if err != nil {
-		return err
+		return root, false, err
 	}
-	defer file.Close()
 
-	_, err = file.Write(jsonData)
+	// metadata changes count as changes, only an identical tree is skipped
+	if opts.SkipIfUnchanged && previous != nil && len(Diff(previous.Node, root.Node)) == 0 {
+		return *previous, false, nil
+	}
+
+	// Write the JSON to a file named after the snapshot id
+	root.File, err = WriteSnapshot(dir, root)
 	if err != nil {
-		return err
+		return root, false, err
+	}
+
+	// unique tags move only once the new snapshot carrying them is written
+	var errs []error
+	for _, tag := range root.Tags {
+		if slices.Contains(opts.UniqueTags, tag) {
+			if err := ClearTag(dir, tag, root.ID); err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
 
-	fmt.Println("Snapshot saved to", savePath)
+	for _, link := range Links(root.Node) {
+		fmt.Println("Warning:", link)
+	}
 
-	return nil
+	errs = append(errs, RunPostHooks(dir, root, previous))
+	return root, true, errors.Join(errs...)
 }
@@ -4,10 +4,17 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"magma/internal/ignore"
+	"magma/internal/initialize"
+	"magma/internal/objects"
+	"magma/internal/parsing"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestHashFile(t *testing.T) {
@@ -188,9 +195,22 @@ func TestHashPath_FileNotFound(t *testing.T) {
 	}
 }
 
+// setIgnore replaces the patterns of the ignore file for the duration of a test
+func setIgnore(t *testing.T, patterns ...string) {
+	previous := ignoreRules
+	t.Cleanup(func() { ignoreRules = previous })
+
+	ignoreRules = ignore.New()
+	for _, pattern := range patterns {
+		if err := ignoreRules.Add("/", pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestHashPath_IgnoreList_StartsWith(t *testing.T) {
 	// Add a pattern to the ignore list
-	ignoreList = append(ignoreList, "**/ignore_*")
+	setIgnore(t, "**/ignore_*")
 
 	// Create a temporary file
 	tmpfile, err := os.CreateTemp("", "ignore_me.txt")
@@ -221,7 +241,7 @@ func TestHashPath_IgnoreList_EndsWith(t *testing.T) {
 	defer os.Remove(tmpfile.Name()) // clean up
 
 	// Add a pattern to the ignore list
-	ignoreList = append(ignoreList, "**/*.log")
+	setIgnore(t, "**/*.log")
 
 	// Call the HashPath function
 	node, err := HashPath(tmpfile.Name())
@@ -241,8 +261,7 @@ func TestHashPath_IgnoreList_Directory(t *testing.T) {
 	tmpdir := t.TempDir()
 
 	// Add a pattern to the ignore list
-	ignoreList = append(ignoreList, tmpdir+"/**")
-	defer os.RemoveAll(tmpdir) // clean up
+	setIgnore(t, tmpdir)
 
 	// Create a temporary file in the directory
 	tmpfile, err := os.CreateTemp(tmpdir, "example")
@@ -264,8 +283,8 @@ func TestHashPath_IgnoreList_Directory(t *testing.T) {
 }
 
 func TestHashPath_IgnoreList_Subdirectory(t *testing.T) {
-	// Add a pattern to the ignore list
-	ignoreList = append(ignoreList, "**/ignore_subdir/*")
+	// Add a pattern to the ignore list, matching the content of the directory but not itself
+	setIgnore(t, "**/ignore_subdir/*")
 
 	// Create a temporary directory
 	tmpdir, err := os.MkdirTemp("", "example")
@@ -293,9 +312,355 @@ func TestHashPath_IgnoreList_Subdirectory(t *testing.T) {
 		t.Fatalf("HashPath returned an error: %v", err)
 	}
 
-	// Check if the subdirectory was skipped
-	if node.Hash != "skipped" {
-		t.Errorf("HashPath did not skip the subdirectory, returned %s", node.Hash)
+	// Check if the file of the subdirectory was skipped
+	if node.Hash == "skipped" || len(node.Children) != 1 || node.Children[0].Hash != "skipped" {
+		t.Errorf("HashPath did not skip the content of the subdirectory, returned %+v", node)
+	}
+}
+
+func TestHashPath_IgnoreList_Negation(t *testing.T) {
+	tmpdir := t.TempDir()
+	for _, name := range []string{"main.conf", "other.conf"} {
+		if err := os.WriteFile(filepath.Join(tmpdir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ignore the directory content except main.conf
+	setIgnore(t, tmpdir+"/*", "!"+tmpdir+"/main.conf")
+
+	node, err := HashPath(tmpdir)
+	if err != nil {
+		t.Fatalf("HashPath returned an error: %v", err)
+	}
+	if len(node.Children) != 2 || node.Children[0].Path != filepath.Join(tmpdir, "main.conf") || node.Children[1].Hash != "skipped" {
+		t.Errorf("Expected only main.conf to be hashed, got %+v", node.Children)
+	}
+}
+
+func TestHashPath_MagmaIgnore(t *testing.T) {
+	// tmp/app/.magmaignore ignores *.cache and re-includes keep.log, ignored by the global file
+	tmpdir := t.TempDir()
+	app := filepath.Join(tmpdir, "app")
+	other := filepath.Join(tmpdir, "other")
+	files := map[string]string{
+		filepath.Join(app, ".magmaignore"):        "*.cache\n!keep.log\n",
+		filepath.Join(app, "a.cache"):             "a",
+		filepath.Join(app, "keep.log"):            "b",
+		filepath.Join(app, "drop.log"):            "c",
+		filepath.Join(app, "sub", ".magmaignore"): "!b.cache\n",
+		filepath.Join(app, "sub", "b.cache"):      "d",
+		filepath.Join(other, "c.cache"):           "e",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setIgnore(t, "*.log")
+
+	node, err := HashPath(tmpdir)
+	if err != nil {
+		t.Fatalf("HashPath returned an error: %v", err)
+	}
+
+	hashed := map[string]bool{}
+	var walk func(node Node)
+	walk = func(node Node) {
+		if node.Hash != "skipped" {
+			hashed[node.Path] = true
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	expected := map[string]bool{
+		filepath.Join(app, "a.cache"):        false, // ignored by app/.magmaignore
+		filepath.Join(app, "keep.log"):       true,  // re-included by app/.magmaignore
+		filepath.Join(app, "drop.log"):       false, // ignored by the global file
+		filepath.Join(app, "sub", "b.cache"): true,  // re-included by the deeper file
+		filepath.Join(other, "c.cache"):      true,  // outside the subtree of app
+	}
+	for path, want := range expected {
+		if hashed[path] != want {
+			t.Errorf("Expected %s hashed %v, got %v", path, want, hashed[path])
+		}
+	}
+
+	// tracking a directory inside the subtree applies the file of its parent
+	x, b := filepath.Join(app, "sub", "x.cache"), filepath.Join(app, "sub", "b.cache")
+	if !NewIgnoreScopes().IsIgnored(x, x, false) || NewIgnoreScopes().IsIgnored(b, b, false) {
+		t.Errorf("Expected IsIgnored to apply the .magmaignore files of the parent directories")
+	}
+	node, err = HashPath(filepath.Join(app, "a.cache"))
+	if err != nil || node.Hash != "skipped" {
+		t.Errorf("Expected a tracked file to be skipped by the .magmaignore of its directory, got %+v, %v", node, err)
+	}
+}
+
+func TestHashEntry_DefaultIgnoreUnderHiddenDirectory(t *testing.T) {
+	setIgnore(t, initialize.DefaultIgnore...)
+	tmpdir := t.TempDir()
+	keys := filepath.Join(tmpdir, ".ssh", "authorized_keys")
+	app := filepath.Join(tmpdir, "home", ".config", "app")
+	for _, path := range []string{keys, filepath.Join(app, "x.conf"), filepath.Join(app, ".secret")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a tracked path under a hidden directory is hashed, **/.* only applies under it
+	node, err := HashEntry(parsing.TrackEntry{Path: keys})
+	if err != nil || node.Hash == "skipped" {
+		t.Errorf("Expected %s to be hashed, got %+v, %v", keys, node, err)
+	}
+	if NewIgnoreScopes().IsIgnored(keys, keys, false) {
+		t.Errorf("Expected %s not to be ignored when tracked", keys)
+	}
+
+	node, err = HashEntry(parsing.TrackEntry{Path: app})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := nodesByPath(node)
+	if conf := nodes[filepath.Join(app, "x.conf")]; node.Hash == "skipped" || conf.Hash == "skipped" {
+		t.Errorf("Expected x.conf to be hashed, got %+v", conf)
+	}
+	if secret := nodes[filepath.Join(app, ".secret")]; secret.Hash != "skipped" {
+		t.Errorf("Expected the hidden file under the tracked path to be skipped, got %+v", secret)
+	}
+
+	// tracking the hidden directory itself still names a hidden path
+	if node, err := HashEntry(parsing.TrackEntry{Path: filepath.Dir(keys)}); err != nil || node.Hash != "skipped" {
+		t.Errorf("Expected the hidden tracked directory to be skipped, got %+v, %v", node, err)
+	}
+}
+
+func TestHashEntry_IgnoreFileHashed(t *testing.T) {
+	setIgnore(t, initialize.DefaultIgnore...)
+	tmpdir := t.TempDir()
+	ignoreFile := filepath.Join(tmpdir, ignore.FileName)
+	logFile := filepath.Join(tmpdir, "app.log")
+	for path, content := range map[string]string{ignoreFile: "*.log\n", logFile: "log"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the ignore file is hashed although **/.* matches it, its rules apply
+	node, err := HashEntry(parsing.TrackEntry{Path: tmpdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := nodesByPath(node)
+	if nodes[ignoreFile].Hash == "" || nodes[ignoreFile].Hash == "skipped" {
+		t.Errorf("Expected %s to be hashed, got %+v", ignoreFile, nodes[ignoreFile])
+	}
+	if nodes[logFile].Hash != "skipped" {
+		t.Errorf("Expected %s to be ignored, got %+v", logFile, nodes[logFile])
+	}
+
+	// tampering with it shows as a change
+	if err := os.WriteFile(ignoreFile, []byte("*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := HashEntry(parsing.TrackEntry{Path: tmpdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(node, tampered); !slices.ContainsFunc(changes, func(c Change) bool { return c.Path == ignoreFile }) {
+		t.Errorf("Expected a change of %s, got %+v", ignoreFile, changes)
+	}
+
+	// an ignore file others can write is not honoured
+	if err := os.Chmod(ignoreFile, 0666); err != nil {
+		t.Fatal(err)
+	}
+	node, err = HashEntry(parsing.TrackEntry{Path: tmpdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log := nodesByPath(node)[logFile]; log.Hash == "skipped" {
+		t.Errorf("Expected the rules of a world-writable %s to be left out, got %+v", ignore.FileName, log)
+	}
+}
+
+// nodesByPath indexes the nodes of a tree by path
+func nodesByPath(node Node) map[string]Node {
+	nodes := map[string]Node{node.Path: node}
+	for _, child := range node.Children {
+		for path, n := range nodesByPath(child) {
+			nodes[path] = n
+		}
+	}
+	return nodes
+}
+
+func TestHashEntry_Options(t *testing.T) {
+	setIgnore(t)
+	tmpdir := t.TempDir()
+	for path, content := range map[string]string{
+		"a.conf":          "a",
+		"a.bak":           "b",
+		"sub/b.conf":      "c",
+		"sub/deep/c.conf": "d",
+		"outside/d.conf":  "e",
+	} {
+		path = filepath.Join(tmpdir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := filepath.Join(tmpdir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.conf", "a.bak", "sub"} {
+		if err := os.Rename(filepath.Join(tmpdir, name), filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(tmpdir, "outside"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// max-depth, ignore and label
+	node, err := HashEntry(parsing.TrackEntry{Path: root, MaxDepth: 2, Ignore: []string{"*.bak"}, Label: "web"})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes := nodesByPath(node)
+	if node.Label != "web" {
+		t.Errorf("Expected the label on the root, got %q", node.Label)
+	}
+	if bak := nodes[filepath.Join(root, "a.bak")]; bak.Hash != "skipped" || bak.Reason != "ignored by track entry "+root+": *.bak" {
+		t.Errorf("Expected a.bak to be ignored by the entry, got %+v", bak)
+	}
+	if _, ok := nodes[filepath.Join(root, "sub", "b.conf")]; !ok {
+		t.Errorf("Expected sub/b.conf to be within max-depth")
+	}
+	if deep := nodes[filepath.Join(root, "sub", "deep")]; deep.Hash != "skipped" || deep.Reason != "max-depth" || len(deep.Children) != 0 {
+		t.Errorf("Expected sub/deep to be skipped past max-depth, got %+v", deep)
+	}
+	if link := nodes[filepath.Join(root, "link")]; len(link.Children) != 0 {
+		t.Errorf("Expected the symlink not to be followed")
+	}
+
+	// the entry ignore patterns only apply to the entry
+	node, err = HashPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bak, ok := nodesByPath(node)[filepath.Join(root, "a.bak")]; !ok || bak.Hash == "skipped" {
+		t.Errorf("Expected a.bak to be hashed without the entry options")
+	}
+
+	// follow-symlinks hashes what the link points to
+	node, err = HashEntry(parsing.TrackEntry{Path: root, Symlinks: parsing.SymlinksFollow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nodesByPath(node)[filepath.Join(root, "link", "d.conf")]; !ok {
+		t.Errorf("Expected the directory behind the symlink to be hashed")
+	}
+}
+
+func TestHashEntry_Skipped(t *testing.T) {
+	tmpdir := t.TempDir()
+	setIgnore(t, "*.log")
+	if err := os.WriteFile(filepath.Join(tmpdir, "app.conf"), []byte("conf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "app.log"), []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(tmpdir, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// skipped entries are recorded with their path and reason
+	node, err := HashEntry(parsing.TrackEntry{Path: tmpdir})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes := nodesByPath(node)
+	if log := nodes[filepath.Join(tmpdir, "app.log")]; log.Hash != "skipped" || log.Reason != "ignored by *.log" {
+		t.Errorf("Expected app.log to be skipped by *.log, got %+v", log)
+	}
+	if fifo := nodes[filepath.Join(tmpdir, "fifo")]; fifo.Hash != "skipped" || fifo.Reason != "named pipe" {
+		t.Errorf("Expected fifo to be skipped as a named pipe, got %+v", fifo)
+	}
+	if node.Hash != hashNodeList(node.Children) || len(node.Children) != 3 {
+		t.Errorf("Expected the skipped entries in the hash of the directory, got %+v", node)
+	}
+
+	// omit-skipped leaves them out of the tree and of the hash
+	omitted, err := HashEntry(parsing.TrackEntry{Path: tmpdir, OmitSkipped: true})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	if len(omitted.Children) != 1 || omitted.Children[0].Path != filepath.Join(tmpdir, "app.conf") {
+		t.Fatalf("Expected only app.conf with omit-skipped, got %+v", omitted.Children)
+	}
+	if omitted.Hash != hashNodeList(omitted.Children) || omitted.Hash == node.Hash {
+		t.Errorf("Expected the skipped entries out of the hash with omit-skipped")
+	}
+
+	// the tracked path itself is always recorded
+	root, err := HashEntry(parsing.TrackEntry{Path: filepath.Join(tmpdir, "fifo"), OmitSkipped: true})
+	if err != nil || root.Hash != "skipped" || root.Path != filepath.Join(tmpdir, "fifo") {
+		t.Errorf("Expected the skipped tracked path to be recorded, got %+v, %v", root, err)
+	}
+}
+
+func TestHashPath_Device(t *testing.T) {
+	info, err := os.Lstat("/dev/null")
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		t.Skip("/dev/null is not a character device")
+	}
+	node, err := HashPath("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Hash != "skipped" || node.Reason != "character device" || node.Device != "1:3" {
+		t.Errorf("Expected /dev/null to be skipped as character device 1:3, got %+v", node)
+	}
+}
+
+func TestHashEntry_StoreContent(t *testing.T) {
+	setIgnore(t)
+	previous := objectsDir
+	objectsDir = t.TempDir()
+	t.Cleanup(func() { objectsDir = previous })
+
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "a.conf")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := HashEntry(parsing.TrackEntry{Path: tmpdir, StoreContent: true})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	file := nodesByPath(node)[path]
+	hash, _ := hashFile(path)
+	if !file.Stored || file.Hash != hash {
+		t.Errorf("Expected the file to be stored under its usual hash, got %+v", file)
+	}
+	if !objects.Has(objectsDir, file.Hash) {
+		t.Errorf("Expected the content in the object store")
 	}
 }
 
@@ -324,7 +689,7 @@ func TestSnapShot(t *testing.T) {
 	}
 
 	// Call the SnapShot function
-	err = SnapShot(snapshotDir, []string{tmpfile.Name()})
+	_, err = SnapShot(snapshotDir, parsing.Entries(tmpfile.Name()))
 	if err != nil {
 		t.Fatalf("SnapShot returned an error: %v", err)
 	}
@@ -386,7 +751,7 @@ func TestSnapShot_WithTags(t *testing.T) {
 	}
 
 	// Call the SnapShot function with tags
-	err = SnapShot(snapshotDir, []string{tmpfile.Name()}, "tag1", "tag2")
+	_, err = SnapShot(snapshotDir, parsing.Entries(tmpfile.Name()), "tag1", "tag2")
 	if err != nil {
 		t.Fatalf("SnapShot returned an error: %v", err)
 	}
@@ -401,10 +766,16 @@ func TestSnapShot_WithTags(t *testing.T) {
 		t.Fatal("No snapshot file created")
 	}
 
-	// Check if the snapshot file name contains the tags
-	snapshotFile := files[0].Name()
-	if !strings.Contains(snapshotFile, "tag1") || !strings.Contains(snapshotFile, "tag2") {
-		t.Errorf("Snapshot file name does not contain tags, got %s", snapshotFile)
+	// Check if the snapshot metadata contains the tags, tags are no longer part of the file name
+	snapshot, err := ReadSnapshot(filepath.Join(snapshotDir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	if strings.Join(snapshot.Tags, ",") != "tag1,tag2" {
+		t.Errorf("Snapshot does not contain tags, got %v", snapshot.Tags)
+	}
+	if files[0].Name() != snapshot.ID+".json" {
+		t.Errorf("Expected the snapshot file to be named after its id %s, got %s", snapshot.ID, files[0].Name())
 	}
 }
 
@@ -417,7 +788,7 @@ func TestSnapShot_EmptyTrackPaths(t *testing.T) {
 	defer os.RemoveAll(snapshotDir) // clean up
 
 	// Call the SnapShot function with empty track paths
-	err = SnapShot(snapshotDir, []string{})
+	_, err = SnapShot(snapshotDir, parsing.Entries())
 	if err != nil {
 		t.Fatalf("SnapShot returned an error: %v", err)
 	}
@@ -452,3 +823,85 @@ func TestSnapShot_EmptyTrackPaths(t *testing.T) {
 		t.Errorf("Expected 0 child nodes, got %d", len(root.Children))
 	}
 }
+
+func TestListSnapshots(t *testing.T) {
+	snapshotDir := t.TempDir()
+
+	// a snapshot written before metadata was recorded, tags only live in the file name
+	legacy := filepath.Join(snapshotDir, "abcd1234_tag1_tag2.json")
+	if err := os.WriteFile(legacy, []byte(`{"path":"root","hash":"abcd1234","children":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(legacy, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SnapShot(snapshotDir, parsing.Entries(), "tag3"); err != nil {
+		t.Fatalf("SnapShot returned an error: %v", err)
+	}
+
+	snapshots, err := ListSnapshots(snapshotDir)
+	if err != nil {
+		t.Fatalf("ListSnapshots returned an error: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+
+	// oldest first
+	if snapshots[0].ID != "abcd1234_tag1_tag2" {
+		t.Errorf("Expected the legacy snapshot first, got %s", snapshots[0].ID)
+	}
+	if !snapshots[0].Created.Equal(past) {
+		t.Errorf("Expected the legacy snapshot to be dated from its file, got %s", snapshots[0].Created)
+	}
+	if strings.Join(snapshots[0].Tags, ",") != "tag1,tag2" {
+		t.Errorf("Expected legacy tags tag1,tag2, got %v", snapshots[0].Tags)
+	}
+	if strings.Join(snapshots[1].Tags, ",") != "tag3" {
+		t.Errorf("Expected tags tag3, got %v", snapshots[1].Tags)
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	snapshotDir := t.TempDir()
+
+	first := Snapshot{Node: Node{Path: "root", Hash: "aaaa1111"}, Created: time.Now().Add(-2 * time.Hour), Tags: []string{"baseline"}}
+	second := Snapshot{Node: Node{Path: "root", Hash: "aaaa2222"}, Created: time.Now().Add(-time.Hour), Tags: []string{"baseline"}}
+	third := Snapshot{Node: Node{Path: "root", Hash: "bbbb3333"}, Created: time.Now()}
+	for _, snapshot := range []Snapshot{first, second, third} {
+		snapshot.ID = NewSnapshotID(snapshot.Created, snapshot.Hash)
+		if _, err := WriteSnapshot(snapshotDir, snapshot); err != nil {
+			t.Fatalf("WriteSnapshot returned an error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		ref      string
+		expected string // expected root hash, empty when an error is expected
+	}{
+		{NewSnapshotID(third.Created, third.Hash), "bbbb3333"},
+		{"baseline", "aaaa2222"}, // newest snapshot with the tag
+		{"bbbb", "bbbb3333"},     // hash prefix
+		{"aaaa", ""},             // ambiguous
+		{"cccc", ""},             // no match
+	}
+
+	for _, test := range tests {
+		snapshot, err := FindSnapshot(snapshotDir, test.ref)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("FindSnapshot(%q): expected an error, got %s", test.ref, snapshot.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FindSnapshot(%q) returned an error: %v", test.ref, err)
+			continue
+		}
+		if snapshot.Hash != test.expected {
+			t.Errorf("FindSnapshot(%q): expected hash %s, got %s", test.ref, test.expected, snapshot.Hash)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package hashing
+
+import (
+	"encoding/json"
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/hooks"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hooksDir holds the pre-snap.d and post-snap.d hooks
+var hooksDir = config.HooksDir
+
+// hookOptions returns how the hooks of a phase are run, from the config file
+func hookOptions(phase string) hooks.Options {
+	opts := hooks.Options{Dir: hooksDir, Timeout: config.VariableConfig.Hooks.Timeout, OnFailure: hooks.Abort}
+	if phase == hooks.PreSnap && config.VariableConfig.Hooks.PreFailure != "" {
+		opts.OnFailure = config.VariableConfig.Hooks.PreFailure
+	}
+	if phase == hooks.PostSnap {
+		opts.OnFailure = hooks.Warn
+		if config.VariableConfig.Hooks.PostFailure != "" {
+			opts.OnFailure = config.VariableConfig.Hooks.PostFailure
+		}
+	}
+	return opts
+}
+
+// RunPreHooks runs the pre-snap.d hooks before the tracked paths are hashed, e.g. to dump a
+// database to a tracked location. The hooks get MAGMA_PHASE, MAGMA_SNAPSHOTS_DIR and MAGMA_TAGS.
+//
+// Parameters:
+//   - snapshotsDir: the directory the snapshot will be written to.
+//   - tags: the tags of the snapshot.
+//
+// Returns:
+//   - error: an error if a hook failed and the pre_failure policy is abort.
+func RunPreHooks(snapshotsDir string, tags []string) error {
+	env := []string{
+		"MAGMA_PHASE=pre-snap",
+		"MAGMA_SNAPSHOTS_DIR=" + snapshotsDir,
+		"MAGMA_TAGS=" + strings.Join(tags, ","),
+	}
+	return hooks.Run(hookOptions(hooks.PreSnap), hooks.PreSnap, env)
+}
+
+// RunPostHooks runs the post-snap.d hooks once a snapshot is written. On top of the variables of
+// the pre-snap.d hooks, the hooks get MAGMA_SNAPSHOT_ID, MAGMA_SNAPSHOT_FILE, MAGMA_ROOT_HASH,
+// MAGMA_PREVIOUS_ID (empty for the first snapshot), MAGMA_CHANGED_FILES, the number of files and
+// symlinks that changed since the previous snapshot, and MAGMA_DIFF, the path of a JSON file
+// holding those changes, removed once the hooks ran.
+//
+// Parameters:
+//   - snapshotsDir: the directory the snapshot was written to.
+//   - snapshot: the new snapshot.
+//   - previous: the latest snapshot before the new one, nil if there was none.
+//
+// Returns:
+//   - error: an error if a hook failed and the post_failure policy is abort.
+func RunPostHooks(snapshotsDir string, snapshot Snapshot, previous *Snapshot) error {
+	opts := hookOptions(hooks.PostSnap)
+
+	// the diff is only computed when there is a hook to read it
+	list, err := hooks.List(filepath.Join(opts.Dir, hooks.PostSnap))
+	if err != nil || len(list) == 0 {
+		return err
+	}
+
+	var old Node
+	previousID := ""
+	if previous != nil {
+		old = previous.Node
+		previousID = previous.ID
+	}
+	changes := Diff(old, snapshot.Node)
+	if changes == nil {
+		changes = []Change{}
+	}
+
+	diffFile, err := os.CreateTemp("", "magma-diff-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(diffFile.Name())
+	if err := json.NewEncoder(diffFile).Encode(changes); err != nil {
+		diffFile.Close()
+		return err
+	}
+	if err := diffFile.Close(); err != nil {
+		return err
+	}
+
+	env := []string{
+		"MAGMA_PHASE=post-snap",
+		"MAGMA_SNAPSHOTS_DIR=" + snapshotsDir,
+		"MAGMA_TAGS=" + strings.Join(snapshot.Tags, ","),
+		"MAGMA_SNAPSHOT_ID=" + snapshot.ID,
+		"MAGMA_SNAPSHOT_FILE=" + snapshot.File,
+		"MAGMA_ROOT_HASH=" + snapshot.Hash,
+		"MAGMA_PREVIOUS_ID=" + previousID,
+		fmt.Sprintf("MAGMA_CHANGED_FILES=%d", changedFiles(changes)),
+		"MAGMA_DIFF=" + diffFile.Name(),
+	}
+	return hooks.Run(opts, hooks.PostSnap, env)
+}
+
+// changedFiles counts the changes of files and symlinks, directories are left out. Snapshots
+// taken before metadata was recorded do not tell directories apart, they are counted as well.
+func changedFiles(changes []Change) int {
+	count := 0
+	for _, change := range changes {
+		node := change.New
+		if node == nil {
+			node = change.Old
+		}
+		if node != nil && strings.HasPrefix(node.Mode, "d") {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// HasPostHooks reports whether post-snap.d hooks are installed, the previous snapshot they are
+// given is only looked up then. A hooks directory that cannot be read counts as having hooks, so
+// RunPostHooks reports it.
+func HasPostHooks() bool {
+	list, err := hooks.List(filepath.Join(hooksDir, hooks.PostSnap))
+	return err != nil || len(list) > 0
+}
+
+// LatestSnapshot returns the most recent snapshot of a directory, nil if there is none. Files
+// that cannot be read or parsed are skipped, so a corrupt file left for fsck does not block new
+// snapshots.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//
+// Returns:
+//   - *Snapshot: the most recent readable snapshot, nil if there is none.
+//   - error: an error if the directory cannot be read.
+func LatestSnapshot(dir string) (*Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		snapshot, err := ReadSnapshot(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		// like ListSnapshots, the last of the snapshots created at the same time wins
+		if latest == nil || !snapshot.Created.Before(latest.Created) {
+			latest = &snapshot
+		}
+	}
+	return latest, nil
+}
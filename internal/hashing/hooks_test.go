@@ -0,0 +1,189 @@
+package hashing
+
+import (
+	"encoding/json"
+	"magma/internal/config"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapShot_Hooks(t *testing.T) {
+	hooksDir = t.TempDir()
+	defer func() { hooksDir = config.HooksDir }()
+
+	tracked := t.TempDir()
+	snapshotsDir := t.TempDir()
+	out := t.TempDir()
+	file := filepath.Join(tracked, "file.txt")
+
+	for phase, script := range map[string]string{
+		"pre-snap.d":  `echo "$MAGMA_PHASE $MAGMA_TAGS" > "` + out + `/pre"`,
+		"post-snap.d": `env | grep ^MAGMA_ > "` + out + `/env"; cp "$MAGMA_DIFF" "` + out + `/diff"`,
+	} {
+		if err := os.MkdirAll(filepath.Join(hooksDir, phase), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(hooksDir, phase, "10-hook"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SnapShot(snapshotsDir, parsing.Entries(tracked), "manual"); err != nil {
+		t.Fatalf("SnapShot returned an error: %v", err)
+	}
+	if err := os.WriteFile(file, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SnapShot(snapshotsDir, parsing.Entries(tracked)); err != nil {
+		t.Fatalf("SnapShot returned an error: %v", err)
+	}
+
+	pre, err := os.ReadFile(filepath.Join(out, "pre"))
+	if err != nil || string(pre) != "pre-snap \n" {
+		t.Errorf("Expected the pre-snap.d hook to run, got %q, %v", pre, err)
+	}
+
+	snapshots, err := ListSnapshots(snapshotsDir)
+	if err != nil || len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d, %v", len(snapshots), err)
+	}
+	latest := snapshots[1]
+
+	content, err := os.ReadFile(filepath.Join(out, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(content)
+	for _, expected := range []string{
+		"MAGMA_PHASE=post-snap",
+		"MAGMA_SNAPSHOT_ID=" + latest.ID,
+		"MAGMA_SNAPSHOT_FILE=" + filepath.Join(snapshotsDir, latest.ID+".json"),
+		"MAGMA_ROOT_HASH=" + latest.Hash,
+		"MAGMA_PREVIOUS_ID=" + snapshots[0].ID,
+		"MAGMA_CHANGED_FILES=1",
+	} {
+		if !strings.Contains(env, expected+"\n") {
+			t.Errorf("Expected %s in the environment of the post-snap.d hook, got %q", expected, env)
+		}
+	}
+
+	var changes []Change
+	content, err = os.ReadFile(filepath.Join(out, "diff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &changes); err != nil {
+		t.Fatalf("Expected the diff to be JSON: %v", err)
+	}
+	found := false
+	for _, change := range changes {
+		if change.Path == file && change.Kind == Modified {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the modification of %s in the diff, got %+v", file, changes)
+	}
+}
+
+func TestSnapShot_PreHookAborts(t *testing.T) {
+	hooksDir = t.TempDir()
+	defer func() { hooksDir = config.HooksDir }()
+
+	if err := os.MkdirAll(filepath.Join(hooksDir, "pre-snap.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(hooksDir, "pre-snap.d", "10-fail"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotsDir := t.TempDir()
+	if _, err := SnapShot(snapshotsDir, parsing.Entries(t.TempDir())); err == nil {
+		t.Fatal("Expected the failing pre-snap.d hook to abort the snapshot")
+	}
+	if snapshots, _ := ListSnapshots(snapshotsDir); len(snapshots) != 0 {
+		t.Errorf("Expected no snapshot to be written, got %d", len(snapshots))
+	}
+}
+
+func TestSnapShot_CorruptSnapshot(t *testing.T) {
+	hooksDir = t.TempDir()
+	defer func() { hooksDir = config.HooksDir }()
+
+	tracked := t.TempDir()
+	snapshotsDir := t.TempDir()
+	out := t.TempDir()
+
+	// a corrupt file left for fsck does not block new snapshots
+	if err := os.WriteFile(filepath.Join(snapshotsDir, "broken.json"), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := SnapShot(snapshotsDir, parsing.Entries(tracked))
+	if err != nil {
+		t.Fatalf("Expected the snapshot to be taken without hooks, got %v", err)
+	}
+
+	// nor does it when the post-snap.d hooks are given the previous snapshot
+	if err := os.MkdirAll(filepath.Join(hooksDir, "post-snap.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$MAGMA_PREVIOUS_ID\" > \"" + out + "/previous\"\n"
+	if err := os.WriteFile(filepath.Join(hooksDir, "post-snap.d", "10-hook"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tracked, "file.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SnapShot(snapshotsDir, parsing.Entries(tracked)); err != nil {
+		t.Fatalf("Expected the snapshot to be taken with hooks, got %v", err)
+	}
+	previous, err := os.ReadFile(filepath.Join(out, "previous"))
+	if err != nil || string(previous) != first.ID+"\n" {
+		t.Errorf("Expected the previous readable snapshot %s, got %q, %v", first.ID, previous, err)
+	}
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	hooksDir = t.TempDir()
+	defer func() { hooksDir = config.HooksDir }()
+
+	tracked := t.TempDir()
+	snapshotsDir := t.TempDir()
+	entries := parsing.Entries(tracked)
+
+	first, taken, err := TakeSnapshot(snapshotsDir, entries, SnapshotOptions{Tags: []string{"baseline", "nightly"}, UniqueTags: []string{"baseline"}})
+	if err != nil || !taken || first.File == "" {
+		t.Fatalf("Expected a snapshot to be written, got %+v, %v, %v", first, taken, err)
+	}
+
+	// an identical tree is skipped
+	opts := SnapshotOptions{Tags: []string{"baseline", "nightly"}, UniqueTags: []string{"baseline"}, SkipIfUnchanged: true}
+	if skipped, taken, err := TakeSnapshot(snapshotsDir, entries, opts); err != nil || taken || skipped.ID != first.ID {
+		t.Fatalf("Expected the unchanged snapshot to be skipped, got %+v, %v, %v", skipped, taken, err)
+	}
+
+	// the unique tag moves to the new snapshot, the others stay
+	if err := os.WriteFile(filepath.Join(tracked, "file.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second, taken, err := TakeSnapshot(snapshotsDir, entries, opts)
+	if err != nil || !taken {
+		t.Fatalf("Expected a changed snapshot to be written, got %v, %v", taken, err)
+	}
+	old, err := ReadSnapshot(first.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.HasTag("baseline") || !old.HasTag("nightly") {
+		t.Errorf("Expected only the unique tag to be removed from %s, got %v", first.ID, old.Tags)
+	}
+	if baseline, err := FindSnapshot(snapshotsDir, "baseline"); err != nil || baseline.ID != second.ID {
+		t.Errorf("Expected the baseline to move to %s, got %s, %v", second.ID, baseline.ID, err)
+	}
+}
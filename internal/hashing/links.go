@@ -0,0 +1,70 @@
+package hashing
+
+import "fmt"
+
+// Link is a symlink of a snapshot that may not point to what was meant to be tracked
+type Link struct {
+	Path     string
+	Target   string
+	Dangling bool // The link points to nothing, or loops through other links
+	Outside  bool // The link points outside the tracked paths
+}
+
+// String describes the problem of the link
+func (l Link) String() string {
+	if l.Dangling {
+		return fmt.Sprintf("%s -> %s is dangling", l.Path, l.Target)
+	}
+	return fmt.Sprintf("%s -> %s points outside the tracked paths", l.Path, l.Target)
+}
+
+// Links lists the symlinks of a snapshot that are dangling or point outside its tracked paths,
+// see Node.Dangling and Node.Outside.
+//
+// Parameters:
+//   - root: the root node of the snapshot.
+//
+// Returns:
+//   - []Link: the links, in the order of the snapshot.
+func Links(root Node) []Link {
+	var links []Link
+	var walk func(node Node)
+	walk = func(node Node) {
+		if node.Dangling || node.Outside {
+			links = append(links, Link{Path: node.Path, Target: node.Target, Dangling: node.Dangling, Outside: node.Outside})
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	for _, tracked := range root.Children {
+		walk(tracked)
+	}
+	return links
+}
+
+// markOutside flags the symlinks pointing outside the tracked paths, the roots of the snapshot
+func markOutside(roots []Node) {
+	var mark func(node *Node)
+	mark = func(node *Node) {
+		if node.Target != "" {
+			node.Outside = !insideRoots(node.Target, roots)
+		}
+		for i := range node.Children {
+			mark(&node.Children[i])
+		}
+	}
+	for i := range roots {
+		mark(&roots[i])
+	}
+}
+
+// insideRoots reports whether a path is one of the tracked paths or under one of them
+func insideRoots(path string, roots []Node) bool {
+	for _, root := range roots {
+		if path == root.Path || IsUnder(path, root.Path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package hashing
+
+import (
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// linkTree creates a tracked directory holding a file, a link to it, a dangling link, a link to
+// an untracked directory and a link looping back to the tracked directory
+func linkTree(t *testing.T) (string, string) {
+	t.Helper()
+	tmpdir := t.TempDir()
+	root := filepath.Join(tmpdir, "root")
+	outside := filepath.Join(tmpdir, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "app.conf"), []byte("conf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "alt.conf"), []byte("alt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"current":  "app.conf",
+		"dangling": "missing.conf",
+		"alt":      outside,
+		"loop":     ".",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestHashEntry_Symlinks(t *testing.T) {
+	setIgnore(t)
+	root, outside := linkTree(t)
+	current := filepath.Join(root, "current")
+	target := filepath.Join(root, "app.conf")
+	content, err := hashFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record-link hashes the path the link points to
+	node, err := HashEntry(parsing.TrackEntry{Path: root})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes := nodesByPath(node)
+	if link := nodes[current]; link.Hash != hashString(target) || link.Target != target || link.Dangling {
+		t.Errorf("Expected the link to be hashed as its target path, got %+v", link)
+	}
+	if link := nodes[filepath.Join(root, "dangling")]; !link.Dangling {
+		t.Errorf("Expected the dangling link to be reported, got %+v", link)
+	}
+	if _, ok := nodes[filepath.Join(root, "alt", "alt.conf")]; ok {
+		t.Errorf("Expected the linked directory not to be hashed")
+	}
+
+	// follow hashes what the link points to, a link back to a hashed directory is skipped
+	node, err = HashEntry(parsing.TrackEntry{Path: root, Symlinks: parsing.SymlinksFollow})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes = nodesByPath(node)
+	if link := nodes[current]; link.Hash != content || link.Target != target {
+		t.Errorf("Expected the link to be hashed as the file it points to, got %+v", link)
+	}
+	if _, ok := nodes[filepath.Join(root, "alt", "alt.conf")]; !ok {
+		t.Errorf("Expected the linked directory to be hashed")
+	}
+	if loop := nodes[filepath.Join(root, "loop")]; loop.Hash != "skipped" || loop.Reason != "symlink loop" {
+		t.Errorf("Expected the loop to be skipped, got %+v", loop)
+	}
+	if link := nodes[filepath.Join(root, "dangling")]; !link.Dangling || link.Hash != hashString(filepath.Join(root, "missing.conf")) {
+		t.Errorf("Expected the dangling link to be kept as a link, got %+v", link)
+	}
+
+	// both hashes the target path and what is there
+	node, err = HashEntry(parsing.TrackEntry{Path: root, Symlinks: parsing.SymlinksBoth})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes = nodesByPath(node)
+	if link := nodes[current]; link.Hash != HashLink(target, content) {
+		t.Errorf("Expected the link to be hashed with its target path and content, got %+v", link)
+	}
+	alt := nodes[filepath.Join(root, "alt")]
+	if alt.Target != outside || alt.Hash != HashLink(outside, hashNodeList(alt.Children)) {
+		t.Errorf("Expected the linked directory to be hashed with its target path, got %+v", alt)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	setIgnore(t)
+	root, outside := linkTree(t)
+
+	snapshot, err := BuildSnapshot(parsing.Entries(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := Links(snapshot.Node)
+	if len(links) != 2 {
+		t.Fatalf("Expected the dangling and outside links, got %+v", links)
+	}
+	if links[0].Path != filepath.Join(root, "alt") || !links[0].Outside || links[0].Target != outside {
+		t.Errorf("Expected alt to point outside, got %+v", links[0])
+	}
+	if links[1].Path != filepath.Join(root, "dangling") || !links[1].Dangling {
+		t.Errorf("Expected dangling to be dangling, got %+v", links[1])
+	}
+
+	// tracking the target brings the link inside
+	snapshot, err = BuildSnapshot(parsing.Entries(root, outside))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links := Links(snapshot.Node); len(links) != 1 || !links[0].Dangling {
+		t.Errorf("Expected only the dangling link, got %+v", links)
+	}
+}
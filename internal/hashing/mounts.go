@@ -0,0 +1,107 @@
+package hashing
+
+import (
+	"bufio"
+	"fmt"
+	"magma/internal/config"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mount is a file system mounted on a directory, as listed in /proc/self/mountinfo
+type Mount struct {
+	FSType string `json:"fstype"`           // The type of the file system, e.g. ext4, tmpfs or nfs4
+	Source string `json:"source,omitempty"` // What is mounted, e.g. /dev/sda1 or server:/export
+	Device string `json:"device,omitempty"` // The major:minor number of the file system
+}
+
+// mountInfo lists the mounts of the process
+var mountInfo = "/proc/self/mountinfo"
+
+// DefaultSkipFSTypes are the file systems not descended into when mounted under a tracked path:
+// pseudo file systems whose content is generated by the kernel, memory backed ones emptied on
+// reboot, and network ones that may be slow, unreachable, or shared with other devices.
+var DefaultSkipFSTypes = []string{
+	"proc", "sysfs", "devtmpfs", "devpts", "tmpfs", "ramfs", "mqueue", "hugetlbfs",
+	"cgroup", "cgroup2", "debugfs", "tracefs", "securityfs", "pstore", "bpf", "configfs",
+	"fusectl", "efivarfs", "binfmt_misc", "autofs", "nsfs", "rpc_pipefs",
+	"nfs", "nfs4", "cifs", "smb3", "ceph", "glusterfs", "fuse.sshfs",
+}
+
+// SkipsFSType reports whether the directories where a file system of a type is mounted are skipped,
+// see DefaultSkipFSTypes. The mounts section of config.yaml adds types with skip_fs_types and keeps
+// hashing some with hash_fs_types.
+//
+// Parameters:
+//   - fsType: the type of the file system, e.g. tmpfs.
+//
+// Returns:
+//   - bool: true if the mounted directories are skipped.
+func SkipsFSType(fsType string) bool {
+	mounts := config.VariableConfig.Mounts
+	if slices.Contains(mounts.HashFSTypes, fsType) {
+		return false
+	}
+	return slices.Contains(DefaultSkipFSTypes, fsType) || slices.Contains(mounts.SkipFSTypes, fsType)
+}
+
+// readMounts reads the mount table, by mount point. The last mount of a point hides the others,
+// like it does on disk
+func readMounts(path string) (map[string]Mount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := map[string]Mount{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		point, mount, err := parseMountInfo(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		mounts[point] = mount
+	}
+	return mounts, scanner.Err()
+}
+
+// parseMountInfo parses a line of /proc/self/mountinfo:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// the optional fields before the separator are skipped
+func parseMountInfo(line string) (string, Mount, error) {
+	fields := strings.Fields(line)
+	separator := slices.Index(fields, "-")
+	if separator < 6 || len(fields) < separator+3 {
+		return "", Mount{}, fmt.Errorf("malformed mount %q", line)
+	}
+	mount := Mount{
+		FSType: fields[separator+1],
+		Source: unescapeMount(fields[separator+2]),
+		Device: fields[2],
+	}
+	return unescapeMount(fields[4]), mount, nil
+}
+
+// unescapeMount decodes the octal escapes of the spaces, tabs, newlines and backslashes of a mount
+func unescapeMount(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
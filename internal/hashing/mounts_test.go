@@ -0,0 +1,121 @@
+package hashing
+
+import (
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	point, mount, err := parseMountInfo(`36 35 98:0 /mnt1 /mnt/my\040disk rw,noatime master:1 shared:2 - ext4 /dev/sda1 rw,errors=continue`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if point != "/mnt/my disk" || mount != (Mount{FSType: "ext4", Source: "/dev/sda1", Device: "98:0"}) {
+		t.Errorf("Unexpected mount %q %+v", point, mount)
+	}
+
+	if _, _, err := parseMountInfo("36 35 98:0 / /mnt rw"); err == nil {
+		t.Errorf("Expected a line without separator to be rejected")
+	}
+}
+
+// setMounts replaces the mount table with mounts on the given points
+func setMounts(t *testing.T, mounts map[string]string) {
+	t.Helper()
+	previous := mountInfo
+	t.Cleanup(func() { mountInfo = previous })
+
+	mountInfo = filepath.Join(t.TempDir(), "mountinfo")
+	content := ""
+	i := 0
+	for point, fsType := range mounts {
+		i++
+		content += fmt.Sprintf("%d 1 0:%d / %s rw - %s %s rw\n", 100+i, 50+i, point, fsType, fsType)
+	}
+	if err := os.WriteFile(mountInfo, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashEntry_Mounts(t *testing.T) {
+	setIgnore(t)
+	root := t.TempDir()
+	for _, dir := range []string{"proc", "data", "cache"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "file"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setMounts(t, map[string]string{
+		root:                         "tmpfs",
+		filepath.Join(root, "proc"):  "proc",
+		filepath.Join(root, "data"):  "ext4",
+		filepath.Join(root, "cache"): "tmpfs",
+	})
+
+	node, err := HashEntry(parsing.TrackEntry{Path: root})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes := nodesByPath(node)
+
+	// the tracked path is hashed whatever its file system
+	if node.Hash == "skipped" || node.Mount == nil || node.Mount.FSType != "tmpfs" {
+		t.Errorf("Expected the tracked path to be hashed and record its mount, got %+v", node)
+	}
+	if proc := nodes[filepath.Join(root, "proc")]; proc.Hash != "skipped" || proc.Reason != "proc file system" || proc.Mount == nil {
+		t.Errorf("Expected proc to be skipped, got %+v", proc)
+	}
+	if data := nodes[filepath.Join(root, "data")]; data.Hash == "skipped" || data.Mount == nil || data.Mount.FSType != "ext4" {
+		t.Errorf("Expected data to be hashed and record its mount, got %+v", data)
+	}
+	if _, ok := nodes[filepath.Join(root, "data", "file")]; !ok {
+		t.Errorf("Expected the content of data to be hashed")
+	}
+	if cache := nodes[filepath.Join(root, "cache")]; cache.Hash != "skipped" {
+		t.Errorf("Expected the tmpfs mount to be skipped, got %+v", cache)
+	}
+
+	// the config keeps hashing a type skipped by default
+	previous := config.VariableConfig.Mounts
+	t.Cleanup(func() { config.VariableConfig.Mounts = previous })
+	config.VariableConfig.Mounts.HashFSTypes = []string{"tmpfs"}
+	config.VariableConfig.Mounts.SkipFSTypes = []string{"ext4"}
+
+	node, err = HashEntry(parsing.TrackEntry{Path: root})
+	if err != nil {
+		t.Fatalf("HashEntry returned an error: %v", err)
+	}
+	nodes = nodesByPath(node)
+	if cache := nodes[filepath.Join(root, "cache")]; cache.Hash == "skipped" {
+		t.Errorf("Expected the tmpfs mount to be hashed with hash_fs_types, got %+v", cache)
+	}
+	if data := nodes[filepath.Join(root, "data")]; data.Hash != "skipped" || data.Reason != "ext4 file system" {
+		t.Errorf("Expected the ext4 mount to be skipped with skip_fs_types, got %+v", data)
+	}
+}
+
+func TestCountFiles_Mounts(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"a.conf", "proc/cpuinfo", "data/b.conf"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setMounts(t, map[string]string{filepath.Join(root, "proc"): "proc", filepath.Join(root, "data"): "ext4"})
+
+	// like snapshots, the pseudo file system mounted under the path is not counted
+	if files, size := CountFiles(parsing.TrackEntry{Path: root}); files != 2 || size != 2 {
+		t.Errorf("Expected 2 files of 2 bytes, got %d files of %d bytes", files, size)
+	}
+}
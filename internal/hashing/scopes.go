@@ -0,0 +1,113 @@
+package hashing
+
+import (
+	"fmt"
+	"magma/internal/ignore"
+	"path/filepath"
+)
+
+// IgnoreScopes caches the ignore rules applying inside each directory for a walk or a watch
+// session, so the .magmaignore files above the paths checked are read and checked once rather
+// than for every path. A .magmaignore file that cannot be honoured is recorded once and returned
+// by Warnings, its valid patterns still apply.
+type IgnoreScopes struct {
+	matchers map[string]*ignore.Matcher // The rules applying inside each directory seen
+	warnings []string                   // The .magmaignore files not honoured, not returned yet
+	reported map[string]bool            // The directories whose .magmaignore file was reported
+}
+
+// NewIgnoreScopes returns an empty cache of the rules of the ignore file and of the .magmaignore
+// files, for a walk or a watch session.
+//
+// Returns:
+//   - *IgnoreScopes: the cache, read lazily.
+func NewIgnoreScopes() *IgnoreScopes {
+	return &IgnoreScopes{matchers: map[string]*ignore.Matcher{}, reported: map[string]bool{}}
+}
+
+// Scope returns the rules applying inside a directory: those of the ignore file followed by those
+// of the .magmaignore files of the directory and of its parents, the deepest last.
+//
+// Parameters:
+//   - dir: the directory.
+//
+// Returns:
+//   - *ignore.Matcher: the rules of the directory, shared and not to be changed.
+func (s *IgnoreScopes) Scope(dir string) *ignore.Matcher {
+	dir = filepath.Clean(dir)
+	if rules, ok := s.matchers[dir]; ok {
+		return rules
+	}
+
+	parent := ignoreRules
+	if dir != "/" && dir != "." {
+		parent = s.Scope(filepath.Dir(dir))
+	}
+	rules := s.extend(parent, dir)
+	s.matchers[dir] = rules
+	return rules
+}
+
+// IsIgnored reports whether a path matches the patterns of the ignore file or of the .magmaignore
+// files of its parent directories, with the semantics of a gitignore file, see ignore.Rule. The
+// directories above the tracked path holding it do not ignore it, see ignore.Matcher.ExplainUnder.
+//
+// Parameters:
+//   - root: the tracked path holding the path, or the path itself.
+//   - path: the absolute path to check.
+//   - isDir: whether the path is a directory.
+//
+// Returns:
+//   - bool: true if the path is skipped when hashing.
+func (s *IgnoreScopes) IsIgnored(root string, path string, isDir bool) bool {
+	_, ignored := s.Scope(filepath.Dir(path)).ExplainUnder(root, path, isDir)
+	return ignored
+}
+
+// Invalidate forgets the rules of a directory and of every directory under it, so they are read
+// again once the .magmaignore file of the directory changed or the directory was replaced.
+//
+// Parameters:
+//   - dir: the directory.
+func (s *IgnoreScopes) Invalidate(dir string) {
+	dir = filepath.Clean(dir)
+	for cached := range s.matchers {
+		if cached == dir || IsUnder(cached, dir) {
+			delete(s.matchers, cached)
+		}
+	}
+	for reported := range s.reported {
+		if reported == dir || IsUnder(reported, dir) {
+			delete(s.reported, reported)
+		}
+	}
+}
+
+// Warnings returns the .magmaignore files that could not be honoured since the last call, each
+// file is reported once for the session, until its directory is invalidated.
+//
+// Returns:
+//   - []string: the warnings, to show to the user.
+func (s *IgnoreScopes) Warnings() []string {
+	warnings := s.warnings
+	s.warnings = nil
+	return warnings
+}
+
+// extend adds the rules of the .magmaignore file of a directory, a broken file is recorded as a
+// warning and its valid patterns still apply
+func (s *IgnoreScopes) extend(rules *ignore.Matcher, dir string) *ignore.Matcher {
+	extended, err := rules.Extend(dir)
+	if err != nil && !s.reported[dir] {
+		s.reported[dir] = true
+		s.warnings = append(s.warnings, err.Error())
+	}
+	return extended
+}
+
+// printWarnings prints the warnings of a walk
+func printWarnings(s *IgnoreScopes) {
+	for _, warning := range s.Warnings() {
+		fmt.Println("Warning:", warning)
+	}
+}
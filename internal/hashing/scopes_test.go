@@ -0,0 +1,51 @@
+package hashing
+
+import (
+	"magma/internal/ignore"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreScopes(t *testing.T) {
+	setIgnore(t)
+	tmpdir := t.TempDir()
+	sub := filepath.Join(tmpdir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	magmaignore := filepath.Join(tmpdir, ignore.FileName)
+	if err := os.WriteFile(magmaignore, []byte("*.log\n[abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the valid patterns apply, the broken line is reported once for the session
+	scopes := NewIgnoreScopes()
+	log := filepath.Join(sub, "app.log")
+	if !scopes.IsIgnored(tmpdir, log, false) || !scopes.IsIgnored(tmpdir, filepath.Join(tmpdir, "b.log"), false) {
+		t.Fatal("Expected the .magmaignore file to ignore the log files")
+	}
+	warnings := scopes.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], magmaignore) {
+		t.Fatalf("Expected a single warning about %s, got %v", magmaignore, warnings)
+	}
+	if warnings := scopes.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected the warning to be returned once, got %v", warnings)
+	}
+
+	// the rules are cached until the directory is invalidated
+	if err := os.WriteFile(magmaignore, []byte("*.conf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !scopes.IsIgnored(tmpdir, log, false) {
+		t.Error("Expected the cached rules to still apply")
+	}
+	scopes.Invalidate(tmpdir)
+	if scopes.IsIgnored(tmpdir, log, false) || !scopes.IsIgnored(tmpdir, filepath.Join(sub, "a.conf"), false) {
+		t.Error("Expected the changed .magmaignore file to apply once invalidated")
+	}
+	if warnings := scopes.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warning for the fixed file, got %v", warnings)
+	}
+}
@@ -0,0 +1,345 @@
+package hashing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is the content of a snapshot file: the root node of the tracked tree, with the
+// metadata describing the snapshot stored next to the root node's fields.
+type Snapshot struct {
+	Node
+	ID      string    `json:"id,omitempty"`    // Unique id of the snapshot, also its file name
+	Created time.Time `json:"created"`         // When the snapshot was taken
+	Tags    []string  `json:"tags,omitempty"`  // Tags attached to the snapshot
+	Notes   []Note    `json:"notes,omitempty"` // Free form annotations, oldest first
+
+	Approval *Approval `json:"approval,omitempty"` // Set when the snapshot was accepted as baseline
+
+	File string `json:"-"` // The path of the snapshot file
+}
+
+// Note is an annotation attached to a snapshot after it was taken
+type Note struct {
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Approval records who accepted a snapshot as the baseline and why
+type Approval struct {
+	By       string    `json:"by"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+	Paths    []string  `json:"paths,omitempty"`    // The accepted paths, empty when every change was accepted
+	Previous string    `json:"previous,omitempty"` // The id of the previous baseline
+}
+
+// snapshotIDTime is the layout of the timestamp that starts every snapshot id
+const snapshotIDTime = "20060102T150405Z"
+
+// NewSnapshotID returns the id of a snapshot taken at the given time with the given root hash,
+// the timestamp keeps ids unique and sorted, the truncated hash makes them recognisable.
+//
+// Parameters:
+//   - created: when the snapshot was taken.
+//   - hash: the root hash of the snapshot.
+//
+// Returns:
+//   - string: the snapshot id, e.g. 20240131T120000Z-1a2b3c4d.
+func NewSnapshotID(created time.Time, hash string) string {
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	return created.UTC().Format(snapshotIDTime) + "-" + hash
+}
+
+// ParseSnapshotID splits an id created by NewSnapshotID into its timestamp and truncated hash.
+// Ids of snapshots written before ids existed are their file name and do not parse.
+//
+// Parameters:
+//   - id: the snapshot id.
+//
+// Returns:
+//   - time.Time: the time the snapshot was taken.
+//   - string: the truncated root hash.
+//   - bool: false if the id was not created by NewSnapshotID.
+func ParseSnapshotID(id string) (time.Time, string, bool) {
+	timestamp, hash, found := strings.Cut(id, "-")
+	if !found {
+		return time.Time{}, "", false
+	}
+	created, err := time.Parse(snapshotIDTime, timestamp)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return created, hash, true
+}
+
+// HasTag reports whether the snapshot carries the given tag
+func (s Snapshot) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSnapshot reads the snapshot file at the given path.
+// Snapshots written before metadata was recorded get their id from the file name, their creation
+// time from the file's modification time and their tags from the file name.
+//
+// Parameters:
+//   - path: the path of the snapshot JSON file.
+//
+// Returns:
+//   - Snapshot: the parsed snapshot.
+//   - error: an error if the file cannot be read or is not valid JSON.
+func ReadSnapshot(path string) (Snapshot, error) {
+	var snapshot Snapshot
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return snapshot, err
+	}
+
+	snapshot.File = path
+
+	if snapshot.ID == "" {
+		snapshot.ID = strings.TrimSuffix(filepath.Base(path), ".json")
+
+		// legacy file names are the truncated root hash followed by the tags: <hash>_<tag1>_<tag2>
+		if parts := strings.Split(snapshot.ID, "_"); len(parts) > 1 && snapshot.Tags == nil {
+			snapshot.Tags = parts[1:]
+		}
+	}
+
+	if snapshot.Created.IsZero() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return snapshot, err
+		}
+		snapshot.Created = info.ModTime()
+	}
+
+	return snapshot, nil
+}
+
+// WriteSnapshot writes the snapshot to <dir>/<id>.json, replacing any previous version of it.
+// The file is written to a temporary file first so a crash never leaves a truncated snapshot.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - snapshot: the snapshot to write, its ID must be set.
+//
+// Returns:
+//   - string: the path of the snapshot file.
+//   - error: an error if the snapshot could not be written.
+func WriteSnapshot(dir string, snapshot Snapshot) (string, error) {
+	if snapshot.ID == "" {
+		return "", fmt.Errorf("snapshot has no id")
+	}
+
+	path := filepath.Join(dir, snapshot.ID+".json")
+	if err := WriteSnapshotFile(path, snapshot); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// WriteSnapshotFile writes the snapshot to the given file, replacing it, through a temporary file
+// in the same directory so a crash never leaves a truncated snapshot. Unlike WriteSnapshot, the
+// file may be named otherwise than after the id, e.g. a legacy snapshot.
+//
+// Parameters:
+//   - path: the snapshot file.
+//   - snapshot: the snapshot to write.
+//
+// Returns:
+//   - error: an error if the snapshot could not be written.
+func WriteSnapshotFile(path string, snapshot Snapshot) error {
+	jsonData, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(jsonData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ListSnapshots reads every snapshot in the given directory, sorted from oldest to newest.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//
+// Returns:
+//   - []Snapshot: the snapshots found in the directory.
+//   - error: an error if the directory or one of the snapshots cannot be read.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	snapshots, unreadable, err := ReadSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(unreadable) > 0 {
+		return nil, unreadable[0]
+	}
+	return snapshots, nil
+}
+
+// ReadSnapshots reads every snapshot in the given directory that can be read, sorted from oldest
+// to newest, and reports the others instead of failing, e.g. for a collector receiving files
+// from many devices.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//
+// Returns:
+//   - []Snapshot: the readable snapshots found in the directory.
+//   - []error: an error for each snapshot that cannot be read, naming its file.
+//   - error: an error if the directory cannot be read.
+func ReadSnapshots(dir string) ([]Snapshot, []error, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var snapshots []Snapshot
+	var unreadable []error
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		snapshot, err := ReadSnapshot(path)
+		if err != nil {
+			unreadable = append(unreadable, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+
+	return snapshots, unreadable, nil
+}
+
+// FindSnapshot looks up a snapshot by reference. A reference is, in order of precedence, a
+// snapshot id, a tag (the newest snapshot carrying it), or an unambiguous prefix of an id or
+// of a root hash.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - ref: the reference given by the user.
+//
+// Returns:
+//   - Snapshot: the snapshot the reference points to.
+//   - error: an error if no snapshot or more than one snapshot matches.
+func FindSnapshot(dir string, ref string) (Snapshot, error) {
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return MatchSnapshot(snapshots, ref)
+}
+
+// MatchSnapshot looks up a snapshot by reference among the given snapshots, see FindSnapshot.
+//
+// Parameters:
+//   - snapshots: the snapshots, sorted from oldest to newest.
+//   - ref: the reference given by the user.
+//
+// Returns:
+//   - Snapshot: the snapshot the reference points to.
+//   - error: an error if no snapshot or more than one snapshot matches.
+func MatchSnapshot(snapshots []Snapshot, ref string) (Snapshot, error) {
+	if ref == "" {
+		return Snapshot{}, fmt.Errorf("empty snapshot reference")
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.ID == ref {
+			return snapshot, nil
+		}
+	}
+
+	// newest snapshot with the tag
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].HasTag(ref) {
+			return snapshots[i], nil
+		}
+	}
+
+	var matches []Snapshot
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.ID, ref) || strings.HasPrefix(snapshot.Hash, ref) {
+			matches = append(matches, snapshot)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Snapshot{}, fmt.Errorf("no snapshot matches %q", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return Snapshot{}, fmt.Errorf("%q matches %d snapshots, use a longer reference", ref, len(matches))
+	}
+}
+
+// ClearTag removes a tag from every snapshot of a directory except one, so the tag identifies a
+// single snapshot, e.g. "baseline". Files that cannot be read are left alone.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - tag: the tag to remove.
+//   - except: the id of the snapshot keeping the tag, empty to remove it everywhere.
+//
+// Returns:
+//   - error: an error if the directory cannot be read or a snapshot cannot be written.
+func ClearTag(dir string, tag string, except string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		snapshot, err := ReadSnapshot(filepath.Join(dir, entry.Name()))
+		if err != nil || snapshot.ID == except || !snapshot.HasTag(tag) {
+			continue
+		}
+		snapshot.Tags = slices.DeleteFunc(snapshot.Tags, func(t string) bool { return t == tag })
+		if _, err := WriteSnapshot(dir, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
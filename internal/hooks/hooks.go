@@ -0,0 +1,137 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the directories holding the hooks of each phase, under the hooks directory
+const (
+	PreSnap  = "pre-snap.d"
+	PostSnap = "post-snap.d"
+)
+
+// what a failing hook does
+const (
+	Abort  = "abort"  // stop running the hooks of the phase and fail the snapshot
+	Warn   = "warn"   // print a warning and carry on
+	Ignore = "ignore" // carry on silently
+)
+
+// DefaultTimeout is how long a hook may run when no timeout is configured
+const DefaultTimeout = time.Minute
+
+// Options configures how the hooks of a phase are run
+type Options struct {
+	Dir       string        // The hooks directory, holding pre-snap.d and post-snap.d
+	Timeout   time.Duration // Time a hook may run before it is killed, DefaultTimeout when zero
+	OnFailure string        // Abort, Warn or Ignore
+}
+
+// ValidatePolicy checks a failure policy read from the configuration, empty is valid
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case "", Abort, Warn, Ignore:
+		return nil
+	}
+	return fmt.Errorf("unknown hook failure policy %q, expected abort, warn or ignore", policy)
+}
+
+// Run runs the executables of a phase directory in lexical order, like run-parts. Hidden files,
+// backup files ending in ~ and files that are not executable are skipped. A missing directory
+// holds no hook.
+//
+// Each hook gets the environment of magma plus env, and is killed along with its children once
+// it runs longer than the timeout.
+//
+// Parameters:
+//   - opts: the hooks directory, the timeout and the failure policy.
+//   - phase: PreSnap or PostSnap.
+//   - env: extra environment variables, as "NAME=value".
+//
+// Returns:
+//   - error: the failure of a hook when the policy is Abort, nil otherwise.
+func Run(opts Options, phase string, env []string) error {
+	hooks, err := List(filepath.Join(opts.Dir, phase))
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	for _, hook := range hooks {
+		err := runHook(hook, timeout, env)
+		if err == nil {
+			continue
+		}
+
+		switch opts.OnFailure {
+		case Ignore:
+		case Warn:
+			fmt.Printf("Warning: hook %s failed: %v\n", hook, err)
+		default:
+			return fmt.Errorf("hook %s failed: %w", hook, err)
+		}
+	}
+	return nil
+}
+
+// List returns the hooks of a phase directory in the order they run
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+
+		// follow symlinks, hooks are often linked from elsewhere
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		hooks = append(hooks, filepath.Join(dir, name))
+	}
+	return hooks, nil
+}
+
+// runHook runs a single hook, killing its process group on timeout
+func runHook(hook string, timeout time.Duration, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// do not wait forever on children that kept the output open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
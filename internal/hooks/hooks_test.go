@@ -0,0 +1,101 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHook creates a shell script in the phase directory of dir
+func writeHook(t *testing.T, dir string, name string, script string, mode os.FileMode) {
+	t.Helper()
+	phaseDir := filepath.Join(dir, PreSnap)
+	if err := os.MkdirAll(phaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(phaseDir, name), []byte("#!/bin/sh\n"+script+"\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun_Order(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	writeHook(t, dir, "20-second", `echo second >> "$OUT"`, 0755)
+	writeHook(t, dir, "10-first", `echo "first $MAGMA_PHASE" >> "$OUT"`, 0755)
+	writeHook(t, dir, "15-not-executable", `echo skipped >> "$OUT"`, 0644)
+	writeHook(t, dir, ".hidden", `echo skipped >> "$OUT"`, 0755)
+	writeHook(t, dir, "30-backup~", `echo skipped >> "$OUT"`, 0755)
+
+	if err := Run(Options{Dir: dir}, PreSnap, []string{"OUT=" + out, "MAGMA_PHASE=pre-snap"}); err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first pre-snap\nsecond\n" {
+		t.Errorf("Expected the executable hooks to run in order, got %q", content)
+	}
+}
+
+func TestRun_MissingDirectory(t *testing.T) {
+	if err := Run(Options{Dir: filepath.Join(t.TempDir(), "missing")}, PostSnap, nil); err != nil {
+		t.Errorf("Expected a missing directory to hold no hook, got %v", err)
+	}
+}
+
+func TestRun_Failure(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	writeHook(t, dir, "10-fail", "exit 3", 0755)
+	writeHook(t, dir, "20-after", `echo ran >> "$OUT"`, 0755)
+	env := []string{"OUT=" + out}
+
+	err := Run(Options{Dir: dir, OnFailure: Abort}, PreSnap, env)
+	if err == nil || !strings.Contains(err.Error(), "10-fail") {
+		t.Errorf("Expected the failing hook to abort, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("Expected the hooks after the failure not to run")
+	}
+
+	for _, policy := range []string{Warn, Ignore} {
+		os.Remove(out)
+		if err := Run(Options{Dir: dir, OnFailure: policy}, PreSnap, env); err != nil {
+			t.Errorf("Expected the %s policy to carry on, got %v", policy, err)
+		}
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("Expected the hooks after the failure to run with the %s policy", policy)
+		}
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	dir := t.TempDir()
+	// the child keeps the output open, the whole group must be killed
+	writeHook(t, dir, "10-slow", "sleep 30 & sleep 30", 0755)
+
+	start := time.Now()
+	err := Run(Options{Dir: dir, Timeout: 100 * time.Millisecond}, PreSnap, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the hook to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hook to be killed, took %s", elapsed)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, policy := range []string{"", Abort, Warn, Ignore} {
+		if err := ValidatePolicy(policy); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", policy, err)
+		}
+	}
+	if err := ValidatePolicy("retry"); err == nil {
+		t.Errorf("Expected retry to be rejected")
+	}
+}
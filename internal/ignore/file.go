@@ -0,0 +1,92 @@
+package ignore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrNotFound is returned when removing a pattern that is not in the ignore file
+var ErrNotFound = errors.New("pattern not found")
+
+// AddPattern appends a pattern to an ignore file, comments and other lines are left untouched.
+//
+// Parameters:
+//   - path: the ignore file, created if missing.
+//   - pattern: the pattern, in the gitignore syntax, relative to /.
+//
+// Returns:
+//   - error: an error if the pattern is not valid or already in the file, or the file cannot be
+//     written.
+func AddPattern(path string, pattern string) error {
+	if _, ok, err := Parse("/", pattern); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%q is not a pattern", pattern)
+	}
+
+	lines, err := readLines(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if slices.ContainsFunc(lines, func(line string) bool { return samePattern(line, pattern) }) {
+		return fmt.Errorf("%q is already in %s", pattern, path)
+	}
+	return writeLines(path, append(lines, pattern))
+}
+
+// RemovePattern removes every line of an ignore file holding a pattern, comments and other lines
+// are left untouched.
+//
+// Parameters:
+//   - path: the ignore file.
+//   - pattern: the pattern, as written in the file.
+//
+// Returns:
+//   - error: ErrNotFound if no line holds the pattern, or an error if the file cannot be read or
+//     written.
+func RemovePattern(path string, pattern string) error {
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(slices.Clone(lines), func(line string) bool { return samePattern(line, pattern) })
+	if len(kept) == len(lines) {
+		return fmt.Errorf("%q: %w in %s", pattern, ErrNotFound, path)
+	}
+	return writeLines(path, kept)
+}
+
+// samePattern reports whether a line holds a pattern, trailing spaces aside
+func samePattern(line string, pattern string) bool {
+	return trimTrailingSpaces(line) == trimTrailingSpaces(pattern)
+}
+
+// writeLines atomically replaces the content of a file with lines
+func writeLines(path string, lines []string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".ignore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	if _, err := temp.WriteString(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
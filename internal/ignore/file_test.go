@@ -0,0 +1,46 @@
+package ignore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddRemovePattern(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(path, []byte("# logs\n*.log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddPattern(path, "/etc/app/cache/"); err != nil {
+		t.Fatalf("AddPattern returned an error: %v", err)
+	}
+	for _, pattern := range []string{"*.log", "/etc/[abc", "# comment"} {
+		if err := AddPattern(path, pattern); err == nil {
+			t.Errorf("Expected %q to be refused", pattern)
+		}
+	}
+	if content, _ := os.ReadFile(path); string(content) != "# logs\n*.log\n/etc/app/cache/\n" {
+		t.Errorf("Expected the pattern appended after the existing lines, got %q", content)
+	}
+
+	if err := RemovePattern(path, "*.log"); err != nil {
+		t.Fatalf("RemovePattern returned an error: %v", err)
+	}
+	if err := RemovePattern(path, "*.log"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "# logs\n/etc/app/cache/\n" {
+		t.Errorf("Expected the comment to be kept, got %q", content)
+	}
+
+	// a missing file is created
+	missing := filepath.Join(t.TempDir(), "ignore")
+	if err := AddPattern(missing, "*.tmp"); err != nil {
+		t.Fatalf("AddPattern returned an error: %v", err)
+	}
+	if content, _ := os.ReadFile(missing); string(content) != "*.tmp\n" {
+		t.Errorf("Expected the new file to hold the pattern, got %q", content)
+	}
+}
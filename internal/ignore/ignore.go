@@ -0,0 +1,356 @@
+package ignore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Rule is a single pattern of an ignore file, with the semantics of a gitignore line:
+//
+//   - a leading ! re-includes what an earlier pattern ignored, \! and \# match a literal ! or #
+//   - a trailing / only matches directories
+//   - a pattern holding a / elsewhere is anchored to the base directory, a leading / included,
+//     while a pattern without one matches a name at any depth under the base
+//   - *, ?, [...] and ** are globs, a backslash escapes them, trailing spaces are dropped
+//     unless escaped
+//   - "dir/**" matches everything inside dir but not dir itself
+type Rule struct {
+	Pattern string // The line as written
+	Base    string // The directory the pattern is relative to, / for the global ignore file
+	Negate  bool   // Re-includes the matching paths
+	DirOnly bool   // Only matches directories
+	Source  string // Where the pattern comes from, the ignore file or a track entry, see AddFrom
+	Line    int    // The line of the pattern in its source file, 0 when not read from a file
+	glob    string // The doublestar pattern matched against absolute paths
+}
+
+// FileName is the name of the ignore files of a subtree, see Matcher.Extend
+const FileName = ".magmaignore"
+
+// Matcher decides whether paths are ignored, the last rule matching a path wins
+type Matcher struct {
+	rules []Rule
+}
+
+// New returns a matcher without any rule, ignoring nothing
+func New() *Matcher {
+	return &Matcher{}
+}
+
+// ReadFile reads an ignore file whose patterns are relative to /. A missing file ignores nothing.
+//
+// Parameters:
+//   - path: the ignore file.
+//
+// Returns:
+//   - *Matcher: the rules of the valid patterns, even if some are invalid.
+//   - error: an error if the file cannot be read or a pattern is invalid.
+func ReadFile(path string) (*Matcher, error) {
+	matcher := New()
+	lines, err := readLines(path)
+	if err != nil {
+		return matcher, err
+	}
+	return matcher, matcher.addLines(path, "/", lines)
+}
+
+// Add parses a line and appends its rule, blank lines and comments are skipped.
+//
+// Parameters:
+//   - base: the absolute directory the pattern is relative to.
+//   - line: the line of the ignore file.
+//
+// Returns:
+//   - error: an error if the pattern is not valid.
+func (m *Matcher) Add(base string, line string) error {
+	return m.AddFrom("", 0, base, line)
+}
+
+// AddFrom parses a line and appends its rule, recording where it comes from so Explain can tell.
+//
+// Parameters:
+//   - source: the file holding the line, or a description like "track entry /etc/nginx".
+//   - number: the number of the line in the file, 0 when not read from a file.
+//   - base: the absolute directory the pattern is relative to.
+//   - line: the line of the ignore file.
+//
+// Returns:
+//   - error: an error if the pattern is not valid.
+func (m *Matcher) AddFrom(source string, number int, base string, line string) error {
+	rule, ok, err := Parse(base, line)
+	if err != nil || !ok {
+		return err
+	}
+	rule.Source, rule.Line = source, number
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// addLines adds the rules of the lines of a file, numbered from 1. The valid patterns are added
+// even if some are invalid.
+func (m *Matcher) addLines(path string, base string, lines []string) error {
+	var errs []error
+	for i, line := range lines {
+		if err := m.AddFrom(path, i+1, base, line); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, i+1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Extend returns the matcher for the subtree of a directory: the rules of m followed by those of
+// the FileName file of the directory, which take precedence. Without such a file, m itself is
+// returned. A file that others than root could have written is not honoured, see checkOwner.
+//
+// Parameters:
+//   - dir: the absolute directory.
+//
+// Returns:
+//   - *Matcher: the matcher of the subtree, m is left unchanged.
+//   - error: an error if the file cannot be read, is not trusted or holds an invalid pattern, the
+//     valid patterns of a trusted file still apply.
+func (m *Matcher) Extend(dir string) (*Matcher, error) {
+	path := filepath.Join(dir, FileName)
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := checkOwner(path, info); err != nil {
+		return m, err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return m, err
+	}
+
+	extended := m.Clone()
+	return extended, extended.addLines(path, dir, lines)
+}
+
+// Clone returns a copy of the matcher, rules added to the copy do not change m
+func (m *Matcher) Clone() *Matcher {
+	if m == nil {
+		return New()
+	}
+	return &Matcher{rules: slices.Clone(m.rules)}
+}
+
+// Rules returns the rules of the matcher, in the order they apply
+func (m *Matcher) Rules() []Rule {
+	return m.rules
+}
+
+// Parse parses a line of an ignore file.
+//
+// Parameters:
+//   - base: the absolute directory the pattern is relative to.
+//   - line: the line of the ignore file.
+//
+// Returns:
+//   - Rule: the rule of the line.
+//   - bool: false if the line is blank or a comment and holds no rule.
+//   - error: an error if the pattern is not valid.
+func Parse(base string, line string) (Rule, bool, error) {
+	rule := Rule{Pattern: line, Base: base}
+
+	pattern := trimTrailingSpaces(line)
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule, false, nil
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.Negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.DirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, false, fmt.Errorf("pattern %q matches nothing", line)
+	}
+
+	// a slash other than a trailing one anchors the pattern to the base directory
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+	// doublestar also matches the directory itself with a trailing /**
+	if pattern == "**" || strings.HasSuffix(pattern, "/**") {
+		pattern += "/*"
+	}
+
+	rule.glob = escape(strings.TrimSuffix(base, "/")) + "/" + pattern
+	if !doublestar.ValidatePattern(rule.glob) {
+		return rule, false, fmt.Errorf("pattern %q is not valid", line)
+	}
+	return rule, true, nil
+}
+
+// String returns the rule as its source, line and pattern, e.g. /etc/magma/ignore:3: *.log
+func (r Rule) String() string {
+	switch {
+	case r.Source == "":
+		return r.Pattern
+	case r.Line == 0:
+		return r.Source + ": " + r.Pattern
+	}
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+}
+
+// Match reports whether the rule matches a path, regardless of negation
+func (r Rule) Match(path string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+	match, _ := doublestar.Match(r.glob, path)
+	return match
+}
+
+// Match reports whether a path is ignored. Like git, a path inside an ignored directory cannot be
+// re-included, magma does not look into ignored directories.
+//
+// Parameters:
+//   - path: the absolute, clean path.
+//   - isDir: whether the path is a directory, for the patterns ending in /.
+//
+// Returns:
+//   - bool: true if the path is ignored.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	_, ignored := m.Explain(path, isDir)
+	return ignored
+}
+
+// Explain returns the rule deciding whether a path is ignored: the rule ignoring one of its parent
+// directories, or else the last rule matching the path, a negation when the path is re-included.
+// A FileName file is only ignored with its parent directory.
+//
+// Parameters:
+//   - path: the absolute, clean path.
+//   - isDir: whether the path is a directory, for the patterns ending in /.
+//
+// Returns:
+//   - *Rule: the deciding rule, nil when no rule matches the path.
+//   - bool: true if the path is ignored.
+func (m *Matcher) Explain(path string, isDir bool) (*Rule, bool) {
+	return m.ExplainUnder("/", path, isDir)
+}
+
+// ExplainUnder is Explain for a path hashed as part of a tracked path: only the directories
+// between the tracked path and the path can ignore it, so tracking /root/.ssh/authorized_keys
+// hashes it even though a rule like **/.* ignores /root/.ssh.
+//
+// Parameters:
+//   - root: the tracked path, the path itself or one of its parent directories.
+//   - path: the absolute, clean path.
+//   - isDir: whether the path is a directory, for the patterns ending in /.
+//
+// Returns:
+//   - *Rule: the deciding rule, nil when no rule matches the path.
+//   - bool: true if the path is ignored.
+func (m *Matcher) ExplainUnder(root string, path string, isDir bool) (*Rule, bool) {
+	if m == nil || len(m.rules) == 0 {
+		return nil, false
+	}
+	for _, dir := range ancestors(root, path) {
+		if rule := m.lastMatch(dir, true); rule != nil && !rule.Negate {
+			return rule, true
+		}
+	}
+	// the ignore files themselves are always hashed, so a change to what is ignored shows as drift
+	if !isDir && filepath.Base(path) == FileName {
+		return nil, false
+	}
+	rule := m.lastMatch(path, isDir)
+	return rule, rule != nil && !rule.Negate
+}
+
+// lastMatch returns the last rule matching a path, without looking at its parent directories
+func (m *Matcher) lastMatch(path string, isDir bool) *Rule {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if m.rules[i].Match(path, isDir) {
+			rule := m.rules[i]
+			return &rule
+		}
+	}
+	return nil
+}
+
+// ancestors returns the directories above a path and under root, outermost first, root and /
+// excluded
+func ancestors(root string, path string) []string {
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	var dirs []string
+	for dir := filepath.Dir(path); dir != "/" && dir != "." && strings.HasPrefix(dir, prefix); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+	return dirs
+}
+
+// checkOwner returns an error unless a FileName file is a regular file owned by root, or by the
+// user running magma, and not writable by its group or by others, so an unprivileged user cannot
+// hide files from the snapshots
+func checkOwner(path string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s not honoured: not a regular file", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s not honoured: writable by group or others (%v)", path, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s not honoured: owned by uid %d, not root", path, stat.Uid)
+	}
+	return nil
+}
+
+// readLines reads every line of a file, blank lines and comments included so lines keep their number.
+// The \r of files written with CRLF line endings is dropped.
+func readLines(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines, nil
+}
+
+// trimTrailingSpaces drops the trailing spaces of a line, except one escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	trimmed := strings.TrimRight(line, " ")
+	if len(trimmed) < len(line) && strings.HasSuffix(trimmed, `\`) && !strings.HasSuffix(trimmed, `\\`) {
+		return trimmed + " "
+	}
+	return trimmed
+}
+
+// escape escapes the glob characters of a literal path
+func escape(path string) string {
+	var escaped strings.Builder
+	for _, c := range path {
+		if strings.ContainsRune(`\*?[]{}`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
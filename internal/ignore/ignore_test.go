@@ -0,0 +1,258 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newMatcher returns a matcher of patterns relative to base
+func newMatcher(t *testing.T, base string, patterns ...string) *Matcher {
+	t.Helper()
+	matcher := New()
+	for _, pattern := range patterns {
+		if err := matcher.Add(base, pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return matcher
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		// absolute patterns, as written before the gitignore semantics
+		{[]string{"/etc/magma"}, "/etc/magma", true, true},
+		{[]string{"/etc/magma"}, "/etc/magma/track", false, true},
+		{[]string{"**/.*"}, "/etc/app/.git", true, true},
+		{[]string{"**/*.log"}, "/var/log/app/x.log", false, true},
+
+		// a pattern without a slash matches at any depth
+		{[]string{"*.log"}, "/var/log/x.log", false, true},
+		{[]string{"*.log"}, "/var/log/x.log.1", false, false},
+		{[]string{"cache"}, "/var/lib/app/cache", true, true},
+
+		// a slash anchors the pattern
+		{[]string{"etc/*.conf"}, "/etc/a.conf", false, true},
+		{[]string{"etc/*.conf"}, "/opt/etc/a.conf", false, false},
+		{[]string{"/etc/*.conf"}, "/etc/sub/a.conf", false, false},
+
+		// directories only
+		{[]string{"logs/"}, "/var/app/logs", true, true},
+		{[]string{"logs/"}, "/var/app/logs", false, false},
+		{[]string{"logs/"}, "/var/app/logs/a", false, true},
+
+		// dir/** matches the content, not the directory
+		{[]string{"/etc/app/**"}, "/etc/app", true, false},
+		{[]string{"/etc/app/**"}, "/etc/app/a/b", false, true},
+
+		// negation, the last matching pattern wins
+		{[]string{"/etc/app/*", "!/etc/app/main.conf"}, "/etc/app/main.conf", false, false},
+		{[]string{"/etc/app/*", "!/etc/app/main.conf"}, "/etc/app/other.conf", false, true},
+		{[]string{"!/etc/app/main.conf", "/etc/app/*"}, "/etc/app/main.conf", false, true},
+
+		// a path under an ignored directory cannot be re-included
+		{[]string{"/etc/app", "!/etc/app/main.conf"}, "/etc/app/main.conf", false, true},
+
+		// escaping
+		{[]string{`\!important`}, "/etc/!important", false, true},
+		{[]string{`\#notes`}, "/etc/#notes", false, true},
+		{[]string{`/etc/\*`}, "/etc/*", false, true},
+		{[]string{`/etc/\*`}, "/etc/hosts", false, false},
+		{[]string{"trailing.conf   "}, "/etc/trailing.conf", false, true},
+		{[]string{`space\ `}, "/etc/space ", false, true},
+	}
+
+	for _, test := range tests {
+		matcher := newMatcher(t, "/", test.patterns...)
+		if ignored := matcher.Match(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("%q on %s (dir %v): expected ignored %v, got %v", test.patterns, test.path, test.isDir, test.ignored, ignored)
+		}
+	}
+}
+
+func TestMatch_Base(t *testing.T) {
+	matcher := newMatcher(t, "/etc/a[1]", "*.bak", "/local")
+
+	if !matcher.Match("/etc/a[1]/sub/x.bak", false) || !matcher.Match("/etc/a[1]/local", true) {
+		t.Errorf("Expected the patterns to apply under their base")
+	}
+	if matcher.Match("/etc/x.bak", false) || matcher.Match("/etc/a[1]/sub/local", true) {
+		t.Errorf("Expected the patterns not to apply outside their base")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment"} {
+		if _, ok, err := Parse("/", line); ok || err != nil {
+			t.Errorf("Expected %q to hold no rule, got %v, %v", line, ok, err)
+		}
+	}
+	for _, line := range []string{"/etc/[abc", "!", "/"} {
+		if _, _, err := Parse("/", line); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+
+	rule, ok, err := Parse("/", "!logs/")
+	if !ok || err != nil || !rule.Negate || !rule.DirOnly || rule.Pattern != "!logs/" {
+		t.Errorf("Unexpected rule %+v, %v, %v", rule, ok, err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(path, []byte("# comment\n*.log\n/etc/[abc\n\n!keep.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := ReadFile(path)
+	if err == nil {
+		t.Errorf("Expected the invalid pattern to be reported")
+	}
+	if len(matcher.Rules()) != 2 || !matcher.Match("/var/x.log", false) || matcher.Match("/var/keep.log", false) {
+		t.Errorf("Expected the valid patterns to apply, got %+v", matcher.Rules())
+	}
+}
+
+func TestReadFile_CRLF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(path, []byte("# comment\r\n*.log\r\ncache/\r\n!keep.log\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	matcher, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned an error: %v", err)
+	}
+	if !matcher.Match("/var/x.log", false) || matcher.Match("/var/keep.log", false) || !matcher.Match("/srv/cache", true) {
+		t.Errorf("Expected the CRLF lines to match like LF lines, got %+v", matcher.Rules())
+	}
+	if rule, _ := matcher.Explain("/var/x.log", false); rule == nil || rule.Pattern != "*.log" || rule.Line != 2 {
+		t.Errorf("Expected *.log on line 2 to decide, got %v", rule)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(path, []byte("# app\n/etc/app/*\n!/etc/app/main.conf\n\n/var/cache/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		rule    string
+		ignored bool
+	}{
+		{"/etc/app/other.conf", path + ":2: /etc/app/*", true},
+		{"/etc/app/main.conf", path + ":3: !/etc/app/main.conf", false},
+		{"/var/cache/app/data", path + ":5: /var/cache/", true},
+		{"/etc/hosts", "", false},
+	}
+	for _, test := range tests {
+		rule, ignored := matcher.Explain(test.path, false)
+		got := ""
+		if rule != nil {
+			got = rule.String()
+		}
+		if got != test.rule || ignored != test.ignored {
+			t.Errorf("%s: expected %q (ignored %v), got %q (ignored %v)", test.path, test.rule, test.ignored, got, ignored)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	dir := t.TempDir()
+	global := newMatcher(t, "/", "*.log")
+
+	// no file, the same rules apply
+	if extended, err := global.Extend(dir); err != nil || extended != global {
+		t.Errorf("Expected the matcher to be returned as is, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("!keep.log\n/local/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	extended, err := global.Extend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extended.Match(filepath.Join(dir, "keep.log"), false) || !extended.Match("/var/keep.log", false) {
+		t.Errorf("Expected the re-inclusion to only apply under %s", dir)
+	}
+	if !extended.Match(filepath.Join(dir, "local"), true) || extended.Match(filepath.Join(dir, "sub", "local"), true) {
+		t.Errorf("Expected the anchored pattern to be relative to %s", dir)
+	}
+	if len(global.Rules()) != 1 {
+		t.Errorf("Expected the original matcher to be left unchanged")
+	}
+}
+
+func TestExtend_Untrusted(t *testing.T) {
+	dir := t.TempDir()
+	global := newMatcher(t, "/", "*.log")
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte("*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file others can write is not honoured
+	if err := os.Chmod(path, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if extended, err := global.Extend(dir); err == nil || extended != global {
+		t.Errorf("Expected a world-writable %s to be rejected, got %v", FileName, err)
+	}
+	if err := os.Chmod(path, 0664); err != nil {
+		t.Fatal(err)
+	}
+	if extended, err := global.Extend(dir); err == nil || extended != global {
+		t.Errorf("Expected a group-writable %s to be rejected, got %v", FileName, err)
+	}
+
+	// nor is a file owned by another user, only root can hand it over
+	if os.Geteuid() != 0 {
+		return
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if extended, err := global.Extend(dir); err == nil || extended != global {
+		t.Errorf("Expected a %s owned by another user to be rejected, got %v", FileName, err)
+	}
+}
+
+func TestExplainUnder(t *testing.T) {
+	matcher := New()
+	if err := matcher.Add("/", "**/.*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ignored := matcher.Explain("/root/.ssh/authorized_keys", false); !ignored {
+		t.Errorf("Expected Explain to ignore a path under a hidden directory")
+	}
+	if _, ignored := matcher.ExplainUnder("/root/.ssh/authorized_keys", "/root/.ssh/authorized_keys", false); ignored {
+		t.Errorf("Expected the directories above the tracked path not to ignore it")
+	}
+	if _, ignored := matcher.ExplainUnder("/home/u", "/home/u/.config/app/x.conf", false); !ignored {
+		t.Errorf("Expected the hidden directories under the tracked path to ignore it")
+	}
+
+	// the ignore files are hashed even though **/.* matches them, unless their directory is ignored
+	if rule, ignored := matcher.ExplainUnder("/etc", "/etc/nginx/"+FileName, false); ignored {
+		t.Errorf("Expected %s not to be ignored, got %v", FileName, rule)
+	}
+	if _, ignored := matcher.ExplainUnder("/home/u", "/home/u/.config/"+FileName, false); !ignored {
+		t.Errorf("Expected %s in an ignored directory to be ignored", FileName)
+	}
+}
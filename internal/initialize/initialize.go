@@ -4,9 +4,24 @@ import (
 	"bufio"
 	"fmt"
 	"magma/internal/config"
+	"magma/internal/hooks"
+	"magma/internal/parsing"
 	"os"
+	"path/filepath"
 )
 
+// DefaultIgnore holds the lines written to a freshly created ignore file
+var DefaultIgnore = []string{
+	"# self directory",
+	"/etc/magma",
+	"# any hidden files or directories that start with a dot",
+	"**/.*",
+	"# patterns follow the gitignore syntax, a pattern without a slash matches at any depth",
+	"# example: *.log to ignore all log files",
+	"# example: logs/ to ignore all directories named logs",
+	"# example: /etc/app/* then !/etc/app/main.conf to only hash main.conf in /etc/app",
+}
+
 // initializes the /etc/magma directory, track file and snapshots directory
 func Initialize() error {
 	// check the existence of the /etc/magma directory
@@ -39,6 +54,14 @@ func Initialize() error {
 		}
 	}
 
+	// create the hooks directories, MkdirAll leaves existing ones alone
+	for _, phase := range []string{hooks.PreSnap, hooks.PostSnap} {
+		err = os.MkdirAll(filepath.Join(config.HooksDir, phase), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
 	// check the existence of the /etc/magma/config.yaml file
 	_, err = os.Stat(config.ConfigFile)
 	if os.IsNotExist(err) {
@@ -54,12 +77,36 @@ func Initialize() error {
 		lines := []string{
 			"# Configuration file for magma, device specific configurations",
 			"device_id: \"\"",
+			"# tags that can only be on one snapshot, tagging another snapshot moves them",
+			"unique_tags: [\"baseline\"]",
+			"# snapshots kept by 'magma prune', set a rule to 0 to disable it",
+			"retention:",
+			"  keep_last: 10",
+			"  keep_daily: 7",
+			"  keep_weekly: 4",
+			"  keep_monthly: 6",
+			"  pinned_tags: [\"baseline\"]",
+			"# when 'magma daemon' takes snapshots, set either cron (e.g. \"0 */6 * * *\") or interval (e.g. 6h)",
+			"schedule:",
+			"  cron: \"0 3 * * *\"",
+			"  jitter: 10m",
+			"  skip_if_unchanged: true",
+			"  tags: [\"scheduled\"]",
+			"# executables in /etc/magma/hooks/pre-snap.d and post-snap.d, a failure aborts, warns or is ignored",
+			"hooks:",
+			"  timeout: 1m",
+			"  pre_failure: abort",
+			"  post_failure: warn",
+			"# node_exporter textfile collector directory the metrics are written to, e.g. /var/lib/node_exporter/textfile_collector",
+			"metrics:",
+			"  textfile_dir: \"\"",
 		}
 
 		// Create a writer
 		writer := bufio.NewWriter(file)
-		writer.WriteString(lines[0] + "\n")
-		writer.WriteString(lines[1] + "\n")
+		for _, line := range lines {
+			writer.WriteString(line + "\n")
+		}
 
 		// Flush the writer to ensure all data is written to the file
 		err = writer.Flush()
@@ -85,14 +132,7 @@ func Initialize() error {
 		// Create a writer
 		writer := bufio.NewWriter(file)
 
-		lines := []string{
-			"# self directory",
-			"/etc/magma",
-			"# any hidden files or directories that start with a dot",
-			"**/.*",
-			"# example: **/*.log to ignore all log files",
-			"# example: **/logs/* to ignore all files in the logs directory",
-		}
+		lines := DefaultIgnore
 
 		// Write each line to the file
 		for _, line := range lines {
@@ -119,5 +159,22 @@ func Initialize() error {
 		}
 	}
 
+	// check the existence of the /etc/magma/policy file
+	_, err = os.Stat(config.PolicyFile)
+	if os.IsNotExist(err) {
+		lines := []string{
+			"# expectations on tracked paths, evaluated by 'magma status' and 'magma verify'",
+			"# format: <expectation> <pattern> [severity], the last matching line wins",
+			"# expectations: immutable, content-only, append-only, metadata-watched, ignored",
+			"# severities: info, warning, critical",
+			"# example: immutable /etc/ssh/**",
+			"# example: append-only /var/log/app/*.log",
+			"# example: metadata-watched /etc/sudoers",
+		}
+		if err := parsing.WriteTrack(lines, config.PolicyFile); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"magma/internal/hashing"
+	"magma/internal/policy"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the files written to a node_exporter textfile collector directory, one per group of metrics so
+// 'magma snap' and 'magma verify' do not overwrite each other
+const (
+	SnapshotTextfile = "magma_snapshot.prom"
+	DriftTextfile    = "magma_drift.prom"
+)
+
+// Registry holds the metrics of the snapshots taken and of the drift found by this process, in
+// the Prometheus text format
+type Registry struct {
+	mu sync.Mutex
+
+	// snapshots, a run skipped because nothing changed counts as successful
+	snapshotObserved bool
+	runs             int
+	failures         int
+	lastFailed       bool
+	duration         time.Duration
+	files            int
+	bytes            int64
+	lastSuccess      time.Time
+
+	// drift of the latest check, by severity
+	driftObserved bool
+	drift         map[policy.Severity]int
+	lastCheck     time.Time
+}
+
+// New returns an empty registry
+func New() *Registry {
+	return &Registry{drift: map[policy.Severity]int{}}
+}
+
+// SetLastSuccess records when a snapshot last succeeded, e.g. the creation of the latest snapshot
+// on disk, so one-shot commands report it even when they fail
+func (r *Registry) SetLastSuccess(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSuccess = t
+}
+
+// ObserveSnapshot records a snapshot run.
+//
+// Parameters:
+//   - snapshot: the snapshot taken, or the latest one if the run was skipped.
+//   - duration: how long hashing and writing the snapshot took.
+//   - err: the error of the run, nil if it succeeded.
+func (r *Registry) ObserveSnapshot(snapshot hashing.Snapshot, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshotObserved = true
+	r.runs++
+	r.duration = duration
+	r.lastFailed = err != nil
+	if err != nil {
+		r.failures++
+		return
+	}
+	r.files, r.bytes = Count(snapshot.Node)
+	r.lastSuccess = time.Now()
+}
+
+// ObserveDrift records the findings of a drift check, replacing those of the previous check
+func (r *Registry) ObserveDrift(findings []policy.Finding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.driftObserved = true
+	r.lastCheck = time.Now()
+	r.drift = map[policy.Severity]int{}
+	for _, finding := range findings {
+		r.drift[finding.Severity]++
+	}
+}
+
+// Count returns the number of regular files of a tree and their total size, that is the files
+// hashed and the bytes read to take it
+func Count(node hashing.Node) (int, int64) {
+	if strings.HasPrefix(node.Mode, "-") {
+		return 1, node.Size
+	}
+	files, bytes := 0, int64(0)
+	for _, child := range node.Children {
+		childFiles, childBytes := Count(child)
+		files += childFiles
+		bytes += childBytes
+	}
+	return files, bytes
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.writeSnapshot(w)
+	r.writeDrift(w)
+}
+
+// WriteTextfiles writes the observed metrics to a node_exporter textfile collector directory,
+// SnapshotTextfile if a snapshot was observed and DriftTextfile if drift was. The files are
+// replaced atomically so node_exporter never reads a partial file.
+//
+// Parameters:
+//   - dir: the directory of the textfile collector, e.g. /var/lib/node_exporter/textfile_collector.
+//
+// Returns:
+//   - error: an error if a file cannot be written.
+func (r *Registry) WriteTextfiles(dir string) error {
+	r.mu.Lock()
+	snapshotObserved, driftObserved := r.snapshotObserved, r.driftObserved
+	r.mu.Unlock()
+
+	if snapshotObserved {
+		if err := writeFile(filepath.Join(dir, SnapshotTextfile), r.writeSnapshot); err != nil {
+			return err
+		}
+	}
+	if driftObserved {
+		return writeFile(filepath.Join(dir, DriftTextfile), r.writeDrift)
+	}
+	return nil
+}
+
+// writeSnapshot writes the snapshot metrics, only the last success is known before a run
+func (r *Registry) writeSnapshot(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.lastSuccess.IsZero() {
+		gauge(w, "magma_last_success_timestamp_seconds", "Time of the last successful snapshot run, skipped unchanged runs included.", float64(r.lastSuccess.UnixMilli())/1000)
+	}
+	if !r.snapshotObserved {
+		return
+	}
+	counter(w, "magma_snapshot_runs_total", "Snapshot runs by this process.", r.runs)
+	counter(w, "magma_snapshot_errors_total", "Snapshot runs by this process that failed.", r.failures)
+	success := 1
+	if r.lastFailed {
+		success = 0
+	}
+	gauge(w, "magma_snapshot_success", "Whether the last snapshot run succeeded.", float64(success))
+	gauge(w, "magma_snapshot_duration_seconds", "Duration of the last snapshot run.", r.duration.Seconds())
+	gauge(w, "magma_snapshot_files_hashed", "Regular files hashed by the last successful snapshot run.", float64(r.files))
+	gauge(w, "magma_snapshot_bytes_read", "Bytes read by the last successful snapshot run.", float64(r.bytes))
+}
+
+// writeDrift writes the drift metrics, every severity is listed so alerts see zeros
+func (r *Registry) writeDrift(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.driftObserved {
+		return
+	}
+	fmt.Fprintln(w, "# HELP magma_drift_files Files drifted from the reference snapshot at the last check, by severity.")
+	fmt.Fprintln(w, "# TYPE magma_drift_files gauge")
+	for _, severity := range []policy.Severity{policy.Info, policy.Warning, policy.Critical} {
+		fmt.Fprintf(w, "magma_drift_files{severity=%q} %d\n", severity.String(), r.drift[severity])
+	}
+	gauge(w, "magma_drift_check_timestamp_seconds", "Time of the last drift check.", float64(r.lastCheck.UnixMilli())/1000)
+}
+
+// gauge writes a gauge without labels
+func gauge(w io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// counter writes a counter without labels
+func counter(w io.Writer, name string, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// writeFile atomically replaces a file with the output of write
+func writeFile(path string, write func(io.Writer)) error {
+	// node_exporter only reads files ending in .prom
+	temp, err := os.CreateTemp(filepath.Dir(path), ".magma-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	write(temp)
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
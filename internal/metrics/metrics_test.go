@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"errors"
+	"magma/internal/hashing"
+	"magma/internal/policy"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSnapshot returns a snapshot of a directory holding two files and a symlink
+func newSnapshot() hashing.Snapshot {
+	return hashing.Snapshot{Node: hashing.Node{Path: "root", Children: []hashing.Node{
+		{Path: "/etc", Mode: "drwxr-xr-x", Size: 4096, Children: []hashing.Node{
+			{Path: "/etc/hosts", Mode: "-rw-r--r--", Size: 100},
+			{Path: "/etc/passwd", Mode: "-rw-r--r--", Size: 1000},
+			{Path: "/etc/localtime", Mode: "Lrwxrwxrwx", Size: 30},
+		}},
+	}}}
+}
+
+func TestCount(t *testing.T) {
+	files, bytes := Count(newSnapshot().Node)
+	if files != 2 || bytes != 1100 {
+		t.Errorf("Expected 2 files and 1100 bytes, got %d and %d", files, bytes)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	registry := New()
+	registry.ObserveSnapshot(newSnapshot(), 1500*time.Millisecond, nil)
+	registry.ObserveSnapshot(hashing.Snapshot{}, time.Second, errors.New("permission denied"))
+	registry.ObserveDrift([]policy.Finding{{Severity: policy.Critical}, {Severity: policy.Critical}, {Severity: policy.Info}})
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, expected := range []string{
+		"magma_snapshot_runs_total 2\n",
+		"magma_snapshot_errors_total 1\n",
+		"magma_snapshot_success 0\n",
+		"magma_snapshot_duration_seconds 1\n",
+		"magma_snapshot_files_hashed 2\n",
+		"magma_snapshot_bytes_read 1100\n",
+		"# TYPE magma_last_success_timestamp_seconds gauge\n",
+		`magma_drift_files{severity="info"} 1` + "\n",
+		`magma_drift_files{severity="warning"} 0` + "\n",
+		`magma_drift_files{severity="critical"} 2` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in the metrics, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestWriteTextfiles(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	// a failed snap still reports when the latest snapshot was taken
+	registry := New()
+	registry.SetLastSuccess(created)
+	registry.ObserveSnapshot(hashing.Snapshot{}, time.Second, errors.New("permission denied"))
+	if err := registry.WriteTextfiles(dir); err != nil {
+		t.Fatalf("WriteTextfiles returned an error: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, SnapshotTextfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "magma_last_success_timestamp_seconds 1706702400\n") {
+		t.Errorf("Expected the last success in the textfile, got:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, DriftTextfile)); !os.IsNotExist(err) {
+		t.Errorf("Expected no drift textfile without a drift check")
+	}
+
+	// a drift check leaves the snapshot textfile alone
+	registry = New()
+	registry.ObserveDrift(nil)
+	if err := registry.WriteTextfiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, SnapshotTextfile)); string(again) != string(content) {
+		t.Errorf("Expected the snapshot textfile to be kept")
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, DriftTextfile)); err != nil || !strings.Contains(string(content), `magma_drift_files{severity="critical"} 0`) {
+		t.Errorf("Expected the drift textfile, got %q, %v", content, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected only the two textfiles, got %d files", len(entries))
+	}
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"magma/internal/config"
+	"magma/internal/policy"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxLines bounds the number of findings listed in text messages
+const maxLines = 50
+
+// Payload is the JSON body posted to generic webhooks
+type Payload struct {
+	Device    string    `json:"device"`
+	Source    string    `json:"source"`
+	Reference string    `json:"reference"`
+	Time      time.Time `json:"time"`
+	Worst     string    `json:"worst"`
+	Findings  []Finding `json:"findings"`
+}
+
+// Finding is a single drift finding of a payload
+type Finding struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	Reason   string `json:"reason"`
+	Rule     string `json:"rule,omitempty"` // The matching rule, e.g. "immutable /etc/ssh/**"
+}
+
+// newPayload converts an event to the webhook payload
+func newPayload(event Event) Payload {
+	payload := Payload{Device: event.Device, Source: event.Source, Reference: event.Reference, Time: event.Time, Worst: worst(event).String()}
+	for _, finding := range event.Findings {
+		result := Finding{Path: finding.Change.Path, Kind: string(finding.Change.Kind), Severity: finding.Severity.String(), Reason: finding.Reason}
+		if finding.Rule != nil {
+			result.Rule = finding.Rule.Kind + " " + finding.Rule.Pattern
+		}
+		payload.Findings = append(payload.Findings, result)
+	}
+	return payload
+}
+
+// summary renders an event as text, bold and code are the markup of the target, empty for plain text
+func summary(event Event, bold string, code string) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s%d drift finding(s) on %s%s, worst %s, detected by magma %s against snapshot %s\n",
+		bold, len(event.Findings), event.Device, bold, worst(event), event.Source, event.Reference)
+
+	for i, finding := range event.Findings {
+		if i == maxLines {
+			fmt.Fprintf(&text, "... and %d more\n", len(event.Findings)-maxLines)
+			break
+		}
+		fmt.Fprintf(&text, "%s %s %s%s%s: %s\n", finding.Severity, finding.Change.Kind, code, finding.Change.Path, code, finding.Reason)
+	}
+	return text.String()
+}
+
+// mail renders an event as an email
+func mail(smtp config.SMTPConfig, event Event) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", smtp.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(smtp.To, ", "))
+	fmt.Fprintf(&message, "Subject: [magma] %s drift on %s\r\n", worst(event), event.Device)
+	fmt.Fprintf(&message, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(summary(event, "", ""), "\n", "\r\n"))
+	return []byte(message.String())
+}
+
+// worst returns the highest severity of the findings of an event
+func worst(event Event) policy.Severity {
+	highest := event.Findings[0].Severity
+	for _, finding := range event.Findings {
+		highest = max(highest, finding.Severity)
+	}
+	return highest
+}
+
+// post sends a JSON body to a URL
+func (n *Notifier) post(url string, headers map[string]string, body any) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	return nil
+}
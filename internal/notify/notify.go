@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"magma/internal/config"
+	"magma/internal/policy"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the kinds of channels
+const (
+	Webhook = "webhook" // JSON payload posted to a URL
+	Slack   = "slack"   // Slack incoming webhook
+	SMTP    = "smtp"    // Email
+)
+
+// Event is drift detected by a command
+type Event struct {
+	Device    string           // The device id
+	Source    string           // The command that detected the drift: status, verify or watch
+	Reference string           // The id of the snapshot the live state was compared against
+	Time      time.Time        // When the drift was detected
+	Findings  []policy.Finding // The drift
+}
+
+// channel is a configured channel with its minimum severity parsed
+type channel struct {
+	config.ChannelConfig
+	minSeverity policy.Severity
+	rateLimit   time.Duration
+}
+
+// Notifier sends drift to the configured channels
+type Notifier struct {
+	channels  []channel
+	stateFile string // Records when each channel was last notified, for rate limiting
+
+	now      func() time.Time
+	client   *http.Client
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// New validates the notification channels of the configuration.
+//
+// Parameters:
+//   - notify: the notify section of the configuration.
+//   - stateFile: the file recording when each channel was last notified.
+//
+// Returns:
+//   - *Notifier: the notifier.
+//   - error: an error if a channel is invalid.
+func New(notify config.NotifyConfig, stateFile string) (*Notifier, error) {
+	notifier := &Notifier{
+		stateFile: stateFile,
+		now:       time.Now,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		sendMail:  smtp.SendMail,
+	}
+
+	names := map[string]bool{}
+	for i, channelConfig := range notify.Channels {
+		c := channel{ChannelConfig: channelConfig, rateLimit: notify.RateLimit}
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("%s-%d", c.Type, i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("notification channel %s is defined twice", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.MinSeverity != "" {
+			severity, err := policy.ParseSeverity(c.MinSeverity)
+			if err != nil {
+				return nil, fmt.Errorf("notification channel %s: %v", c.Name, err)
+			}
+			c.minSeverity = severity
+		}
+		if c.RateLimit != 0 {
+			c.rateLimit = c.RateLimit
+		}
+
+		switch c.Type {
+		case Webhook, Slack:
+			if c.URL == "" {
+				return nil, fmt.Errorf("notification channel %s: url is required", c.Name)
+			}
+		case SMTP:
+			if c.SMTP.Host == "" || c.SMTP.From == "" || len(c.SMTP.To) == 0 {
+				return nil, fmt.Errorf("notification channel %s: smtp host, from and to are required", c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("notification channel %s: unknown type %q, expected webhook, slack or smtp", c.Name, c.Type)
+		}
+		notifier.channels = append(notifier.channels, c)
+	}
+	return notifier, nil
+}
+
+// Notify sends the findings of the event at or above the minimum severity of each channel. A
+// channel notified less than its rate limit ago is skipped, the drift is reported again by the
+// first notification after the limit.
+//
+// Parameters:
+//   - event: the drift to report.
+//
+// Returns:
+//   - error: the errors of the channels that failed, the other channels are still notified.
+func (n *Notifier) Notify(event Event) error {
+	if len(n.channels) == 0 || len(event.Findings) == 0 {
+		return nil
+	}
+
+	state, err := n.readState()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	changed := false
+	now := n.now()
+	for _, c := range n.channels {
+		var findings []policy.Finding
+		for _, finding := range event.Findings {
+			if finding.Severity >= c.minSeverity {
+				findings = append(findings, finding)
+			}
+		}
+		if len(findings) == 0 {
+			continue
+		}
+
+		if last, found := state[c.Name]; found && now.Sub(last) < c.rateLimit {
+			log.Printf("Notification to %s suppressed, last sent %s ago", c.Name, now.Sub(last).Round(time.Second))
+			continue
+		}
+
+		routed := event
+		routed.Findings = findings
+		if err := n.send(c, routed); err != nil {
+			errs = append(errs, fmt.Errorf("notifying %s: %w", c.Name, err))
+			continue
+		}
+		state[c.Name] = now
+		changed = true
+	}
+
+	if changed {
+		errs = append(errs, n.writeState(state))
+	}
+	return errors.Join(errs...)
+}
+
+// send delivers an event on a channel
+func (n *Notifier) send(c channel, event Event) error {
+	switch c.Type {
+	case Webhook:
+		return n.post(c.URL, c.Headers, newPayload(event))
+	case Slack:
+		return n.post(c.URL, nil, map[string]string{"text": summary(event, "*", "`")})
+	}
+
+	port := c.SMTP.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := fmt.Sprintf("%s:%d", c.SMTP.Host, port)
+
+	var auth smtp.Auth
+	if c.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", c.SMTP.Username, c.SMTP.Password, c.SMTP.Host)
+	}
+	return n.sendMail(addr, auth, c.SMTP.From, c.SMTP.To, mail(c.SMTP, event))
+}
+
+// readState reads when each channel was last notified
+func (n *Notifier) readState() (map[string]time.Time, error) {
+	state := map[string]time.Time{}
+	content, err := os.ReadFile(n.stateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a corrupted state only resets the rate limits
+	if err := json.Unmarshal(content, &state); err != nil {
+		return map[string]time.Time{}, nil
+	}
+	return state, nil
+}
+
+// writeState atomically replaces when each channel was last notified
+func (n *Notifier) writeState(state map[string]time.Time) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(n.stateFile), ".notified-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), n.stateFile)
+}
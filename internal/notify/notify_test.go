@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"encoding/json"
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/policy"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records the JSON bodies posted to it
+type receiver struct {
+	mu     sync.Mutex
+	bodies []map[string]any
+	header http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var body map[string]any
+	json.NewDecoder(req.Body).Decode(&body)
+	r.bodies = append(r.bodies, body)
+	r.header = req.Header
+}
+
+// newEvent returns an event with a warning and a critical finding
+func newEvent() Event {
+	return Event{
+		Device:    "box-1",
+		Source:    "verify",
+		Reference: "20240131T120000Z-1a2b3c4d",
+		Time:      time.Now(),
+		Findings: []policy.Finding{
+			{Change: hashing.Change{Path: "/etc/hosts", Kind: hashing.Modified}, Severity: policy.Warning, Reason: "content modified"},
+			{Change: hashing.Change{Path: "/etc/ssh/sshd_config", Kind: hashing.Modified}, Severity: policy.Critical, Reason: "path is immutable"},
+		},
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	invalid := [][]config.ChannelConfig{
+		{{Type: "pager"}},
+		{{Type: Webhook}},
+		{{Type: Slack, URL: "http://x", MinSeverity: "urgent"}},
+		{{Type: SMTP, SMTP: config.SMTPConfig{Host: "mail"}}},
+		{{Name: "a", Type: Slack, URL: "http://x"}, {Name: "a", Type: Slack, URL: "http://y"}},
+	}
+	for _, channels := range invalid {
+		if _, err := New(config.NotifyConfig{Channels: channels}, ""); err == nil {
+			t.Errorf("Expected %+v to be rejected", channels)
+		}
+	}
+}
+
+func TestNotify_Routing(t *testing.T) {
+	webhook, slack := &receiver{}, &receiver{}
+	webhookServer, slackServer := httptest.NewServer(webhook), httptest.NewServer(slack)
+	defer webhookServer.Close()
+	defer slackServer.Close()
+
+	var mails [][]byte
+	notifier, err := New(config.NotifyConfig{Channels: []config.ChannelConfig{
+		{Type: Webhook, URL: webhookServer.URL, Headers: map[string]string{"X-Token": "secret"}},
+		{Type: Slack, URL: slackServer.URL, MinSeverity: "critical"},
+		{Type: SMTP, MinSeverity: "critical", SMTP: config.SMTPConfig{Host: "mail", From: "magma@box-1", To: []string{"ops@example.com"}}},
+	}}, filepath.Join(t.TempDir(), "notified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "mail:25" {
+			t.Errorf("Expected the mail to be sent to mail:25, got %s", addr)
+		}
+		mails = append(mails, msg)
+		return nil
+	}
+
+	if err := notifier.Notify(newEvent()); err != nil {
+		t.Fatalf("Notify returned an error: %v", err)
+	}
+
+	// the webhook gets every finding
+	if len(webhook.bodies) != 1 || len(webhook.bodies[0]["findings"].([]any)) != 2 || webhook.bodies[0]["worst"] != "critical" {
+		t.Errorf("Unexpected webhook payload %+v", webhook.bodies)
+	}
+	if webhook.header.Get("X-Token") != "secret" {
+		t.Errorf("Expected the configured header to be sent")
+	}
+
+	// slack and smtp only the critical one
+	if len(slack.bodies) != 1 {
+		t.Fatalf("Expected one slack message, got %d", len(slack.bodies))
+	}
+	text := slack.bodies[0]["text"].(string)
+	if !strings.Contains(text, "/etc/ssh/sshd_config") || strings.Contains(text, "/etc/hosts") {
+		t.Errorf("Expected only the critical finding in the slack message, got %q", text)
+	}
+	if len(mails) != 1 || !strings.Contains(string(mails[0]), "Subject: [magma] critical drift on box-1") {
+		t.Errorf("Unexpected mails %q", mails)
+	}
+
+	// a warning alone only reaches the webhook
+	event := newEvent()
+	event.Findings = event.Findings[:1]
+	if err := notifier.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.bodies) != 2 || len(slack.bodies) != 1 || len(mails) != 1 {
+		t.Errorf("Expected the warning to only reach the webhook, got %d %d %d", len(webhook.bodies), len(slack.bodies), len(mails))
+	}
+}
+
+func TestNotify_RateLimit(t *testing.T) {
+	webhook := &receiver{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	stateFile := filepath.Join(t.TempDir(), "notified")
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	newNotifier := func() *Notifier {
+		notifier, err := New(config.NotifyConfig{RateLimit: time.Hour, Channels: []config.ChannelConfig{{Type: Webhook, URL: server.URL}}}, stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notifier.now = func() time.Time { return now }
+		return notifier
+	}
+
+	if err := newNotifier().Notify(newEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the limit holds across runs, e.g. successive 'magma verify'
+	now = now.Add(30 * time.Minute)
+	if err := newNotifier().Notify(newEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.bodies) != 1 {
+		t.Errorf("Expected the second notification to be suppressed, got %d", len(webhook.bodies))
+	}
+
+	now = now.Add(time.Hour)
+	if err := newNotifier().Notify(newEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.bodies) != 2 {
+		t.Errorf("Expected a notification once the limit passed, got %d", len(webhook.bodies))
+	}
+}
+
+func TestNotify_Failure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	stateFile := filepath.Join(t.TempDir(), "notified")
+	notifier, err := New(config.NotifyConfig{RateLimit: time.Hour, Channels: []config.ChannelConfig{{Type: Webhook, URL: server.URL}}}, stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(newEvent()); err == nil {
+		t.Fatal("Expected the failure to be reported")
+	}
+
+	// a failed notification does not count against the rate limit
+	state, err := notifier.readState()
+	if err != nil || len(state) != 0 {
+		t.Errorf("Expected no notification to be recorded, got %v, %v", state, err)
+	}
+}
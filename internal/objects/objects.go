@@ -0,0 +1,166 @@
+package objects
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// hashPattern matches the name of an object, the SHA-256 of its content
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// gracePeriod protects the objects of a snapshot being taken from GC, they are written, or
+// touched when already stored, before the snapshot referring to them
+const gracePeriod = time.Hour
+
+// Path returns where the object of a hash is stored, under a directory named after the first two
+// characters of the hash so no directory grows too large
+func Path(dir string, hash string) string {
+	return filepath.Join(dir, hash[:2], hash)
+}
+
+// Put hashes a file and stores its content in the object store. Content already stored is not
+// copied again.
+//
+// Parameters:
+//   - dir: the object store.
+//   - path: the file to store.
+//
+// Returns:
+//   - string: the SHA-256 of the content, as computed when hashing without storing.
+//   - error: an error if the file cannot be read or the object cannot be written.
+func Put(dir string, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// the content is copied while it is hashed, the file is only read once
+	temp, err := os.CreateTemp(dir, ".object-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(temp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(hasher, temp), file); err != nil {
+		temp.Close()
+		return "", err
+	}
+	if err := temp.Close(); err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	object := Path(dir, hash)
+	if _, err := os.Stat(object); err == nil {
+		now := time.Now()
+		return hash, os.Chtimes(object, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0700); err != nil {
+		return "", err
+	}
+	// objects are read only, their name is their content
+	if err := os.Chmod(temp.Name(), 0400); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(temp.Name(), object)
+}
+
+// Has reports whether the content of a hash is stored
+func Has(dir string, hash string) bool {
+	if !hashPattern.MatchString(hash) {
+		return false
+	}
+	_, err := os.Stat(Path(dir, hash))
+	return err == nil
+}
+
+// Open opens the stored content of a hash
+func Open(dir string, hash string) (*os.File, error) {
+	if !hashPattern.MatchString(hash) {
+		return nil, fmt.Errorf("%q is not a content hash", hash)
+	}
+	return os.Open(Path(dir, hash))
+}
+
+// Walk calls fn with the hash and path of every object of the store, a missing store holds none.
+// Files that are not objects, like the leftovers of an interrupted Put, are passed with an empty
+// hash.
+func Walk(dir string, fn func(hash string, path string) error) error {
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		hash := entry.Name()
+		if !hashPattern.MatchString(hash) || filepath.Base(filepath.Dir(path)) != hash[:2] {
+			hash = ""
+		}
+		return fn(hash, path)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Verify re-hashes a stored object and reports whether its content still matches its name
+func Verify(path string, hash string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return false, err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)) == hash, nil
+}
+
+// GC removes the objects no snapshot refers to anymore, along with the leftovers of interrupted
+// writes. Files changed less than an hour ago are kept, they may belong to a snapshot being taken.
+//
+// Parameters:
+//   - dir: the object store.
+//   - referenced: the hashes still referred to, see hashing.Node.
+//
+// Returns:
+//   - int: the number of objects removed.
+//   - int64: the bytes freed.
+//   - error: an error if the store cannot be read or an object cannot be removed.
+func GC(dir string, referenced map[string]bool) (int, int64, error) {
+	removed, freed := 0, int64(0)
+	err := Walk(dir, func(hash string, path string) error {
+		if hash != "" && referenced[hash] {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) < gracePeriod {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
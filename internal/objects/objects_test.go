@@ -0,0 +1,93 @@
+package objects
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPut(t *testing.T) {
+	dir := t.TempDir()
+
+	hash, err := Put(dir, writeFile(t, "hello"))
+	if err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+	// the SHA-256 of "hello"
+	if hash != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected hash %s", hash)
+	}
+	if !Has(dir, hash) {
+		t.Fatalf("Expected the content to be stored")
+	}
+
+	file, err := Open(dir, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "hello" {
+		t.Errorf("Expected the stored content, got %q", content)
+	}
+
+	// storing the same content again keeps a single object
+	if again, err := Put(dir, writeFile(t, "hello")); err != nil || again != hash {
+		t.Errorf("Expected the same hash, got %s, %v", again, err)
+	}
+	count := 0
+	Walk(dir, func(hash string, path string) error {
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("Expected a single object, got %d files", count)
+	}
+}
+
+func TestOpen_InvalidHash(t *testing.T) {
+	if _, err := Open(t.TempDir(), "../../etc/shadow"); err == nil {
+		t.Errorf("Expected a path that is not a hash to be rejected")
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir := t.TempDir()
+	kept, _ := Put(dir, writeFile(t, "kept"))
+	removed, _ := Put(dir, writeFile(t, "removed"))
+	recent, _ := Put(dir, writeFile(t, "recent"))
+
+	old := time.Now().Add(-2 * gracePeriod)
+	for _, hash := range []string{kept, removed} {
+		if err := os.Chtimes(Path(dir, hash), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, freed, err := GC(dir, map[string]bool{kept: true})
+	if err != nil {
+		t.Fatalf("GC returned an error: %v", err)
+	}
+	if count != 1 || freed != int64(len("removed")) {
+		t.Errorf("Expected 1 object and 7 bytes removed, got %d and %d", count, freed)
+	}
+	if !Has(dir, kept) || Has(dir, removed) || !Has(dir, recent) {
+		t.Errorf("Expected the unreferenced old object only to be removed")
+	}
+
+	// a missing store holds nothing to collect
+	if count, _, err := GC(filepath.Join(dir, "missing"), nil); count != 0 || err != nil {
+		t.Errorf("Expected nothing removed from a missing store, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,220 @@
+package parsing
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TrackEntry is a line of the track file: a path, or a glob pattern, followed by the options it is
+// hashed with
+//
+//	/etc/nginx label=web ignore=*.bak,cache/
+//	/home/*/.bashrc
+//	/srv/app max-depth=2 follow-symlinks one-file-system
+//	/etc/alternatives symlinks=both
+//	/etc/ssh store-content
+//	/var/run/app omit-skipped
+type TrackEntry struct {
+	Path          string
+	MaxDepth      int         // Levels of directories hashed under the path, no limit when zero
+	Symlinks      SymlinkMode // How symlinks are hashed, SymlinksRecord when empty
+	StoreContent  bool        // Keep the content of the files in the object store
+	OneFileSystem bool        // Do not descend into directories of other file systems
+	OmitSkipped   bool        // Leave skipped entries out of snapshots instead of recording why they were skipped
+	Ignore        []string    // Ignore patterns relative to the path, in the syntax of the ignore file
+	Label         string      // Recorded on the root of the path in snapshots
+	Pattern       string      // The pattern the path was expanded from, empty for a path of the track file
+}
+
+// SymlinkMode tells how the symlinks under a tracked path are hashed
+type SymlinkMode string
+
+const (
+	SymlinksRecord SymlinkMode = "record-link" // The path a link points to is hashed, not what is there
+	SymlinksFollow SymlinkMode = "follow"      // What a link points to is hashed in its place
+	SymlinksBoth   SymlinkMode = "both"        // Both, so a link changing target or its target changing is reported
+)
+
+// symlinkModes are the values of the symlinks option
+var symlinkModes = []SymlinkMode{SymlinksRecord, SymlinksFollow, SymlinksBoth}
+
+// Follows reports whether what the symlinks of the entry point to is hashed
+func (e TrackEntry) Follows() bool {
+	return e.Symlinks == SymlinksFollow || e.Symlinks == SymlinksBoth
+}
+
+// IsPattern reports whether the path of the entry is a glob pattern, expanded to the paths it
+// matches when a snapshot is taken. A backslash escapes a glob character, the path is still
+// expanded but only matches itself.
+func (e TrackEntry) IsPattern() bool {
+	return strings.ContainsAny(e.Path, "*?[{")
+}
+
+// the flags and the name=value options of a track entry
+var (
+	trackFlags   = []string{"follow-symlinks", "store-content", "one-file-system", "omit-skipped"}
+	trackOptions = []string{"max-depth", "symlinks", "ignore", "label"}
+	optionName   = regexp.MustCompile(`^[a-z][a-z-]*=`)
+)
+
+// ReadTrackFile reads the entries of the track file, successor of ReadMagmaFile for the track file.
+//
+// Parameters:
+//   - path: the track file.
+//
+// Returns:
+//   - []TrackEntry: the entries, in the order of the file.
+//   - error: an error if the file cannot be read or a line has an invalid option.
+func ReadTrackFile(path string) ([]TrackEntry, error) {
+	lines, err := ReadMagmaFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TrackEntry
+	for _, line := range lines {
+		entry, err := ParseTrackEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ParseTrackEntry parses a line of the track file. Options are read from the end of the line, so
+// paths holding spaces remain valid as long as they do not end with something looking like an
+// option.
+//
+// Parameters:
+//   - line: the line, a path optionally followed by options separated by spaces.
+//
+// Returns:
+//   - TrackEntry: the entry.
+//   - error: an error if an option is unknown or has an invalid value.
+func ParseTrackEntry(line string) (TrackEntry, error) {
+	var entry TrackEntry
+	rest := strings.TrimRight(line, " \t")
+
+	for {
+		i := strings.LastIndexAny(rest, " \t")
+		if i < 0 {
+			break
+		}
+		option := rest[i+1:]
+
+		name, value, hasValue := strings.Cut(option, "=")
+		switch {
+		case !hasValue && slices.Contains(trackFlags, name):
+			setFlag(&entry, name)
+		case hasValue && slices.Contains(trackOptions, name):
+			if err := setOption(&entry, name, value); err != nil {
+				return entry, fmt.Errorf("%q: %w", line, err)
+			}
+		case optionName.MatchString(option):
+			return entry, fmt.Errorf("%q: unknown option %s", line, name)
+		default:
+			// not an option, part of the path
+			entry.Path = rest
+			return entry, nil
+		}
+		rest = strings.TrimRight(rest[:i], " \t")
+	}
+
+	entry.Path = rest
+	if entry.Path == "" {
+		return entry, fmt.Errorf("%q: no path", line)
+	}
+	return entry, nil
+}
+
+// Entries returns the entries of paths without options
+func Entries(paths ...string) []TrackEntry {
+	entries := make([]TrackEntry, len(paths))
+	for i, path := range paths {
+		entries[i] = TrackEntry{Path: path}
+	}
+	return entries
+}
+
+// Paths returns the paths of entries
+func Paths(entries []TrackEntry) []string {
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	return paths
+}
+
+// String returns the entry as a line of the track file
+func (e TrackEntry) String() string {
+	options := []string{e.Path}
+	if e.MaxDepth > 0 {
+		options = append(options, "max-depth="+strconv.Itoa(e.MaxDepth))
+	}
+	switch e.Symlinks {
+	case SymlinksFollow:
+		options = append(options, "follow-symlinks")
+	case SymlinksBoth:
+		options = append(options, "symlinks=both")
+	}
+	if e.StoreContent {
+		options = append(options, "store-content")
+	}
+	if e.OneFileSystem {
+		options = append(options, "one-file-system")
+	}
+	if e.OmitSkipped {
+		options = append(options, "omit-skipped")
+	}
+	if len(e.Ignore) > 0 {
+		options = append(options, "ignore="+strings.Join(e.Ignore, ","))
+	}
+	if e.Label != "" {
+		options = append(options, "label="+e.Label)
+	}
+	return strings.Join(options, " ")
+}
+
+// setFlag sets an option without value
+func setFlag(entry *TrackEntry, name string) {
+	switch name {
+	case "follow-symlinks":
+		entry.Symlinks = SymlinksFollow
+	case "store-content":
+		entry.StoreContent = true
+	case "one-file-system":
+		entry.OneFileSystem = true
+	case "omit-skipped":
+		entry.OmitSkipped = true
+	}
+}
+
+// setOption sets a name=value option
+func setOption(entry *TrackEntry, name string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s needs a value", name)
+	}
+
+	switch name {
+	case "max-depth":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 1 {
+			return fmt.Errorf("max-depth must be a positive number, got %q", value)
+		}
+		entry.MaxDepth = depth
+	case "symlinks":
+		if !slices.Contains(symlinkModes, SymlinkMode(value)) {
+			return fmt.Errorf("symlinks must be record-link, follow or both, got %q", value)
+		}
+		entry.Symlinks = SymlinkMode(value)
+	case "ignore":
+		entry.Ignore = strings.Split(value, ",")
+	case "label":
+		entry.Label = value
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package parsing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTrackEntry(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected TrackEntry
+	}{
+		{"/etc/nginx", TrackEntry{Path: "/etc/nginx"}},
+		{"/etc/nginx label=web ignore=*.bak,cache/", TrackEntry{Path: "/etc/nginx", Label: "web", Ignore: []string{"*.bak", "cache/"}}},
+		{"/srv/app  max-depth=2 follow-symlinks\tone-file-system", TrackEntry{Path: "/srv/app", MaxDepth: 2, Symlinks: SymlinksFollow, OneFileSystem: true}},
+		{"/etc/alternatives symlinks=both", TrackEntry{Path: "/etc/alternatives", Symlinks: SymlinksBoth}},
+		{"/etc/alternatives symlinks=record-link", TrackEntry{Path: "/etc/alternatives", Symlinks: SymlinksRecord}},
+		{"/etc/ssh store-content", TrackEntry{Path: "/etc/ssh", StoreContent: true}},
+		{"/run/app omit-skipped", TrackEntry{Path: "/run/app", OmitSkipped: true}},
+
+		// paths holding spaces, only the trailing options are parsed
+		{"/srv/my files", TrackEntry{Path: "/srv/my files"}},
+		{"/srv/my files label=docs", TrackEntry{Path: "/srv/my files", Label: "docs"}},
+		{"/srv/store-content files", TrackEntry{Path: "/srv/store-content files"}},
+	}
+
+	for _, test := range tests {
+		entry, err := ParseTrackEntry(test.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(entry, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.line, test.expected, entry)
+		}
+	}
+}
+
+func TestParseTrackEntry_Invalid(t *testing.T) {
+	for _, line := range []string{
+		"/etc max-depth=0",
+		"/etc max-depth=deep",
+		"/etc symlinks=resolve",
+		"/etc label=",
+		"/etc colour=red",
+		" label=web",
+	} {
+		if _, err := ParseTrackEntry(line); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestTrackEntry_String(t *testing.T) {
+	line := "/srv/app max-depth=3 follow-symlinks store-content one-file-system omit-skipped ignore=*.tmp,logs/ label=app"
+	entry, err := ParseTrackEntry(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.String() != line {
+		t.Errorf("Expected %q, got %q", line, entry.String())
+	}
+}
+
+func TestReadTrackFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track")
+	if err := os.WriteFile(path, []byte("# tracked\n/etc/hosts\n/etc/nginx label=web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadTrackFile(path)
+	if err != nil {
+		t.Fatalf("ReadTrackFile returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(Paths(entries), []string{"/etc/hosts", "/etc/nginx"}) || entries[1].Label != "web" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	if err := os.WriteFile(path, []byte("/etc/hosts\n/etc/nginx max-depth=-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTrackFile(path); err == nil {
+		t.Errorf("Expected the invalid line to be reported")
+	}
+}
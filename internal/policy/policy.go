@@ -0,0 +1,283 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"magma/internal/hashing"
+	"magma/internal/parsing"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Severity ranks how serious a finding is
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// ParseSeverity parses the name of a severity, as written in the policy file
+func ParseSeverity(name string) (Severity, error) {
+	switch name {
+	case "info":
+		return Info, nil
+	case "warning":
+		return Warning, nil
+	case "critical":
+		return Critical, nil
+	}
+	return Info, fmt.Errorf("unknown severity %q, expected info, warning or critical", name)
+}
+
+// The expectations a rule can declare on the paths it matches
+const (
+	Immutable       = "immutable"        // any change is critical
+	ContentOnly     = "content-only"     // only content changes matter, metadata changes are ignored
+	AppendOnly      = "append-only"      // files may grow but not shrink or be rewritten
+	MetadataWatched = "metadata-watched" // permission and ownership changes are critical
+	Ignored         = "ignored"          // changes are not reported
+)
+
+var kinds = []string{Immutable, ContentOnly, AppendOnly, MetadataWatched, Ignored}
+
+// Rule declares the expectation for the paths matching a doublestar pattern
+type Rule struct {
+	Kind     string    // One of the expectations above
+	Pattern  string    // Doublestar pattern matched against absolute paths
+	Severity *Severity // Overrides the severity of the findings of the rule, nil keeps the default
+}
+
+// Finding is a change evaluated against the policy
+type Finding struct {
+	Change   hashing.Change
+	Rule     *Rule // The rule that matched the change, nil if no rule matched
+	Severity Severity
+	Reason   string
+}
+
+// ReadPolicy reads the policy file. Each line holds an expectation, a pattern and optionally a
+// severity overriding the default of the expectation, e.g. "content-only /etc/app/** critical".
+// A missing policy file is not an error, it simply holds no rule.
+//
+// Parameters:
+//   - path: the path of the policy file.
+//
+// Returns:
+//   - []Rule: the rules, in file order.
+//   - error: an error if the file cannot be read or a line is invalid.
+func ReadPolicy(path string) ([]Rule, error) {
+	lines, err := parsing.ReadMagmaFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, line := range lines {
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseRule parses a single line of the policy file
+func ParseRule(line string) (Rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Rule{}, fmt.Errorf("invalid policy line %q, expected <expectation> <pattern> [severity]", line)
+	}
+
+	rule := Rule{Kind: fields[0], Pattern: fields[1]}
+	if !slices.Contains(kinds, rule.Kind) {
+		return rule, fmt.Errorf("unknown expectation %q in %q, expected one of %s", rule.Kind, line, strings.Join(kinds, ", "))
+	}
+	if !doublestar.ValidatePattern(rule.Pattern) {
+		return rule, fmt.Errorf("invalid pattern %q in %q", rule.Pattern, line)
+	}
+	if len(fields) == 3 {
+		severity, err := ParseSeverity(fields[2])
+		if err != nil {
+			return rule, err
+		}
+		rule.Severity = &severity
+	}
+	return rule, nil
+}
+
+// Match returns the rule that applies to a path, the last matching rule wins
+func Match(rules []Rule, path string) *Rule {
+	var matched *Rule
+	for i := range rules {
+		if ok, _ := doublestar.Match(rules[i].Pattern, path); ok {
+			matched = &rules[i]
+		}
+	}
+	return matched
+}
+
+// Evaluate applies the policy to the changes between a snapshot and the live state of the
+// tracked paths. Changes the matching rule does not care about are dropped.
+//
+// Without a matching rule, content changes and permission or ownership changes are warnings and
+// a change of the modification time alone is informational.
+//
+// Parameters:
+//   - rules: the policy rules.
+//   - changes: the changes to evaluate, the new side must be the live file system.
+//
+// Returns:
+//   - []Finding: one finding per change that matters, in the order of the changes.
+func Evaluate(rules []Rule, changes []hashing.Change) []Finding {
+	var findings []Finding
+	for _, change := range changes {
+		rule := Match(rules, change.Path)
+
+		finding, keep := evaluate(rule, change)
+		if !keep {
+			continue
+		}
+		finding.Change = change
+		finding.Rule = rule
+		if rule != nil && rule.Severity != nil {
+			finding.Severity = *rule.Severity
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// evaluate decides the default severity of a change under a rule, it returns false if the change is dropped
+func evaluate(rule *Rule, change hashing.Change) (Finding, bool) {
+	kind := ""
+	if rule != nil {
+		kind = rule.Kind
+	}
+	ownership := hasAny(change.Attributes, "mode", "owner", "group")
+	// a followed link pointing elsewhere tracks another file, even one with the same content
+	if change.Kind == hashing.Metadata && hasAny(change.Attributes, "target") {
+		change.Kind = hashing.Modified
+	}
+
+	switch kind {
+	case Ignored:
+		return Finding{}, false
+
+	case Immutable:
+		return Finding{Severity: Critical, Reason: "path is immutable"}, true
+
+	case ContentOnly:
+		if change.Kind == hashing.Metadata {
+			return Finding{}, false
+		}
+		return Finding{Severity: Warning, Reason: "content changed"}, true
+
+	case AppendOnly:
+		switch change.Kind {
+		case hashing.Added:
+			return Finding{Severity: Info, Reason: "file created"}, true
+		case hashing.Removed:
+			return Finding{Severity: Critical, Reason: "append-only file removed"}, true
+		case hashing.Metadata:
+			if !ownership {
+				return Finding{}, false
+			}
+			return Finding{Severity: Warning, Reason: "permissions or ownership changed"}, true
+		}
+		if appended(change) {
+			return Finding{Severity: Info, Reason: "file grew"}, true
+		}
+		return Finding{Severity: Critical, Reason: "append-only file shrunk or was rewritten"}, true
+
+	case MetadataWatched:
+		if ownership {
+			return Finding{Severity: Critical, Reason: "permissions or ownership changed"}, true
+		}
+		if reason := hardlinkChange(change); change.Kind == hashing.Metadata && reason != "" {
+			return Finding{Severity: Warning, Reason: reason}, true
+		}
+		if change.Kind == hashing.Metadata {
+			return Finding{Severity: Info, Reason: "modification time changed"}, true
+		}
+		return Finding{Severity: Warning, Reason: "content changed"}, true
+	}
+
+	// no rule
+	switch {
+	case change.Kind == hashing.Metadata && hardlinkChange(change) != "":
+		return Finding{Severity: Warning, Reason: hardlinkChange(change)}, true
+	case change.Kind == hashing.Metadata && !ownership:
+		return Finding{Severity: Info, Reason: "modification time changed"}, true
+	case change.Kind == hashing.Metadata:
+		return Finding{Severity: Warning, Reason: "permissions or ownership changed"}, true
+	}
+	return Finding{Severity: Warning, Reason: "content " + string(change.Kind)}, true
+}
+
+// hardlinkChange describes a file linked to another path, or no longer, empty for other changes
+func hardlinkChange(change hashing.Change) string {
+	if !hasAny(change.Attributes, "hardlink") || change.Old == nil || change.New == nil {
+		return ""
+	}
+	switch {
+	case change.New.Hardlink == "":
+		return "hardlink to " + change.Old.Hardlink + " broken"
+	case change.Old.Hardlink == "":
+		return "hardlink to " + change.New.Hardlink + " created"
+	}
+	return "hardlink to " + change.Old.Hardlink + " moved to " + change.New.Hardlink
+}
+
+// appended reports whether a modified file only grew: the live file starts with the exact content
+// the old snapshot hashed
+func appended(change hashing.Change) bool {
+	if change.Old == nil || change.New == nil || change.Old.Mode == "" {
+		return false
+	}
+	if change.New.Size < change.Old.Size {
+		return false
+	}
+
+	file, err := os.Open(change.Path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, file, change.Old.Size); err != nil {
+		return false
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)) == change.Old.Hash
+}
+
+// hasAny reports whether any of the values is in the list
+func hasAny(list []string, values ...string) bool {
+	for _, value := range values {
+		if slices.Contains(list, value) {
+			return true
+		}
+	}
+	return false
+}
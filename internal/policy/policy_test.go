@@ -0,0 +1,170 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"magma/internal/hashing"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("content-only /etc/app/** critical")
+	if err != nil {
+		t.Fatalf("ParseRule returned an error: %v", err)
+	}
+	if rule.Kind != ContentOnly || rule.Pattern != "/etc/app/**" || rule.Severity == nil || *rule.Severity != Critical {
+		t.Errorf("Unexpected rule %+v", rule)
+	}
+
+	for _, line := range []string{"immutable", "frozen /etc/**", "immutable /etc/[a", "immutable /etc/** urgent", "immutable /etc a b"} {
+		if _, err := ParseRule(line); err == nil {
+			t.Errorf("ParseRule(%q): expected an error", line)
+		}
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	rules, err := ReadPolicy(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || rules != nil {
+		t.Errorf("Expected no rule and no error for a missing policy, got %v, %v", rules, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "policy")
+	content := "# comment\nimmutable /etc/ssh/**\n\nignored /etc/ssh/*.bak\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = ReadPolicy(path)
+	if err != nil {
+		t.Fatalf("ReadPolicy returned an error: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+
+	// the last matching rule wins
+	if rule := Match(rules, "/etc/ssh/sshd_config.bak"); rule == nil || rule.Kind != Ignored {
+		t.Errorf("Expected the ignored rule to match, got %+v", rule)
+	}
+	if rule := Match(rules, "/etc/ssh/sshd_config"); rule == nil || rule.Kind != Immutable {
+		t.Errorf("Expected the immutable rule to match, got %+v", rule)
+	}
+	if rule := Match(rules, "/etc/hosts"); rule != nil {
+		t.Errorf("Expected no rule to match, got %+v", rule)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Kind: Immutable, Pattern: "/etc/ssh/**"},
+		{Kind: ContentOnly, Pattern: "/etc/app/**"},
+		{Kind: MetadataWatched, Pattern: "/etc/sudoers"},
+		{Kind: Ignored, Pattern: "/var/cache/**"},
+	}
+
+	tests := []struct {
+		change   hashing.Change
+		keep     bool
+		severity Severity
+	}{
+		{hashing.Change{Path: "/etc/ssh/sshd_config", Kind: hashing.Metadata, Attributes: []string{"mtime"}}, true, Critical},
+		{hashing.Change{Path: "/etc/app/a.conf", Kind: hashing.Metadata, Attributes: []string{"mode"}}, false, Info},
+		{hashing.Change{Path: "/etc/app/a.conf", Kind: hashing.Modified}, true, Warning},
+		{hashing.Change{Path: "/etc/sudoers", Kind: hashing.Metadata, Attributes: []string{"owner"}}, true, Critical},
+		{hashing.Change{Path: "/etc/sudoers", Kind: hashing.Metadata, Attributes: []string{"mtime"}}, true, Info},
+		{hashing.Change{Path: "/var/cache/x", Kind: hashing.Added}, false, Info},
+		{hashing.Change{Path: "/etc/hosts", Kind: hashing.Modified}, true, Warning},
+		{hashing.Change{Path: "/etc/hosts", Kind: hashing.Metadata, Attributes: []string{"mtime"}}, true, Info},
+		{hashing.Change{Path: "/etc/app/editor", Kind: hashing.Metadata, Attributes: []string{"target"}}, true, Warning},
+	}
+
+	for _, test := range tests {
+		findings := Evaluate(rules, []hashing.Change{test.change})
+		if !test.keep {
+			if len(findings) != 0 {
+				t.Errorf("%s %v: expected the change to be dropped, got %+v", test.change.Path, test.change.Attributes, findings)
+			}
+			continue
+		}
+		if len(findings) != 1 {
+			t.Errorf("%s %v: expected one finding, got %d", test.change.Path, test.change.Attributes, len(findings))
+			continue
+		}
+		if findings[0].Severity != test.severity {
+			t.Errorf("%s %v: expected severity %s, got %s", test.change.Path, test.change.Attributes, test.severity, findings[0].Severity)
+		}
+	}
+}
+
+func TestEvaluate_Hardlink(t *testing.T) {
+	linked := hashing.Node{Path: "/etc/app/b.conf", Inode: 12, Hardlink: "/etc/app/a.conf"}
+	copied := hashing.Node{Path: "/etc/app/b.conf", Inode: 13}
+
+	tests := []struct {
+		old, new *hashing.Node
+		reason   string
+	}{
+		{&linked, &copied, "hardlink to /etc/app/a.conf broken"},
+		{&copied, &linked, "hardlink to /etc/app/a.conf created"},
+	}
+	for _, test := range tests {
+		change := hashing.Change{Path: "/etc/app/b.conf", Kind: hashing.Metadata, Attributes: []string{"hardlink"}, Old: test.old, New: test.new}
+		findings := Evaluate(nil, []hashing.Change{change})
+		if len(findings) != 1 || findings[0].Severity != Warning || findings[0].Reason != test.reason {
+			t.Errorf("Expected a warning %q, got %+v", test.reason, findings)
+		}
+	}
+}
+
+func TestEvaluate_SeverityOverride(t *testing.T) {
+	severity := Critical
+	rules := []Rule{{Kind: ContentOnly, Pattern: "/etc/**", Severity: &severity}}
+
+	findings := Evaluate(rules, []hashing.Change{{Path: "/etc/hosts", Kind: hashing.Modified}})
+	if len(findings) != 1 || findings[0].Severity != Critical {
+		t.Errorf("Expected the severity to be overridden, got %+v", findings)
+	}
+}
+
+func TestEvaluate_AppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rules := []Rule{{Kind: AppendOnly, Pattern: "**/*.log"}}
+
+	old := &hashing.Node{Path: path, Hash: fmt.Sprintf("%x", sha256.Sum256([]byte("line1\n"))), Mode: "-rw-r--r--", Size: 6}
+	change := func() hashing.Change {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashing.Change{Path: path, Kind: hashing.Modified, Old: old, New: &hashing.Node{Path: path, Mode: "-rw-r--r--", Size: info.Size()}}
+	}
+
+	// the log grew
+	if err := os.WriteFile(path, []byte("line1\nline2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings := Evaluate(rules, []hashing.Change{change()})
+	if len(findings) != 1 || findings[0].Severity != Info {
+		t.Errorf("Expected a growing log to be informational, got %+v", findings)
+	}
+
+	// the log was rewritten
+	if err := os.WriteFile(path, []byte("LINE1\nline2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings = Evaluate(rules, []hashing.Change{change()})
+	if len(findings) != 1 || findings[0].Severity != Critical {
+		t.Errorf("Expected a rewritten log to be critical, got %+v", findings)
+	}
+
+	// the log was truncated
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings = Evaluate(rules, []hashing.Change{change()})
+	if len(findings) != 1 || findings[0].Severity != Critical {
+		t.Errorf("Expected a truncated log to be critical, got %+v", findings)
+	}
+}
@@ -0,0 +1,147 @@
+package prune
+
+import (
+	"fmt"
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/objects"
+	"os"
+	"slices"
+)
+
+// Decision records whether a snapshot is kept and why
+type Decision struct {
+	Snapshot hashing.Snapshot
+	Keep     bool
+	Reasons  []string // The rules that keep the snapshot, empty when it is removed
+}
+
+// Plan applies the retention policy to the given snapshots and decides which ones to keep.
+// Snapshots are evaluated from newest to oldest, the daily, weekly and monthly rules keep the
+// newest snapshot of each period. Snapshots carrying a pinned tag are always kept.
+//
+// Parameters:
+//   - snapshots: the snapshots to evaluate, in any order.
+//   - policy: the retention rules.
+//
+// Returns:
+//   - []Decision: one decision per snapshot, newest first.
+//   - error: an error if the policy has no rule at all, which would remove every snapshot.
+func Plan(snapshots []hashing.Snapshot, policy config.RetentionConfig) ([]Decision, error) {
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 && policy.KeepWeekly <= 0 && policy.KeepMonthly <= 0 {
+		return nil, fmt.Errorf("no retention rule is configured, refusing to remove every snapshot")
+	}
+
+	// newest first
+	sorted := slices.Clone(snapshots)
+	slices.SortStableFunc(sorted, func(a, b hashing.Snapshot) int {
+		return b.Created.Compare(a.Created)
+	})
+
+	decisions := make([]Decision, len(sorted))
+	for i, snapshot := range sorted {
+		decisions[i].Snapshot = snapshot
+	}
+
+	keep := func(i int, reason string) {
+		decisions[i].Keep = true
+		decisions[i].Reasons = append(decisions[i].Reasons, reason)
+	}
+
+	for i := range decisions {
+		if i < policy.KeepLast {
+			keep(i, "last")
+		}
+		for _, tag := range decisions[i].Snapshot.Tags {
+			if slices.Contains(policy.PinnedTags, tag) {
+				keep(i, "pinned:"+tag)
+				break
+			}
+		}
+	}
+
+	keepPeriods(decisions, policy.KeepDaily, "daily", keep, func(s hashing.Snapshot) string {
+		return s.Created.Local().Format("2006-01-02")
+	})
+	keepPeriods(decisions, policy.KeepWeekly, "weekly", keep, func(s hashing.Snapshot) string {
+		year, week := s.Created.Local().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(decisions, policy.KeepMonthly, "monthly", keep, func(s hashing.Snapshot) string {
+		return s.Created.Local().Format("2006-01")
+	})
+
+	return decisions, nil
+}
+
+// keepPeriods keeps the newest snapshot of each of the n most recent periods, the period of a
+// snapshot is given by the key function
+func keepPeriods(decisions []Decision, n int, reason string, keep func(int, string), key func(hashing.Snapshot) string) {
+	lastKey := ""
+	kept := 0
+	for i := range decisions {
+		if kept >= n {
+			return
+		}
+		k := key(decisions[i].Snapshot)
+		if k == lastKey {
+			continue
+		}
+		lastKey = k
+		keep(i, reason)
+		kept++
+	}
+}
+
+// Apply removes the snapshot files of every decision that does not keep its snapshot.
+//
+// Parameters:
+//   - decisions: the decisions returned by Plan.
+//
+// Returns:
+//   - int: the number of snapshots removed.
+//   - error: an error if a snapshot file could not be removed.
+func Apply(decisions []Decision) (int, error) {
+	removed := 0
+	for _, decision := range decisions {
+		if decision.Keep {
+			continue
+		}
+		if err := os.Remove(decision.Snapshot.File); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// CollectObjects removes the stored content no kept snapshot refers to anymore, once Apply
+// removed the other snapshots.
+//
+// Parameters:
+//   - dir: the object store.
+//   - decisions: the decisions returned by Plan.
+//
+// Returns:
+//   - int: the number of objects removed.
+//   - int64: the bytes freed.
+//   - error: an error if the object store could not be cleaned.
+func CollectObjects(dir string, decisions []Decision) (int, int64, error) {
+	referenced := map[string]bool{}
+	for _, decision := range decisions {
+		if decision.Keep {
+			storedHashes(decision.Snapshot.Node, referenced)
+		}
+	}
+	return objects.GC(dir, referenced)
+}
+
+// storedHashes adds the hashes of the files of a node whose content is stored
+func storedHashes(node hashing.Node, hashes map[string]bool) {
+	if node.Stored {
+		hashes[node.Hash] = true
+	}
+	for _, child := range node.Children {
+		storedHashes(child, hashes)
+	}
+}
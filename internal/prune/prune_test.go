@@ -0,0 +1,166 @@
+package prune
+
+import (
+	"magma/internal/config"
+	"magma/internal/hashing"
+	"magma/internal/objects"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// daily returns one snapshot per day for the given number of days, newest last
+func daily(days int, tagged map[int]string) []hashing.Snapshot {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	var snapshots []hashing.Snapshot
+	for i := 0; i < days; i++ {
+		snapshot := hashing.Snapshot{ID: start.AddDate(0, 0, i).Format("20060102"), Created: start.AddDate(0, 0, i)}
+		if tag, ok := tagged[i]; ok {
+			snapshot.Tags = []string{tag}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+// kept returns the ids of the kept snapshots
+func kept(decisions []Decision) []string {
+	var ids []string
+	for _, decision := range decisions {
+		if decision.Keep {
+			ids = append(ids, decision.Snapshot.ID)
+		}
+	}
+	return ids
+}
+
+func TestPlan_NoRules(t *testing.T) {
+	_, err := Plan(daily(3, nil), config.RetentionConfig{})
+	if err == nil {
+		t.Fatal("Expected an error when no rule is configured")
+	}
+}
+
+func TestPlan_KeepLast(t *testing.T) {
+	decisions, err := Plan(daily(5, nil), config.RetentionConfig{KeepLast: 2})
+	if err != nil {
+		t.Fatalf("Plan returned an error: %v", err)
+	}
+
+	ids := kept(decisions)
+	if len(ids) != 2 || ids[0] != "20240105" || ids[1] != "20240104" {
+		t.Errorf("Expected the two newest snapshots to be kept, got %v", ids)
+	}
+}
+
+func TestPlan_KeepDailyOnePerDay(t *testing.T) {
+	snapshots := daily(3, nil)
+
+	// a second, older snapshot on the last day must not be kept by the daily rule
+	extra := snapshots[2]
+	extra.ID = "early"
+	extra.Created = extra.Created.Add(-time.Hour)
+	snapshots = append(snapshots, extra)
+
+	decisions, err := Plan(snapshots, config.RetentionConfig{KeepDaily: 2})
+	if err != nil {
+		t.Fatalf("Plan returned an error: %v", err)
+	}
+
+	ids := kept(decisions)
+	if len(ids) != 2 || ids[0] != "20240103" || ids[1] != "20240102" {
+		t.Errorf("Expected the newest snapshot of the two last days, got %v", ids)
+	}
+}
+
+func TestPlan_KeepMonthly(t *testing.T) {
+	decisions, err := Plan(daily(70, nil), config.RetentionConfig{KeepMonthly: 2})
+	if err != nil {
+		t.Fatalf("Plan returned an error: %v", err)
+	}
+
+	// 70 days from January 1st end on March 10th
+	ids := kept(decisions)
+	if len(ids) != 2 || ids[0] != "20240310" || ids[1] != "20240229" {
+		t.Errorf("Expected the last snapshot of March and February, got %v", ids)
+	}
+}
+
+func TestPlan_PinnedTags(t *testing.T) {
+	snapshots := daily(5, map[int]string{0: "baseline", 1: "other"})
+
+	decisions, err := Plan(snapshots, config.RetentionConfig{KeepLast: 1, PinnedTags: []string{"baseline"}})
+	if err != nil {
+		t.Fatalf("Plan returned an error: %v", err)
+	}
+
+	ids := kept(decisions)
+	if len(ids) != 2 || ids[0] != "20240105" || ids[1] != "20240101" {
+		t.Errorf("Expected the newest and the pinned snapshot to be kept, got %v", ids)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	var decisions []Decision
+	for i, keep := range []bool{true, false} {
+		file := filepath.Join(dir, []string{"keep.json", "remove.json"}[i])
+		if err := os.WriteFile(file, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		decisions = append(decisions, Decision{Snapshot: hashing.Snapshot{File: file}, Keep: keep})
+	}
+
+	removed, err := Apply(decisions)
+	if err != nil {
+		t.Fatalf("Apply returned an error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 snapshot removed, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.json")); err != nil {
+		t.Errorf("Kept snapshot was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "remove.json")); !os.IsNotExist(err) {
+		t.Errorf("Pruned snapshot still exists")
+	}
+}
+
+func TestCollectObjects(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "objects")
+
+	var hashes []string
+	for _, content := range []string{"kept", "removed"} {
+		file := filepath.Join(dir, content)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := objects.Put(store, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// objects younger than the grace period are never collected
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(objects.Path(store, hash), old, old); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	decisions := []Decision{
+		{Keep: true, Snapshot: hashing.Snapshot{Node: hashing.Node{Path: "root", Children: []hashing.Node{{Path: "/etc/a", Hash: hashes[0], Stored: true}}}}},
+		{Keep: false, Snapshot: hashing.Snapshot{Node: hashing.Node{Path: "root", Children: []hashing.Node{{Path: "/etc/a", Hash: hashes[1], Stored: true}}}}},
+	}
+	removed, freed, err := CollectObjects(store, decisions)
+	if err != nil {
+		t.Fatalf("CollectObjects returned an error: %v", err)
+	}
+	if removed != 1 || freed != int64(len("removed")) {
+		t.Errorf("Expected 1 object and 7 bytes removed, got %d and %d", removed, freed)
+	}
+	if !objects.Has(store, hashes[0]) || objects.Has(store, hashes[1]) {
+		t.Errorf("Expected only the object of the kept snapshot to remain")
+	}
+}
@@ -0,0 +1,244 @@
+package push
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"magma/internal/hashing"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the headers carrying the signature of a pushed snapshot
+const (
+	PublicKeyHeader = "X-Magma-Public-Key"
+	SignatureHeader = "X-Magma-Signature"
+)
+
+// Options describes where snapshots are pushed from and to
+type Options struct {
+	URL          string             // Base URL of the collector
+	Token        string             // Bearer token expected by the collector, if any
+	DeviceID     string             // The device the snapshots are stored under
+	Key          ed25519.PrivateKey // Signing key of the device
+	SnapshotsDir string
+	StateFile    string       // Records the snapshots the collector acknowledged
+	Client       *http.Client // Nil uses a client with a 30 second timeout
+}
+
+// Push uploads the snapshots the collector has not acknowledged yet, oldest first. A snapshot
+// whose file changed since it was pushed, e.g. because it was tagged, is pushed again. Pushing
+// stops at the first failure so the snapshots left are retried, in order, by the next push.
+//
+// Parameters:
+//   - opts: the collector and the snapshots to push.
+//
+// Returns:
+//   - int: the number of snapshots pushed.
+//   - error: an error if a snapshot could not be pushed.
+func Push(opts Options) (int, error) {
+	if opts.URL == "" {
+		return 0, fmt.Errorf("no collector configured, set push.url in the config file")
+	}
+	if opts.DeviceID == "" {
+		return 0, fmt.Errorf("device_id is empty, set it in the config file")
+	}
+
+	snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+	if err != nil {
+		return 0, err
+	}
+	state, err := readState(opts.StateFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// forget the snapshots that were pruned
+	current := map[string]string{}
+	for _, snapshot := range snapshots {
+		if digest, found := state[snapshot.ID]; found {
+			current[snapshot.ID] = digest
+		}
+	}
+
+	pushed := 0
+	for _, snapshot := range snapshots {
+		body, err := os.ReadFile(snapshot.File)
+		if err != nil {
+			return pushed, err
+		}
+		digest := fmt.Sprintf("%x", sha256.Sum256(body))
+		if current[snapshot.ID] == digest {
+			continue
+		}
+
+		if err := upload(opts, snapshot.ID, body); err != nil {
+			return pushed, fmt.Errorf("pushing %s: %w", snapshot.ID, err)
+		}
+		pushed++
+
+		current[snapshot.ID] = digest
+		if err := writeState(opts.StateFile, current); err != nil {
+			return pushed, err
+		}
+	}
+	return pushed, nil
+}
+
+// Pending returns the number of snapshots the collector has not acknowledged yet
+func Pending(opts Options) (int, error) {
+	snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+	if err != nil {
+		return 0, err
+	}
+	state, err := readState(opts.StateFile)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, snapshot := range snapshots {
+		body, err := os.ReadFile(snapshot.File)
+		if err != nil {
+			return 0, err
+		}
+		if state[snapshot.ID] != fmt.Sprintf("%x", sha256.Sum256(body)) {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Loop pushes the pending snapshots on start and every time it is triggered, until the context is
+// cancelled. A failed push is retried every retry interval until it succeeds.
+//
+// Parameters:
+//   - ctx: cancelling the context stops the loop.
+//   - opts: the collector and the snapshots to push.
+//   - retry: the delay before retrying a failed push.
+//   - trigger: receives a value when a new snapshot was taken.
+func Loop(ctx context.Context, opts Options, retry time.Duration, trigger <-chan struct{}) {
+	var retryTimer <-chan time.Time
+	for {
+		pushed, err := Push(opts)
+		switch {
+		case err != nil:
+			log.Printf("Error pushing snapshots, retrying in %s: %v", retry, err)
+			retryTimer = time.After(retry)
+		case pushed > 0:
+			log.Printf("%d snapshot(s) pushed to %s", pushed, opts.URL)
+			retryTimer = nil
+		default:
+			retryTimer = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-retryTimer:
+		}
+	}
+}
+
+// upload sends a single signed snapshot to the collector
+func upload(opts Options, snapshotID string, body []byte) error {
+	target, err := url.JoinPath(opts.URL, "v1", "devices", opts.DeviceID, "snapshots", snapshotID)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(PublicKeyHeader, base64.StdEncoding.EncodeToString(opts.Key.Public().(ed25519.PublicKey)))
+	request.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(Sign(opts.Key, opts.DeviceID, snapshotID, body)))
+	if opts.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		var message struct {
+			Error string `json:"error"`
+		}
+		content, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		if json.Unmarshal(content, &message) == nil && message.Error != "" {
+			return fmt.Errorf("collector answered %s: %s", response.Status, message.Error)
+		}
+		return fmt.Errorf("collector answered %s", response.Status)
+	}
+	return nil
+}
+
+// readState reads the acknowledged snapshots, one "<id> <sha256 of the file>" per line
+func readState(path string) (map[string]string, error) {
+	state := map[string]string{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id, digest, found := strings.Cut(strings.TrimSpace(scanner.Text()), " "); found {
+			state[id] = digest
+		}
+	}
+	return state, scanner.Err()
+}
+
+// writeState atomically replaces the acknowledged snapshots
+func writeState(path string, state map[string]string) error {
+	var ids []string
+	for id := range state {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var content strings.Builder
+	for _, id := range ids {
+		content.WriteString(id + " " + state[id] + "\n")
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".pushed-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(content.String()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
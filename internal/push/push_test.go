@@ -0,0 +1,146 @@
+package push
+
+import (
+	"crypto/ed25519"
+	"magma/internal/hashing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+
+	key, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("LoadKey returned an error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key to be created with mode 0600, got %v, %v", info, err)
+	}
+
+	again, err := LoadKey(path)
+	if err != nil || !key.Equal(again) {
+		t.Errorf("Expected the same key to be loaded again, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("garbage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(path); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"path":"root"}`)
+	signature := Sign(key, "box-1", "20240131T120000Z-1a2b3c4d", body)
+
+	if !Verify(public, "box-1", "20240131T120000Z-1a2b3c4d", body, signature) {
+		t.Error("Expected the signature to verify")
+	}
+	// the signature is bound to the device and the snapshot id
+	if Verify(public, "box-2", "20240131T120000Z-1a2b3c4d", body, signature) || Verify(public, "box-1", "other", body, signature) {
+		t.Error("Expected the signature not to verify under another name")
+	}
+	if Verify(public, "box-1", "20240131T120000Z-1a2b3c4d", []byte(`{}`), signature) {
+		t.Error("Expected the signature not to verify another body")
+	}
+}
+
+// recorder is a collector stub recording the pushed snapshot ids, failing while down is set
+type recorder struct {
+	mu     sync.Mutex
+	down   bool
+	pushed []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		http.Error(w, `{"error": "down"}`, http.StatusServiceUnavailable)
+		return
+	}
+	r.pushed = append(r.pushed, filepath.Base(req.URL.Path))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// newOptions writes two snapshots and returns the options pushing them to the url
+func newOptions(t *testing.T, url string) Options {
+	root := t.TempDir()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{URL: url, DeviceID: "box-1", Key: key, SnapshotsDir: filepath.Join(root, "snapshots"), StateFile: filepath.Join(root, "pushed")}
+	if err := os.Mkdir(opts.SnapshotsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	for i, hash := range []string{"aaaa", "bbbb"} {
+		snapshot := hashing.Snapshot{Node: hashing.Node{Path: "root", Hash: hash}, Created: created.Add(time.Duration(i) * time.Hour)}
+		snapshot.ID = hashing.NewSnapshotID(snapshot.Created, snapshot.Hash)
+		if _, err := hashing.WriteSnapshot(opts.SnapshotsDir, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return opts
+}
+
+func TestPush(t *testing.T) {
+	collector := &recorder{down: true}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	opts := newOptions(t, server.URL)
+
+	// nothing is recorded as pushed while the collector is down
+	if pushed, err := Push(opts); err == nil || pushed != 0 {
+		t.Fatalf("Expected the push to fail, got %d, %v", pushed, err)
+	}
+	if pending, err := Pending(opts); err != nil || pending != 2 {
+		t.Errorf("Expected 2 pending snapshots, got %d, %v", pending, err)
+	}
+
+	collector.down = false
+	if pushed, err := Push(opts); err != nil || pushed != 2 {
+		t.Fatalf("Expected 2 snapshots to be pushed, got %d, %v", pushed, err)
+	}
+	if pushed, err := Push(opts); err != nil || pushed != 0 {
+		t.Errorf("Expected nothing left to push, got %d, %v", pushed, err)
+	}
+
+	// a snapshot changed after it was pushed is pushed again
+	snapshots, err := hashing.ListSnapshots(opts.SnapshotsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots[0].Tags = []string{"known-good"}
+	if _, err := hashing.WriteSnapshot(opts.SnapshotsDir, snapshots[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pushed, err := Push(opts); err != nil || pushed != 1 {
+		t.Errorf("Expected the tagged snapshot to be pushed again, got %d, %v", pushed, err)
+	}
+
+	expected := []string{snapshots[0].ID, snapshots[1].ID, snapshots[0].ID}
+	if len(collector.pushed) != len(expected) {
+		t.Fatalf("Expected %v to be pushed, got %v", expected, collector.pushed)
+	}
+	for i := range expected {
+		if collector.pushed[i] != expected[i] {
+			t.Errorf("Expected %v to be pushed, got %v", expected, collector.pushed)
+			break
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package push
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadKey reads the ed25519 signing key of the device, creating it on first use. The key is
+// stored base64 encoded and only readable by root.
+//
+// Parameters:
+//   - path: the path of the key file.
+//
+// Returns:
+//   - ed25519.PrivateKey: the signing key.
+//   - error: an error if the key cannot be read, is invalid or cannot be created.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+		if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%s is not a valid signing key", path)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// signedMessage is what is signed for a snapshot: the device and the snapshot id are part of it so
+// a signed snapshot cannot be replayed under another name
+func signedMessage(deviceID string, snapshotID string, body []byte) []byte {
+	return append([]byte("magma-snapshot\n"+deviceID+"\n"+snapshotID+"\n"), body...)
+}
+
+// Sign signs a snapshot file of a device
+func Sign(key ed25519.PrivateKey, deviceID string, snapshotID string, body []byte) []byte {
+	return ed25519.Sign(key, signedMessage(deviceID, snapshotID, body))
+}
+
+// Verify checks the signature of a snapshot file of a device
+func Verify(publicKey ed25519.PublicKey, deviceID string, snapshotID string, body []byte, signature []byte) bool {
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, signedMessage(deviceID, snapshotID, body), signature)
+}
@@ -0,0 +1,236 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month, month and day of week.
+// Each field is a bit set of the values it allows.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // The day fields were "*", see Next
+}
+
+// the range of values of each field, in order
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// macros are the supported shorthands for common schedules
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// ParseCron parses a cron expression. Each field is "*", a value, a range "a-b" or a comma
+// separated list of those, optionally followed by a step "/n". Sunday is 0 or 7, and the
+// @hourly, @daily, @weekly, @monthly and @yearly shorthands are accepted.
+//
+// Parameters:
+//   - expr: the cron expression, e.g. "0 */6 * * *".
+//
+// Returns:
+//   - Cron: the parsed expression.
+//   - error: an error if the expression is invalid.
+func ParseCron(expr string) (Cron, error) {
+	if macro, ok := macros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Cron{}, fmt.Errorf("invalid cron expression %q, expected 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return Cron{}, fmt.Errorf("invalid %s in cron expression %q: %v", fields[i].name, expr, err)
+		}
+		sets[i] = set
+	}
+
+	// sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	return Cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+// parseField parses a single field into the bit set of the values it allows
+func parseField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if base, stepText, found := strings.Cut(item, "/"); found {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			item = base
+		}
+
+		low, high := min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			lowText, highText, _ := strings.Cut(item, "-")
+			var err error
+			if low, err = parseValue(lowText, min, max); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(highText, min, max); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q", item)
+			}
+		default:
+			value, err := parseValue(item, min, max)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			// "5/10" means every 10 starting at 5
+			if step == 1 {
+				high = value
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// parseValue parses a single number and checks it is within the range of the field
+func parseValue(text string, min int, max int) (int, error) {
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", text)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", value, min, max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after the given time matching the expression, in the location of
+// the given time. As with cron, when both the day of month and the day of week are restricted, a
+// day matching either of them matches. The zero time is returned if the expression never matches,
+// e.g. "0 0 30 2 *".
+func (c Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// every schedule matches within a few years, unless it never does
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !has(c.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(c.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(c.minute, t.Minute()) {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of the given time matches the day fields
+func (c Cron) matchDay(t time.Time) bool {
+	dom := has(c.dom, t.Day())
+	dow := has(c.dow, int(t.Weekday()))
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// OnCalendar converts the expression to a systemd timer OnCalendar specification, e.g.
+// "0 */6 * * *" becomes "*-*-* 00,06,12,18:00:00". It returns an error for expressions systemd
+// cannot express, i.e. when both the day of month and the day of week are restricted.
+func (c Cron) OnCalendar() (string, error) {
+	if !c.domAny && !c.dowAny {
+		return "", fmt.Errorf("systemd timers cannot match either a day of month or a day of week, use the daemon service instead")
+	}
+
+	spec := ""
+	if !c.dowAny {
+		weekdays := []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+		var days []string
+		for day, name := range weekdays {
+			if has(c.dow, day) {
+				days = append(days, name)
+			}
+		}
+		spec = strings.Join(days, ",") + " "
+	}
+
+	spec += fmt.Sprintf("*-%s-%s %s:%s:00",
+		calendarField(c.month, 1, 12),
+		calendarField(c.dom, 1, 31),
+		calendarField(c.hour, 0, 23),
+		calendarField(c.minute, 0, 59))
+	return spec, nil
+}
+
+// calendarField renders a field as "*" when it allows every value, or as the list of its values
+func calendarField(set uint64, min int, max int) string {
+	var values []string
+	for value := min; value <= max; value++ {
+		if has(set, value) {
+			values = append(values, fmt.Sprintf("%02d", value))
+		}
+	}
+	if len(values) == max-min+1 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
+
+// has reports whether the value is in the set
+func has(set uint64, value int) bool {
+	return set&(1<<value) != 0
+}
@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"magma/internal/config"
+	"testing"
+	"time"
+)
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected an error", expr)
+		}
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	// a wednesday
+	start := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 8,20 * * *", time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// either the 15th or a monday
+		{"0 0 15 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		cron, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) returned an error: %v", test.expr, err)
+			continue
+		}
+		if next := cron.Next(start); !next.Equal(test.next) {
+			t.Errorf("%q: expected %s, got %s", test.expr, test.next, next)
+		}
+	}
+
+	never, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := never.Next(start); !next.IsZero() {
+		t.Errorf("Expected an impossible date to never match, got %s", next)
+	}
+}
+
+func TestCron_OnCalendar(t *testing.T) {
+	tests := map[string]string{
+		"0 */6 * * *": "*-*-* 00,06,12,18:00:00",
+		"30 2 * * *":  "*-*-* 02:30:00",
+		"0 9 * * 1-5": "Mon,Tue,Wed,Thu,Fri *-*-* 09:00:00",
+		"0 0 1 */3 *": "*-01,04,07,10-01 00:00:00",
+	}
+	for expr, expected := range tests {
+		cron, err := ParseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := cron.OnCalendar()
+		if err != nil || spec != expected {
+			t.Errorf("%q: expected %q, got %q, %v", expr, expected, spec, err)
+		}
+	}
+
+	cron, err := ParseCron("0 0 15 * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cron.OnCalendar(); err == nil {
+		t.Error("Expected an error when both day fields are restricted")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	if _, err := FromConfig(config.ScheduleConfig{}); err == nil {
+		t.Error("Expected an error without a schedule")
+	}
+	if _, err := FromConfig(config.ScheduleConfig{Cron: "@daily", Interval: time.Hour}); err == nil {
+		t.Error("Expected an error with both cron and interval")
+	}
+
+	schedule, err := FromConfig(config.ScheduleConfig{Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("FromConfig returned an error: %v", err)
+	}
+	start := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC)
+	if next := schedule.Next(start); !next.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected the next run an hour later, got %s", next)
+	}
+}
@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"fmt"
+	"magma/internal/config"
+	"time"
+)
+
+// Schedule decides when the next scheduled snapshot is due
+type Schedule interface {
+	// Next returns the first run after the given time, the zero time if there is none
+	Next(after time.Time) time.Time
+}
+
+// Interval runs at a fixed interval from the previous run
+type Interval time.Duration
+
+// Next returns the given time plus the interval
+func (i Interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// FromConfig returns the schedule configured in config.yaml.
+//
+// Parameters:
+//   - schedule: the schedule section of the configuration.
+//
+// Returns:
+//   - Schedule: the cron or interval schedule.
+//   - error: an error if neither or both of cron and interval are set, or if they are invalid.
+func FromConfig(schedule config.ScheduleConfig) (Schedule, error) {
+	switch {
+	case schedule.Cron != "" && schedule.Interval != 0:
+		return nil, fmt.Errorf("set either schedule.cron or schedule.interval in %s, not both", config.ConfigFile)
+	case schedule.Cron != "":
+		return ParseCron(schedule.Cron)
+	case schedule.Interval < 0:
+		return nil, fmt.Errorf("schedule.interval must be positive, got %s", schedule.Interval)
+	case schedule.Interval > 0:
+		return Interval(schedule.Interval), nil
+	}
+	return nil, fmt.Errorf("no schedule configured, set schedule.cron or schedule.interval in %s", config.ConfigFile)
+}
@@ -0,0 +1,135 @@
+package tag
+
+import (
+	"fmt"
+	"magma/internal/hashing"
+	"os"
+	"strings"
+	"time"
+)
+
+// Validate checks that a tag can be stored and typed back on the command line
+func Validate(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tag cannot be empty")
+	}
+	if strings.ContainsAny(tag, " \t\n/\\") {
+		return fmt.Errorf("tag %q cannot contain whitespace or slashes", tag)
+	}
+	return nil
+}
+
+// Add tags the snapshot matching ref. When move is true the tag is removed from every other
+// snapshot first, so that it identifies a single snapshot (e.g. "baseline").
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - ref: a reference to the snapshot to tag, see hashing.FindSnapshot.
+//   - tag: the tag to add.
+//   - move: whether to remove the tag from every other snapshot.
+//
+// Returns:
+//   - hashing.Snapshot: the tagged snapshot.
+//   - error: an error if the tag is invalid, the snapshot cannot be found or written.
+func Add(dir string, ref string, tag string, move bool) (hashing.Snapshot, error) {
+	if err := Validate(tag); err != nil {
+		return hashing.Snapshot{}, err
+	}
+
+	snapshot, err := hashing.FindSnapshot(dir, ref)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if move {
+		if err := hashing.ClearTag(dir, tag, snapshot.ID); err != nil {
+			return snapshot, err
+		}
+	}
+
+	if snapshot.HasTag(tag) {
+		return snapshot, nil
+	}
+	snapshot.Tags = append(snapshot.Tags, tag)
+	return snapshot, rewrite(dir, snapshot)
+}
+
+// Remove removes a tag from the snapshot matching ref.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - ref: a reference to the snapshot, see hashing.FindSnapshot.
+//   - tag: the tag to remove.
+//
+// Returns:
+//   - hashing.Snapshot: the updated snapshot.
+//   - error: an error if the snapshot cannot be found, does not carry the tag or cannot be written.
+func Remove(dir string, ref string, tag string) (hashing.Snapshot, error) {
+	snapshot, err := hashing.FindSnapshot(dir, ref)
+	if err != nil {
+		return snapshot, err
+	}
+	if !snapshot.HasTag(tag) {
+		return snapshot, fmt.Errorf("snapshot %s is not tagged %q", snapshot.ID, tag)
+	}
+
+	snapshot.Tags = without(snapshot.Tags, tag)
+	return snapshot, rewrite(dir, snapshot)
+}
+
+// Note appends an annotation to the snapshot matching ref.
+//
+// Parameters:
+//   - dir: the snapshots directory.
+//   - ref: a reference to the snapshot, see hashing.FindSnapshot.
+//   - message: the annotation.
+//   - author: who wrote the annotation, may be empty.
+//
+// Returns:
+//   - hashing.Snapshot: the updated snapshot.
+//   - error: an error if the message is empty, the snapshot cannot be found or written.
+func Note(dir string, ref string, message string, author string) (hashing.Snapshot, error) {
+	if strings.TrimSpace(message) == "" {
+		return hashing.Snapshot{}, fmt.Errorf("note cannot be empty")
+	}
+
+	snapshot, err := hashing.FindSnapshot(dir, ref)
+	if err != nil {
+		return snapshot, err
+	}
+
+	snapshot.Notes = append(snapshot.Notes, hashing.Note{
+		Time:    time.Now().UTC(),
+		Author:  author,
+		Message: message,
+	})
+	return snapshot, rewrite(dir, snapshot)
+}
+
+// CurrentUser returns the name of the user running magma, through sudo when possible
+func CurrentUser() string {
+	for _, env := range []string{"SUDO_USER", "USER", "LOGNAME"} {
+		if user := os.Getenv(env); user != "" {
+			return user
+		}
+	}
+	return ""
+}
+
+// rewrite writes the snapshot back to its file. Legacy snapshots keep their file name as id,
+// once rewritten their tags are read from the metadata instead of the file name.
+func rewrite(dir string, snapshot hashing.Snapshot) error {
+	_, err := hashing.WriteSnapshot(dir, snapshot)
+	return err
+}
+
+// without returns the tags without the given tag
+func without(tags []string, tag string) []string {
+	var result []string
+	for _, t := range tags {
+		if t != tag {
+			result = append(result, t)
+		}
+	}
+	return result
+}
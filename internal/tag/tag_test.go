@@ -0,0 +1,143 @@
+package tag
+
+import (
+	"magma/internal/hashing"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSnapshots writes n empty snapshots an hour apart and returns their ids, oldest first
+func writeSnapshots(t *testing.T, dir string, n int) []string {
+	var ids []string
+	for i := 0; i < n; i++ {
+		snapshot := hashing.Snapshot{
+			Node:    hashing.Node{Path: "root", Hash: hashing.HashChildren([]hashing.Node{{Hash: string(rune('a' + i))}})},
+			Created: time.Now().Add(time.Duration(i-n) * time.Hour),
+		}
+		snapshot.ID = hashing.NewSnapshotID(snapshot.Created, snapshot.Hash)
+		if _, err := hashing.WriteSnapshot(dir, snapshot); err != nil {
+			t.Fatalf("WriteSnapshot returned an error: %v", err)
+		}
+		ids = append(ids, snapshot.ID)
+	}
+	return ids
+}
+
+func TestAddRemove(t *testing.T) {
+	dir := t.TempDir()
+	ids := writeSnapshots(t, dir, 2)
+
+	if _, err := Add(dir, ids[0], "known-good", false); err != nil {
+		t.Fatalf("Add returned an error: %v", err)
+	}
+	if _, err := Add(dir, ids[1], "known-good", false); err != nil {
+		t.Fatalf("Add returned an error: %v", err)
+	}
+
+	// without move both snapshots carry the tag
+	for _, id := range ids {
+		snapshot, err := hashing.ReadSnapshot(filepath.Join(dir, id+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !snapshot.HasTag("known-good") {
+			t.Errorf("Expected snapshot %s to be tagged", id)
+		}
+	}
+
+	if _, err := Remove(dir, ids[0], "known-good"); err != nil {
+		t.Fatalf("Remove returned an error: %v", err)
+	}
+	snapshot, err := hashing.ReadSnapshot(filepath.Join(dir, ids[0]+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.HasTag("known-good") {
+		t.Errorf("Expected the tag to be removed from %s", ids[0])
+	}
+
+	if _, err := Remove(dir, ids[0], "known-good"); err == nil {
+		t.Errorf("Expected an error when removing a missing tag")
+	}
+}
+
+func TestAdd_Move(t *testing.T) {
+	dir := t.TempDir()
+	ids := writeSnapshots(t, dir, 2)
+
+	if _, err := Add(dir, ids[0], "baseline", true); err != nil {
+		t.Fatalf("Add returned an error: %v", err)
+	}
+	if _, err := Add(dir, ids[1], "baseline", true); err != nil {
+		t.Fatalf("Add returned an error: %v", err)
+	}
+
+	snapshot, err := hashing.FindSnapshot(dir, "baseline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ID != ids[1] {
+		t.Errorf("Expected the baseline to move to %s, got %s", ids[1], snapshot.ID)
+	}
+
+	old, err := hashing.ReadSnapshot(filepath.Join(dir, ids[0]+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.HasTag("baseline") {
+		t.Errorf("Expected the baseline tag to be removed from %s", ids[0])
+	}
+}
+
+func TestAdd_InvalidTag(t *testing.T) {
+	dir := t.TempDir()
+	ids := writeSnapshots(t, dir, 1)
+
+	if _, err := Add(dir, ids[0], "two words", false); err == nil {
+		t.Errorf("Expected an error for a tag with whitespace")
+	}
+}
+
+func TestRemove_LegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	// tags of legacy snapshots are part of the file name
+	legacy := filepath.Join(dir, "abcd1234_tag1_tag2.json")
+	if err := os.WriteFile(legacy, []byte(`{"path":"root","hash":"abcd1234","children":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Remove(dir, "abcd1234", "tag1"); err != nil {
+		t.Fatalf("Remove returned an error: %v", err)
+	}
+
+	snapshot, err := hashing.ReadSnapshot(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.HasTag("tag1") || !snapshot.HasTag("tag2") {
+		t.Errorf("Expected only tag2 to be left, got %v", snapshot.Tags)
+	}
+}
+
+func TestNote(t *testing.T) {
+	dir := t.TempDir()
+	ids := writeSnapshots(t, dir, 1)
+
+	if _, err := Note(dir, ids[0], "", "alice"); err == nil {
+		t.Errorf("Expected an error for an empty note")
+	}
+	if _, err := Note(dir, ids[0], "known good state after upgrade", "alice"); err != nil {
+		t.Fatalf("Note returned an error: %v", err)
+	}
+
+	snapshot, err := hashing.ReadSnapshot(filepath.Join(dir, ids[0]+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Notes) != 1 || snapshot.Notes[0].Author != "alice" {
+		t.Errorf("Expected one note by alice, got %+v", snapshot.Notes)
+	}
+}
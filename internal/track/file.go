@@ -0,0 +1,196 @@
+package track
+
+import (
+	"errors"
+	"fmt"
+	"magma/internal/parsing"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEditAborted is returned by Edit when the edited track file is invalid and not edited again
+var ErrEditAborted = errors.New("edit aborted, the track file is unchanged")
+
+// Import adds the entries of another file, in the format of the track file, options included.
+// Each entry is added on its own, see AddEntry, an entry that cannot be tracked does not prevent
+// the others from being added.
+//
+// Parameters:
+//   - importPath: the file to import.
+//   - trackFilePath: The path to the track file.
+//
+// Returns:
+//   - int: the number of entries added or already tracked.
+//   - []string: warnings about the entries, to show to the user.
+//   - error: an error if the file cannot be read, or the errors of the entries that were not added.
+func Import(importPath string, trackFilePath string) (int, []string, error) {
+	entries, err := parsing.ReadTrackFile(importPath)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	added := 0
+	var warnings []string
+	var errs []error
+	for _, entry := range entries {
+		entryWarnings, err := AddEntry(entry, trackFilePath)
+		if errors.Is(err, ErrAlreadyTracked) {
+			added++
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		added++
+		warnings = append(warnings, entryWarnings...)
+	}
+	return added, warnings, errors.Join(errs...)
+}
+
+// Export writes the entries of the track file to another file, one per line with their options,
+// so they can be imported on another device.
+//
+// Parameters:
+//   - exportPath: the file to write.
+//   - trackFilePath: The path to the track file.
+//
+// Returns:
+//   - int: the number of entries exported.
+//   - error: an error if the track file cannot be read or the file cannot be written.
+func Export(exportPath string, trackFilePath string) (int, error) {
+	entries, err := parsing.ReadTrackFile(trackFilePath)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), parsing.WriteTrack(lines(entries), exportPath)
+}
+
+// Validate checks the entries of a file in the format of the track file: every line must parse,
+// every path must be absolute, clean and exist, every pattern must be valid and no path may be
+// tracked twice. Overlapping entries are reported as warnings.
+//
+// Parameters:
+//   - path: the file to check.
+//
+// Returns:
+//   - []string: warnings about the entries, to show to the user.
+//   - error: the problems that make the file invalid, joined.
+func Validate(path string) ([]string, error) {
+	entries, err := parsing.ReadTrackFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	var errs []error
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		abs, err := Normalize(entry.Path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if abs != entry.Path {
+			errs = append(errs, fmt.Errorf("%s is not absolute and clean, write %s", entry.Path, abs))
+			continue
+		}
+		if err := checkExists(entry); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[entry.Path] {
+			errs = append(errs, fmt.Errorf("%s is tracked twice", entry.Path))
+			continue
+		}
+		seen[entry.Path] = true
+
+		for j, other := range entries {
+			if j != i && other.Path != entry.Path && Overlaps(other, entry) {
+				warnings = append(warnings, fmt.Sprintf("%s is inside %s, it will be hashed twice", entry.Path, other.Path))
+				break
+			}
+		}
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// Edit opens a copy of the track file in an editor and replaces the track file with it once it is
+// valid, see Validate. Comments and blank lines are kept as written.
+//
+// Parameters:
+//   - trackFilePath: The path to the track file.
+//   - editor: the editor command, e.g. $EDITOR, it may hold arguments like "code --wait".
+//   - retry: called with the problems of an invalid edit, returns true to edit the file again.
+//
+// Returns:
+//   - []string: warnings about the entries of the new track file.
+//   - error: ErrEditAborted if the edit was invalid and not retried, or an error if the editor
+//     fails or the track file cannot be written.
+func Edit(trackFilePath string, editor string, retry func(error) bool) ([]string, error) {
+	command := strings.Fields(editor)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no editor, set $EDITOR")
+	}
+
+	content, err := os.ReadFile(trackFilePath)
+	if err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp("", "magma-track-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		cmd := exec.Command(command[0], append(command[1:], temp.Name())...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("editor %s: %w", editor, err)
+		}
+
+		warnings, err := Validate(temp.Name())
+		if err == nil {
+			edited, err := os.ReadFile(temp.Name())
+			if err != nil {
+				return nil, err
+			}
+			return warnings, replace(trackFilePath, edited)
+		}
+		if !retry(err) {
+			return nil, fmt.Errorf("%w: %w", ErrEditAborted, err)
+		}
+	}
+}
+
+// replace atomically replaces the content of a file
+func replace(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".track-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,125 @@
+package track
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTrack writes a track file holding the given lines and returns its path
+func writeTrack(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "track")
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportExport(t *testing.T) {
+	dir := t.TempDir()
+	source := writeTrack(t, dir+" label=web store-content", "/non/existent/path", dir+"/*.conf")
+
+	trackFile := writeTrack(t)
+	added, _, err := Import(source, trackFile)
+	if err == nil || !strings.Contains(err.Error(), "/non/existent/path") {
+		t.Errorf("Expected the missing path to be reported, got %v", err)
+	}
+	if added != 1 {
+		t.Errorf("Expected 1 path imported, got %d", added)
+	}
+
+	exported := filepath.Join(t.TempDir(), "export")
+	count, err := Export(exported, trackFile)
+	if err != nil {
+		t.Fatalf("Export returned an error: %v", err)
+	}
+	content, err := os.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pattern inside the imported directory is refused, the options are kept
+	if count != 1 || string(content) != dir+" store-content label=web\n" {
+		t.Errorf("Expected the imported entry with its options, got %d entries: %q", count, content)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings, err := Validate(writeTrack(t, "# tracked", dir, sub+" max-depth=1", dir+"/*.conf"))
+	if err != nil {
+		t.Errorf("Expected a valid file, got %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("Expected warnings for the entries inside %s, got %v", dir, warnings)
+	}
+
+	for _, line := range []string{"relative/path", dir + "/", "/non/existent/path", "/etc/[abc", "/etc colour=red"} {
+		if _, err := Validate(writeTrack(t, line)); err == nil {
+			t.Errorf("Expected %q to be invalid", line)
+		}
+	}
+	if _, err := Validate(writeTrack(t, dir, dir+" label=twice")); err == nil {
+		t.Errorf("Expected a path tracked twice to be invalid")
+	}
+}
+
+// writeEditor writes an editor script replacing the edited file with the content of the files
+// given, one per run, and returns its path
+func writeEditor(t *testing.T, contents ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nrun=$(cat " + dir + "/run 2>/dev/null || echo 0)\necho $((run + 1)) > " + dir + "/run\ncp " + dir + "/content$run \"$1\"\n"
+	for i, content := range contents {
+		if err := os.WriteFile(filepath.Join(dir, "content"+string(rune('0'+i))), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	editor := filepath.Join(dir, "editor")
+	if err := os.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return editor
+}
+
+func TestEdit(t *testing.T) {
+	dir := t.TempDir()
+	trackFile := writeTrack(t, dir)
+
+	// an invalid edit is retried, then saved as written
+	edited := "# edited\n" + dir + " label=web\n"
+	retries := 0
+	_, err := Edit(trackFile, writeEditor(t, "relative/path\n", edited), func(err error) bool {
+		retries++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Edit returned an error: %v", err)
+	}
+	if retries != 1 {
+		t.Errorf("Expected a single retry, got %d", retries)
+	}
+	if content, _ := os.ReadFile(trackFile); string(content) != edited {
+		t.Errorf("Expected the edited content, got %q", content)
+	}
+
+	// an invalid edit not retried leaves the track file unchanged
+	_, err = Edit(trackFile, writeEditor(t, "/non/existent/path\n"), func(err error) bool { return false })
+	if !errors.Is(err, ErrEditAborted) {
+		t.Errorf("Expected ErrEditAborted, got %v", err)
+	}
+	if content, _ := os.ReadFile(trackFile); string(content) != edited {
+		t.Errorf("Expected the track file to be unchanged, got %q", content)
+	}
+}
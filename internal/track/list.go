@@ -0,0 +1,61 @@
+package track
+
+import (
+	"magma/internal/hashing"
+	"magma/internal/parsing"
+	"os"
+)
+
+// Status describes what an entry of the track file currently holds on disk
+type Status struct {
+	Entry   parsing.TrackEntry
+	Exists  bool  // The path exists, or the pattern matches at least one path
+	Matches int   // The number of paths a pattern matches, 1 for an existing path
+	Files   int   // The regular files under the paths
+	Size    int64 // The bytes of those files
+}
+
+// List reads the track file and describes each entry. Files are counted on disk before the ignore
+// list applies, without descending into the file systems snapshots skip, see hashing.CountFiles.
+//
+// Parameters:
+//   - trackFilePath: The path to the track file.
+//
+// Returns:
+//   - []Status: the status of each entry, in the order of the track file.
+//   - []string: warnings about the .magmaignore files the patterns are expanded with.
+//   - error: an error if the track file cannot be read or a pattern is not valid.
+func List(trackFilePath string) ([]Status, []string, error) {
+	entries, err := parsing.ReadTrackFile(trackFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes := hashing.NewIgnoreScopes()
+	statuses := make([]Status, 0, len(entries))
+	for _, entry := range entries {
+		status := Status{Entry: entry}
+
+		paths := []string{entry.Path}
+		if entry.IsPattern() {
+			if paths, err = scopes.Expand(entry.Path); err != nil {
+				return nil, scopes.Warnings(), err
+			}
+		}
+		for _, path := range paths {
+			if _, err := os.Lstat(path); err != nil {
+				continue
+			}
+			status.Matches++
+			matched := entry
+			matched.Path = path
+			files, size := hashing.CountFiles(matched)
+			status.Files += files
+			status.Size += size
+		}
+		status.Exists = status.Matches > 0
+
+		statuses = append(statuses, status)
+	}
+	return statuses, scopes.Warnings(), nil
+}
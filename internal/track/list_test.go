@@ -0,0 +1,48 @@
+package track
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.conf": "aa", "sub/b.conf": "bbb", "c.log": "c"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trackFile := filepath.Join(t.TempDir(), "track")
+	content := dir + " label=app\n/non/existent/path\n" + dir + "/*.conf\n" + dir + "/*.missing\n"
+	if err := os.WriteFile(trackFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, _, err := List(trackFile)
+	if err != nil {
+		t.Fatalf("List returned an error: %v", err)
+	}
+	if len(statuses) != 4 {
+		t.Fatalf("Expected 4 entries, got %+v", statuses)
+	}
+
+	expected := []Status{
+		{Exists: true, Matches: 1, Files: 3, Size: 6},
+		{},
+		{Exists: true, Matches: 1, Files: 1, Size: 2},
+		{},
+	}
+	for i, status := range statuses {
+		if status.Exists != expected[i].Exists || status.Matches != expected[i].Matches || status.Files != expected[i].Files || status.Size != expected[i].Size {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, expected[i], status)
+		}
+	}
+	if statuses[0].Entry.Label != "app" {
+		t.Errorf("Expected the options of the entry, got %+v", statuses[0].Entry)
+	}
+}
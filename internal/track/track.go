@@ -1,55 +1,129 @@
 package track
 
 import (
+	"errors"
 	"fmt"
-	"magma/internal/config"
+	"magma/internal/hashing"
 	"magma/internal/parsing"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
 )
 
+// ErrNotTracked is returned when untracking a path that is not in the track file
+var ErrNotTracked = errors.New("not tracked")
+
+// ErrAlreadyTracked is returned when tracking a path that is already in the track file
+var ErrAlreadyTracked = errors.New("already tracked")
+
+// Normalize returns the canonical form of a path to track: absolute and clean, without trailing
+// slash, so that ./conf, /etc/nginx/ and /etc/nginx//sites are recorded the way snap finds them
+// whatever the directory it runs from. Symlinks are not resolved.
+//
+// Parameters:
+//   - path: the path, or pattern, as typed.
+//
+// Returns:
+//   - string: the absolute, clean path.
+//   - error: an error if the path is empty or the working directory cannot be determined.
+func Normalize(path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	return filepath.Abs(path)
+}
+
 // adds new path to the track file
-// AddPath adds a new path to the track file if it does not already exist.
+// AddPath adds a new path to the track file if it does not already exist, see AddEntry.
+//
+// Parameters:
+//   - newPath: The new path, or pattern, to be added.
+//   - trackFilePath: The path to the track file.
+//
+// Returns:
+//   - []string: warnings about the path, to show to the user.
+//   - error: An error if the path cannot be tracked, see AddEntry.
+func AddPath(newPath string, trackFilePath string) ([]string, error) {
+	return AddEntry(parsing.TrackEntry{Path: newPath}, trackFilePath)
+}
+
+// AddEntry adds a new entry to the track file if its path is not tracked yet. The path is
+// normalized first, see Normalize. A glob pattern, like /home/*/.bashrc, is stored as is and
+// expanded when snapshots are taken, so it may match nothing yet.
+//
+// A path without options inside a tracked path is already hashed and is refused. Tracking the
+// parent of tracked paths, a path with options inside a tracked path, or a path the ignore list
+// skips, is allowed but reported as warnings.
 //
 // Parameters:
-//   - newPath: The new path to be added.
+//   - newEntry: The new entry, a path or pattern with its options.
 //   - trackFilePath: The path to the track file.
 //
 // Returns:
-//   - error: An error if the new path does not exist, if there is an error reading the track file,
-//     or if there is an error writing to the track file. Returns nil if the path is successfully added
-//     or if the path already exists in the track file.
-func AddPath(newPath string, trackFilePath string) error {
+//   - []string: warnings about the entry, to show to the user.
+//   - error: An error if the new path does not exist, the pattern is not valid or the path is
+//     inside a tracked path, if there is an error reading the track file, or if there is an error
+//     writing to the track file. ErrAlreadyTracked if its path already is in the track file.
+func AddEntry(newEntry parsing.TrackEntry, trackFilePath string) ([]string, error) {
+
+	newPath, err := Normalize(newEntry.Path)
+	if err != nil {
+		return nil, err
+	}
+	newEntry.Path = newPath
 
-	// check if the new path exists
-	_, err := os.Stat(newPath)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("path %s does not exist", newPath)
+	// check if the new path exists, or that the pattern is valid
+	if err := checkExists(newEntry); err != nil {
+		return nil, err
 	}
 
-	// read the current paths from the track file
-	currentPaths, err := parsing.ReadMagmaFile(config.TrackFile)
+	// read the current entries from the track file
+	entries, err := parsing.ReadTrackFile(trackFilePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// check if the new path is already in the track file
-	for _, path := range currentPaths {
-		if path == newPath {
-			println("Path already exists in the track file")
-			return nil
+	// check if the new path is already in the track file, or inside a tracked path
+	var warnings []string
+	for _, entry := range entries {
+		// entries written before paths were normalized may be in another form
+		entry.Path = normalized(entry.Path)
+		if entry.Path == newPath {
+			return nil, fmt.Errorf("%s is %w", newPath, ErrAlreadyTracked)
+		}
+		if Overlaps(entry, newEntry) {
+			if !hasOptions(newEntry) {
+				return nil, fmt.Errorf("%s is already tracked through %s", newPath, entry.Path)
+			}
+			warnings = append(warnings, fmt.Sprintf("%s is inside %s, it will be hashed twice", newPath, entry.Path))
 		}
+		if Overlaps(newEntry, entry) {
+			warnings = append(warnings, fmt.Sprintf("%s is inside %s, it will be hashed twice, untrack it unless its options differ", entry.Path, newPath))
+		}
+	}
+	scopes := hashing.NewIgnoreScopes()
+	if !newEntry.IsPattern() && scopes.IsIgnored(newPath, newPath, isDir(newPath)) {
+		warnings = append(warnings, fmt.Sprintf("%s is ignored by the ignore list, snapshots will skip it", newPath))
 	}
+	if newEntry.IsPattern() {
+		if rule, _ := scopes.IgnoredBy(newPath); rule != nil {
+			warnings = append(warnings, fmt.Sprintf("every path %s matches is ignored by %s, snapshots will skip them", newPath, rule))
+		}
+	}
+	warnings = append(warnings, scopes.Warnings()...)
 
-	// append the new path to the current paths
-	currentPaths = append(currentPaths, newPath)
+	// append the new entry to the current entries
+	entries = append(entries, newEntry)
 
-	// write the new paths to the track file
-	err = parsing.WriteTrack(currentPaths, trackFilePath)
+	// write the new entries to the track file, with their options
+	err = parsing.WriteTrack(lines(entries), trackFilePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return warnings, nil
 }
 
 // removes path from the track file
@@ -57,39 +131,115 @@ func AddPath(newPath string, trackFilePath string) error {
 //
 // It reads the current paths from the tracking file, checks if the path to be removed
 // is present, removes it if found, and then writes the updated paths back to the tracking file.
+// The path is normalized first, see Normalize, and matches entries written in another form.
 //
 // Parameters:
 //   - pathToRemove: The path that needs to be removed from the tracking file.
 //   - trackFilePath: The file path of the tracking file.
 //
 // Returns:
-//   - error: An error if there is an issue reading from or writing to the tracking file, otherwise nil.
+//   - error: ErrNotTracked if no entry matches the path, telling which entry tracks it if any,
+//     or an error if there is an issue reading from or writing to the tracking file, otherwise nil.
 func RemovePath(pathToRemove string, trackFilePath string) error {
 
-	// read the current paths from the track file
-	currentPaths, err := parsing.ReadMagmaFile(trackFilePath)
+	pathToRemove, err := Normalize(pathToRemove)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(currentPaths)
-	fmt.Println(pathToRemove)
+	// read the current entries from the track file
+	entries, err := parsing.ReadTrackFile(trackFilePath)
+	if err != nil {
+		return err
+	}
 
 	// check if the path to remove is in the track file and remove it
-	for i, path := range currentPaths {
-		if path == pathToRemove {
-			currentPaths = append(currentPaths[:i], currentPaths[i+1:]...)
+	removed := false
+	for i, entry := range entries {
+		if normalized(entry.Path) == pathToRemove {
+			entries = append(entries[:i], entries[i+1:]...)
+			removed = true
 			break
 		}
 	}
 
-	fmt.Println(currentPaths)
+	if !removed {
+		for _, entry := range entries {
+			if hashing.Tracks(entry, pathToRemove) {
+				return fmt.Errorf("%s is %w itself, it is tracked through %s", pathToRemove, ErrNotTracked, entry.Path)
+			}
+		}
+		return fmt.Errorf("%s is %w", pathToRemove, ErrNotTracked)
+	}
 
-	// write the new paths to the track file
-	err = parsing.WriteTrack(currentPaths, trackFilePath)
+	// write the new entries to the track file
+	err = parsing.WriteTrack(lines(entries), trackFilePath)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Overlaps reports whether everything the inner entry tracks is already tracked by the outer
+// entry: the inner path is the outer path or under it, or it matches the outer pattern. For an
+// inner pattern, the directory it starts with must be tracked by the outer entry.
+func Overlaps(outer parsing.TrackEntry, inner parsing.TrackEntry) bool {
+	if outer.Path == inner.Path {
+		return true
+	}
+	path := inner.Path
+	if inner.IsPattern() {
+		base, _ := doublestar.SplitPattern(path)
+		// the matches of a pattern are only known once expanded, unless the outer path holds them all
+		if outer.IsPattern() {
+			return false
+		}
+		path = base
+	}
+	return hashing.Tracks(outer, path)
+}
+
+// checkExists makes sure the path of an entry exists, or that its pattern is valid
+func checkExists(entry parsing.TrackEntry) error {
+	if entry.IsPattern() {
+		if !doublestar.ValidatePattern(entry.Path) {
+			return fmt.Errorf("pattern %s is not valid", entry.Path)
+		}
+		return nil
+	}
+	if _, err := os.Stat(entry.Path); os.IsNotExist(err) {
+		return fmt.Errorf("path %s does not exist", entry.Path)
+	}
+	return nil
+}
+
+// hasOptions reports whether an entry has options, and may hash its path differently from an
+// entry holding it
+func hasOptions(entry parsing.TrackEntry) bool {
+	return entry.String() != entry.Path
+}
+
+// normalized returns the normalized form of a path of the track file, or the path itself if it
+// cannot be normalized
+func normalized(path string) string {
+	if abs, err := Normalize(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// isDir reports whether a path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// lines returns the entries as lines of the track file
+func lines(entries []parsing.TrackEntry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.String()
+	}
+	return lines
+}
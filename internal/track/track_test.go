@@ -1,7 +1,10 @@
 package track
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -15,7 +18,7 @@ func TestAddPath(t *testing.T) {
 
 	// Test case: Add a new path that does not exist
 	newPath := "/new/path"
-	err = AddPath(newPath, tmpFile.Name())
+	_, err = AddPath(newPath, tmpFile.Name())
 	if err == nil {
 		t.Errorf("Expected error, got nil")
 	}
@@ -27,7 +30,7 @@ func TestAddPath(t *testing.T) {
 	}
 	defer os.Remove(tmpFile2.Name())
 	newPath = tmpFile2.Name()
-	err = AddPath(newPath, tmpFile.Name())
+	_, err = AddPath(newPath, tmpFile.Name())
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -42,9 +45,9 @@ func TestAddPath(t *testing.T) {
 	}
 
 	// Test case: Add the same path again
-	err = AddPath(newPath, tmpFile.Name())
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+	_, err = AddPath(newPath, tmpFile.Name())
+	if !errors.Is(err, ErrAlreadyTracked) {
+		t.Errorf("Expected ErrAlreadyTracked, got %v", err)
 	}
 
 	// Verify the path was not duplicated
@@ -58,7 +61,7 @@ func TestAddPath(t *testing.T) {
 
 	// Test case: Add a non-existent path
 	nonExistentPath := "/non/existent/path"
-	err = AddPath(nonExistentPath, tmpFile.Name())
+	_, err = AddPath(nonExistentPath, tmpFile.Name())
 	if err == nil {
 		t.Errorf("Expected error, got nil")
 	}
@@ -79,7 +82,7 @@ func TestRemovePath(t *testing.T) {
 	}
 	defer os.Remove(tmpFile2.Name())
 	newPath := tmpFile2.Name()
-	err = AddPath(newPath, tmpFile.Name())
+	_, err = AddPath(newPath, tmpFile.Name())
 	if err != nil {
 		t.Fatalf("Failed to add path: %v", err)
 	}
@@ -102,7 +105,96 @@ func TestRemovePath(t *testing.T) {
 	// Test case: Remove a non-existent path
 	nonExistentPath := "/non/existent/path"
 	err = RemovePath(nonExistentPath, tmpFile.Name())
+	if !errors.Is(err, ErrNotTracked) {
+		t.Errorf("Expected ErrNotTracked, got %v", err)
+	}
+}
+
+func TestAddPath_Pattern(t *testing.T) {
+	trackFile := filepath.Join(t.TempDir(), "track")
+	if err := os.WriteFile(trackFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pattern is stored as is, even when it matches nothing yet
+	if _, err := AddPath("/home/*/.bashrc", trackFile); err != nil {
+		t.Fatalf("Expected the pattern to be tracked, got %v", err)
+	}
+	content, err := os.ReadFile(trackFile)
 	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+		t.Fatal(err)
+	}
+	if string(content) != "/home/*/.bashrc\n" {
+		t.Errorf("Expected the pattern in the track file, got %q", content)
+	}
+
+	if _, err := AddPath("/etc/[abc", trackFile); err == nil {
+		t.Errorf("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestAddPath_Normalize(t *testing.T) {
+	trackFile := filepath.Join(t.TempDir(), "track")
+	if err := os.WriteFile(trackFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	if _, err := AddPath(dir+"/", trackFile); err != nil {
+		t.Fatalf("AddPath returned an error: %v", err)
+	}
+	// another form of the same path is not added twice
+	if _, err := AddPath(dir+"//sub/..", trackFile); !errors.Is(err, ErrAlreadyTracked) {
+		t.Fatalf("Expected ErrAlreadyTracked, got %v", err)
+	}
+
+	content, err := os.ReadFile(trackFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != dir+"\n" {
+		t.Errorf("Expected the clean path once, got %q", content)
+	}
+
+	// untracking accepts any form as well
+	if err := RemovePath(dir+"/", trackFile); err != nil {
+		t.Errorf("Expected the path to be untracked, got %v", err)
+	}
+}
+
+func TestAddPath_Overlap(t *testing.T) {
+	trackFile := filepath.Join(t.TempDir(), "track")
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trackFile, []byte(sub+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent of a tracked path is tracked, with a warning
+	warnings, err := AddPath(dir, trackFile)
+	if err != nil {
+		t.Fatalf("AddPath returned an error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], sub) {
+		t.Errorf("Expected a warning about %s, got %v", sub, warnings)
+	}
+
+	// a path inside a tracked path is refused
+	if err := os.WriteFile(trackFile, []byte(dir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{sub, filepath.Join(dir, "*.conf")} {
+		if _, err := AddPath(path, trackFile); err == nil {
+			t.Errorf("Expected %s to be refused, it is inside %s", path, dir)
+		}
+	}
+
+	// untracking a path inside a tracked path tells which entry tracks it
+	err = RemovePath(sub, trackFile)
+	if !errors.Is(err, ErrNotTracked) || !strings.Contains(err.Error(), dir) {
+		t.Errorf("Expected ErrNotTracked naming %s, got %v", dir, err)
 	}
 }
@@ -0,0 +1,175 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"magma/internal/hashing"
+	"magma/internal/parsing"
+	"slices"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// errWatchLimit is returned by the notifier when the kernel refuses new watches, e.g. when
+// fs.inotify.max_user_watches is exhausted
+var errWatchLimit = errors.New("inotify watch limit reached")
+
+// errUnsupported is returned by the notifier on platforms without inotify
+var errUnsupported = errors.New("file system notifications are not supported on this platform")
+
+// Options configures a watch
+type Options struct {
+	Entries        []parsing.TrackEntry // The entries of the track file to watch
+	Debounce       time.Duration        // How long the paths must stay quiet before a burst of changes is reported
+	RescanInterval time.Duration        // How often the tracked paths are rescanned when notifications are unavailable
+	OnChange       func(paths []string) // Called with the changed paths, sorted, once a burst settles
+}
+
+// Run watches the tracked paths until the context is cancelled. Changes are collected until no
+// new change happened for the debounce delay, then reported to OnChange in a single call.
+//
+// Paths matching the ignore list are not watched, for a pattern the directory it starts with is
+// watched and the changes of the paths it does not match are dropped. If the kernel runs out of watches, Run falls
+// back to hashing the tracked paths every RescanInterval and reports the paths that changed.
+//
+// Parameters:
+//   - ctx: cancelling the context stops the watch.
+//   - opts: the paths to watch and how to report changes.
+//
+// Returns:
+//   - error: an error if the watch could not be started, nil once the context is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	roots := watchRoots(opts.Entries)
+	onChange := func(paths []string) {
+		paths = slices.DeleteFunc(paths, func(path string) bool {
+			return !tracked(opts.Entries, roots, path)
+		})
+		if len(paths) > 0 && opts.OnChange != nil {
+			opts.OnChange(paths)
+		}
+	}
+
+	changes := make(chan string, 256)
+	done := make(chan struct{})
+	go func() {
+		debounce(ctx, changes, opts.Debounce, onChange)
+		close(done)
+	}()
+	defer func() { <-done }()
+
+	err := notify(ctx, roots, changes)
+	if errors.Is(err, errWatchLimit) || errors.Is(err, errUnsupported) {
+		log.Printf("%v, falling back to a rescan every %s", err, opts.RescanInterval)
+		err = poll(ctx, opts.Entries, opts.RescanInterval, changes)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// watchRoots returns the paths to watch: the path of each entry, or the directory a pattern
+// starts with, since the paths it matches may not exist yet
+func watchRoots(entries []parsing.TrackEntry) []string {
+	var roots []string
+	for _, entry := range entries {
+		root := entry.Path
+		if entry.IsPattern() {
+			root, _ = doublestar.SplitPattern(entry.Path)
+		}
+		if !slices.Contains(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// tracked reports whether a changed path belongs to an entry. The directory a pattern starts with
+// stands for all its matches, it is reported when events were lost.
+func tracked(entries []parsing.TrackEntry, roots []string, path string) bool {
+	if slices.Contains(roots, path) {
+		return true
+	}
+	return slices.ContainsFunc(entries, func(entry parsing.TrackEntry) bool {
+		return hashing.Tracks(entry, path)
+	})
+}
+
+// debounce collects changed paths and reports them once no new path arrived for the delay
+func debounce(ctx context.Context, changes <-chan string, delay time.Duration, onChange func([]string)) {
+	pending := map[string]bool{}
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case path := <-changes:
+			pending[path] = true
+			timer.Reset(delay)
+
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			slices.Sort(paths)
+			pending = map[string]bool{}
+			if len(paths) > 0 && onChange != nil {
+				onChange(paths)
+			}
+		}
+	}
+}
+
+// poll hashes the tracked paths every interval and sends the paths that differ from the previous scan
+func poll(ctx context.Context, entries []parsing.TrackEntry, interval time.Duration, changes chan<- string) error {
+	// rescans only compare hashes, there is no need to store content
+	entries = slices.Clone(entries)
+	for i := range entries {
+		entries[i].StoreContent = false
+	}
+
+	previous, err := hashing.BuildSnapshot(entries)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := hashing.BuildSnapshot(entries)
+		if err != nil {
+			log.Println("Error rescanning tracked paths:", err)
+			continue
+		}
+
+		for _, change := range hashing.Diff(previous.Node, current.Node) {
+			if !send(ctx, changes, change.Path) {
+				return nil
+			}
+		}
+		previous = current
+	}
+}
+
+// send delivers a changed path unless the context is cancelled first
+func send(ctx context.Context, changes chan<- string, path string) bool {
+	select {
+	case changes <- path:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,211 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"log"
+	"magma/internal/hashing"
+	"magma/internal/ignore"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// the events that can change the hash or the metadata of a tracked path
+const watchMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF | syscall.IN_DONT_FOLLOW
+
+// watcher holds the inotify instance and the directory each watch descriptor stands for
+type watcher struct {
+	fd     int
+	dirs   map[int]string
+	roots  []string
+	scopes *hashing.IgnoreScopes // The ignore rules of the watched directories, read once
+}
+
+// notify watches every directory under the tracked paths with inotify and sends the changed
+// paths until the context is cancelled. Tracked files are watched through their parent directory
+// so that editors replacing a file by renaming a new one over it are still seen.
+func notify(ctx context.Context, trackPaths []string, changes chan<- string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		if err == syscall.EMFILE {
+			return errWatchLimit
+		}
+		return err
+	}
+
+	// a non-blocking descriptor is handled by the runtime poller, closing it unblocks Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+
+	w := &watcher{fd: fd, dirs: map[int]string{}, scopes: hashing.NewIgnoreScopes()}
+	for _, path := range trackPaths {
+		w.roots = append(w.roots, filepath.Clean(path))
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			log.Printf("Not watching %s: %v", path, err)
+			continue
+		}
+		if !info.IsDir() {
+			if err := w.add(filepath.Dir(path)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.addTree(ctx, path, nil); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := w.handle(ctx, buf[:n], changes); err != nil {
+			return err
+		}
+	}
+}
+
+// handle decodes a buffer of inotify events and sends the tracked paths they affect
+func (w *watcher) handle(ctx context.Context, buf []byte, changes chan<- string) error {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		wd := int(int32(binary.NativeEndian.Uint32(buf[offset:])))
+		mask := binary.NativeEndian.Uint32(buf[offset+4:])
+		length := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+		name := string(bytes.TrimRight(buf[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+length], "\x00"))
+		offset += syscall.SizeofInotifyEvent + length
+
+		// events were dropped, every tracked path may have changed
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			log.Println("inotify queue overflowed, some events were lost")
+			for _, root := range w.roots {
+				if !send(ctx, changes, root) {
+					return nil
+				}
+			}
+			continue
+		}
+
+		dir, ok := w.dirs[wd]
+		if !ok {
+			continue
+		}
+		if mask&syscall.IN_IGNORED != 0 {
+			delete(w.dirs, wd)
+			continue
+		}
+
+		path := dir
+		if name != "" {
+			path = filepath.Join(dir, name)
+		}
+
+		// the rules of a directory are read again once its .magmaignore file changed, or once
+		// the directory itself was replaced
+		if name == ignore.FileName {
+			w.scopes.Invalidate(dir)
+		}
+		if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO) != 0 {
+			w.scopes.Invalidate(path)
+		}
+
+		if _, ok := w.rootOf(path); !ok || w.ignored(path, mask&syscall.IN_ISDIR != 0) {
+			continue
+		}
+		if !send(ctx, changes, path) {
+			return nil
+		}
+
+		// new directories are watched as well, and what was created in them before the watch
+		// was in place is reported
+		if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			if err := w.addTree(ctx, path, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addTree watches a directory and every directory under it that is not ignored. If changes is
+// not nil, every path found is sent to it.
+func (w *watcher) addTree(ctx context.Context, root string, changes chan<- string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Not watching %s: %v", path, err)
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if w.ignored(path, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if changes != nil && path != root && !send(ctx, changes, path) {
+			return filepath.SkipAll
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		return w.add(path)
+	})
+}
+
+// ignored reports whether a path is skipped by the ignore rules, the .magmaignore files that
+// cannot be honoured are logged once
+func (w *watcher) ignored(path string, isDir bool) bool {
+	root, _ := w.rootOf(path)
+	ignored := w.scopes.IsIgnored(root, path, isDir)
+	for _, warning := range w.scopes.Warnings() {
+		log.Println("Warning:", warning)
+	}
+	return ignored
+}
+
+// add watches a single directory
+func (w *watcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		return errWatchLimit
+	}
+	if err != nil {
+		// the directory may have been removed since it was seen
+		log.Printf("Not watching %s: %v", dir, err)
+		return nil
+	}
+	w.dirs[wd] = dir
+	return nil
+}
+
+// rootOf returns the deepest watched path holding a path, false if none does
+func (w *watcher) rootOf(path string) (string, bool) {
+	found, ok := "", false
+	for _, root := range w.roots {
+		if (path == root || strings.HasPrefix(path, root+"/") || root == "/") && len(root) >= len(found) {
+			found, ok = root, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watch
+
+import "context"
+
+// notify is not available without inotify, Run falls back to periodic rescans
+func notify(ctx context.Context, trackPaths []string, changes chan<- string) error {
+	return errUnsupported
+}
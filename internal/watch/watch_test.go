@@ -0,0 +1,178 @@
+package watch
+
+import (
+	"context"
+	"magma/internal/parsing"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// collect returns an OnChange callback sending every reported batch to a channel
+func collect() (func([]string), chan []string) {
+	batches := make(chan []string, 16)
+	return func(paths []string) { batches <- paths }, batches
+}
+
+// waitFor waits until a reported batch contains the path
+func waitFor(t *testing.T, batches chan []string, path string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case batch := <-batches:
+			if slices.Contains(batch, path) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a change of %s", path)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	onChange, batches := collect()
+	changes := make(chan string)
+	go debounce(ctx, changes, 50*time.Millisecond, onChange)
+
+	// a burst is reported once, without duplicates
+	for _, path := range []string{"/b", "/a", "/b"} {
+		changes <- path
+	}
+
+	select {
+	case batch := <-batches:
+		if !slices.Equal(batch, []string{"/a", "/b"}) {
+			t.Errorf("Expected [/a /b], got %v", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the burst to be reported")
+	}
+
+	select {
+	case batch := <-batches:
+		t.Errorf("Expected a single batch, got another one: %v", batch)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	onChange, batches := collect()
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, Options{Entries: parsing.Entries(root), Debounce: 50 * time.Millisecond, RescanInterval: 100 * time.Millisecond, OnChange: onChange})
+	}()
+
+	// give the watches time to be set up
+	time.Sleep(200 * time.Millisecond)
+
+	file := filepath.Join(root, "a.conf")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, batches, file)
+
+	// files in new subdirectories are seen as well
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, batches, sub)
+
+	nested := filepath.Join(sub, "b.conf")
+	if err := os.WriteFile(nested, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, batches, nested)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned an error: %v", err)
+	}
+}
+
+func TestRun_Pattern(t *testing.T) {
+	root := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	onChange, batches := collect()
+	done := make(chan error)
+	go func() {
+		entries := parsing.Entries(filepath.Join(root, "*", "a.conf"))
+		done <- Run(ctx, Options{Entries: entries, Debounce: 50 * time.Millisecond, RescanInterval: 100 * time.Millisecond, OnChange: onChange})
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	// a file created after the watch started is picked up, files the pattern does not match are not reported
+	other := filepath.Join(root, "other.conf")
+	if err := os.WriteFile(other, []byte("o"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	file := filepath.Join(root, "app", "a.conf")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for seen := false; !seen; {
+		select {
+		case batch := <-batches:
+			if slices.Contains(batch, other) || slices.Contains(batch, filepath.Join(root, "app")) {
+				t.Errorf("Expected only the matching paths to be reported, got %v", batch)
+			}
+			seen = slices.Contains(batch, file)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a change of %s", file)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned an error: %v", err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.conf")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 16)
+	go poll(ctx, parsing.Entries(root), 50*time.Millisecond, changes)
+
+	// let the first scan complete before changing the file
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(file, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case path := <-changes:
+			if path == file {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the rescan to report %s", file)
+		}
+	}
+}
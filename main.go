@@ -1,22 +1,72 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"magma/internal/api"
+	"magma/internal/collector"
 	"magma/internal/config"
+	"magma/internal/daemon"
+	"magma/internal/doctor"
+	"magma/internal/drift"
+	"magma/internal/fleet"
+	"magma/internal/fsck"
 	"magma/internal/hashing"
+	"magma/internal/ignore"
 	"magma/internal/initialize"
+	"magma/internal/metrics"
+	"magma/internal/notify"
 	"magma/internal/parsing"
+	"magma/internal/policy"
+	"magma/internal/prune"
+	"magma/internal/push"
+	"magma/internal/schedule"
+	"magma/internal/tag"
 	"magma/internal/track"
+	"magma/internal/watch"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 // main is the entry point of the magma-agent application. It displays an ASCII art banner,
 // checks for at least one positional argument (command), and executes the corresponding
 // command. Supported commands are:
-// - "snap [tag1] [tag2] ...": Creates a new cryptographic snapshot for all tracked files and directories.
-// - "track [path]": Adds a new path to the track file.
-// - "untrack [path]": Removes a path from the track file.
+// - "snap [--one-file-system] [tag1] [tag2] ...": Creates a new cryptographic snapshot for all tracked files and directories.
+// - "track [path...]": Adds new paths, or glob patterns expanded at snapshot time, to the track file.
+// - "track list": Lists the tracked paths, whether they exist and the files they hold.
+// - "track import <file>" / "track export <file>": Adds the entries of a file, or writes the entries to a file.
+// - "track --edit": Edits the track file with $EDITOR and validates it before saving.
+// - "untrack [path...]": Removes paths from the track file.
+// - "ignore add|remove <pattern...>", "ignore list": Manages the patterns of the ignore file.
+// - "ignore check <path...>": Tells which rule, file and line, makes snapshots skip a path.
+// - "ignore preview": Counts the files under the tracked paths each rule excludes.
 // - "init": Initializes the magma directory.
+// - "fsck [--repair]": Validates the magma directory and its snapshots.
+// - "doctor": Checks that the environment magma runs in is healthy.
+// - "prune [--dry-run]": Removes the snapshots not kept by the retention policy.
+// - "list": Lists the snapshots with their tags and notes.
+// - "tag <snapshot> <tag> [--move]": Tags an existing snapshot.
+// - "untag <snapshot> <tag>": Removes a tag from a snapshot.
+// - "note <snapshot> <message>": Annotates a snapshot.
+// - "status": Shows the drift of the tracked paths since the baseline.
+// - "verify [--fail-on severity]": Like status, but exits with a non-zero status when there is drift.
+// - "baseline [snapshot]": Shows or designates the approved baseline.
+// - "accept --reason <text> [--by <name>] [path...]": Promotes reviewed changes into a new baseline.
+// - "daemon [--once] [--no-api]": Takes snapshots on the schedule of config.yaml and serves the local API.
+// - "install-service [--timer]": Writes the systemd units running the scheduled snapshots.
+// - "push": Pushes the snapshots not pushed yet to the collector.
+// - "server [--listen addr] [--dir path] [--token token | --insecure]": Runs a collector storing the snapshots pushed by devices.
+// - "compare-devices [--golden device] [--ref ref] [snapshot...]": Reports the files on which devices deviate.
+// - "watch [--snap] [--debounce d] [--rescan d] [tag...]": Reports, and optionally snapshots, changes as they happen.
 // If an unknown command is provided, it prints an error message.
 func main() {
 
@@ -36,10 +86,35 @@ func main() {
 
 		// print the help message
 		fmt.Println("Usage:")
-		fmt.Println("  magma snap [tag1] [tag2] ...")
-		fmt.Println("  magma track [path]")
-		fmt.Println("  magma untrack [path]")
+		fmt.Println("  magma snap [--one-file-system] [tag1] [tag2] ...")
+		fmt.Println("  magma track [path...]")
+		fmt.Println("  magma track list")
+		fmt.Println("  magma track import <file>")
+		fmt.Println("  magma track export <file>")
+		fmt.Println("  magma track --edit")
+		fmt.Println("  magma untrack [path...]")
+		fmt.Println("  magma ignore add|remove <pattern...>")
+		fmt.Println("  magma ignore list")
+		fmt.Println("  magma ignore check <path...>")
+		fmt.Println("  magma ignore preview")
 		fmt.Println("  magma init")
+		fmt.Println("  magma fsck [--repair]")
+		fmt.Println("  magma doctor")
+		fmt.Println("  magma prune [--dry-run] [--keep-last N] [--keep-daily N] [--keep-weekly N] [--keep-monthly N]")
+		fmt.Println("  magma list")
+		fmt.Println("  magma tag <snapshot> <tag> [--move]")
+		fmt.Println("  magma untag <snapshot> <tag>")
+		fmt.Println("  magma note <snapshot> <message>")
+		fmt.Println("  magma status")
+		fmt.Println("  magma verify [--fail-on info|warning|critical]")
+		fmt.Println("  magma baseline [snapshot]")
+		fmt.Println("  magma accept --reason <text> [--by <name>] [path...]")
+		fmt.Println("  magma daemon [--once] [--no-api]")
+		fmt.Println("  magma install-service [--timer] [--dir /etc/systemd/system]")
+		fmt.Println("  magma push")
+		fmt.Println("  magma server [--listen :7080] [--dir /var/lib/magma-collector] [--token token | --insecure]")
+		fmt.Println("  magma compare-devices [--dir path] [--ref ref] [--golden device] [device=snapshot.json...]")
+		fmt.Println("  magma watch [--snap] [--debounce 2s] [--rescan 5m] [tag...]")
 
 		// print the version
 		fmt.Println("Version:", config.Version)
@@ -56,22 +131,42 @@ func main() {
 	case command == "snap":
 
 		// Get the paths to track
-		trackPaths, err := parsing.ReadMagmaFile(config.TrackFile)
+		entries, err := parsing.ReadTrackFile(config.TrackFile)
 		if err != nil {
 			fmt.Println("Error reading magma file:", err)
 			return
 		}
 
-		if len(trackPaths) == 0 {
+		if len(entries) == 0 {
 			fmt.Println("No paths to track")
 			return
 		}
 
-		// get all the optional tags for the snapshot
-		tags := os.Args[2:]
+		// get all the optional tags for the snapshot, --one-file-system applies to every entry
+		var tags []string
+		for _, arg := range os.Args[2:] {
+			if arg == "--one-file-system" {
+				for i := range entries {
+					entries[i].OneFileSystem = true
+				}
+				continue
+			}
+			tags = append(tags, arg)
+		}
+		for _, t := range tags {
+			if err := tag.Validate(t); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		}
 
 		// Create a snapshot
-		err = hashing.SnapShot(config.SnapshotsDir, trackPaths, tags...)
+		start := time.Now()
+		snapshot, err := hashing.SnapShot(config.SnapshotsDir, entries, tags...)
+		writeTextfiles(func(registry *metrics.Registry) {
+			registry.ObserveSnapshot(snapshot, time.Since(start), err)
+		})
+
 		if err != nil {
 			fmt.Println("Error creating snapshot:", err)
 			return
@@ -79,23 +174,105 @@ func main() {
 
 	case command == "track":
 
-		// Ensure at least one positional argument (path) is provided
+		// Ensure at least one positional argument (path or subcommand) is provided
 		if len(os.Args) < 3 {
-			fmt.Println("please provide a path to track")
+			fmt.Println("please provide a path to track, or list, import, export or --edit")
 			return
 		}
 
-		// Get the path to track
-		path := os.Args[2]
+		switch os.Args[2] {
+		case "list":
+			statuses, warnings, err := track.List(config.TrackFile)
+			for _, warning := range warnings {
+				fmt.Println("Warning:", warning)
+			}
+			if err != nil {
+				fmt.Println("Error listing tracked paths:", err)
+				return
+			}
+			if len(statuses) == 0 {
+				fmt.Println("No paths are tracked")
+				return
+			}
 
-		// Track the path
-		err := track.AddPath(path, config.TrackFile)
-		if err != nil {
-			fmt.Println("Error tracking path:", err)
-			return
-		}
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, status := range statuses {
+				state := "exists"
+				switch {
+				case status.Entry.IsPattern():
+					state = fmt.Sprintf("%d match(es)", status.Matches)
+				case !status.Exists:
+					state = "missing"
+				}
+				fmt.Fprintf(writer, "%s\t%s\t%d file(s)\t%d bytes\n", status.Entry, state, status.Files, status.Size)
+			}
+			writer.Flush()
+
+		case "import":
+			if len(os.Args) < 4 {
+				fmt.Println("please provide the file to import")
+				return
+			}
+			added, warnings, err := track.Import(os.Args[3], config.TrackFile)
+			for _, warning := range warnings {
+				fmt.Println("Warning:", warning)
+			}
+			if err != nil {
+				fmt.Println("Error importing tracked paths:", err)
+			}
+			fmt.Printf("%d path(s) imported\n", added)
 
-		fmt.Println("Path tracked")
+		case "export":
+			if len(os.Args) < 4 {
+				fmt.Println("please provide the file to export to")
+				return
+			}
+			exported, err := track.Export(os.Args[3], config.TrackFile)
+			if err != nil {
+				fmt.Println("Error exporting tracked paths:", err)
+				return
+			}
+			fmt.Printf("%d path(s) exported to %s\n", exported, os.Args[3])
+
+		case "--edit":
+			editor := os.Getenv("EDITOR")
+			if editor == "" {
+				editor = "vi"
+			}
+			warnings, err := track.Edit(config.TrackFile, editor, func(err error) bool {
+				fmt.Println("The track file is not valid:", err)
+				fmt.Print("Edit again? [Y/n] ")
+				var answer string
+				fmt.Scanln(&answer)
+				return !strings.HasPrefix(strings.ToLower(answer), "n")
+			})
+			if err != nil {
+				fmt.Println("Error editing the track file:", err)
+				return
+			}
+			for _, warning := range warnings {
+				fmt.Println("Warning:", warning)
+			}
+			fmt.Println("Track file updated")
+
+		default:
+			// Track every path given, one failing does not prevent the others
+			for _, path := range os.Args[2:] {
+				warnings, err := track.AddPath(path, config.TrackFile)
+				if errors.Is(err, track.ErrAlreadyTracked) {
+					fmt.Println("Path already exists in the track file:", path)
+					continue
+				}
+				if err != nil {
+					fmt.Println("Error tracking path:", err)
+					continue
+				}
+				for _, warning := range warnings {
+					fmt.Println("Warning:", warning)
+				}
+				fmt.Println("Path tracked:", path)
+			}
+		}
 
 	case command == "untrack":
 
@@ -105,17 +282,104 @@ func main() {
 			return
 		}
 
-		// Get the path to untrack
-		path := os.Args[2]
+		// Untrack every path given, one failing does not prevent the others
+		for _, path := range os.Args[2:] {
+			err := track.RemovePath(path, config.TrackFile)
+			if err != nil {
+				fmt.Println("Error untracking path:", err)
+				continue
+			}
+			fmt.Println("Path untracked:", path)
+		}
+
+	case command == "ignore":
 
-		// Untrack the path
-		err := track.RemovePath(path, config.TrackFile)
-		if err != nil {
-			fmt.Println("Error untracking path:", err)
+		// Ensure a subcommand is provided
+		if len(os.Args) < 3 {
+			fmt.Println("please provide add, remove, list, check or preview")
 			return
 		}
 
-		fmt.Println("Path untracked")
+		switch subcommand, args := os.Args[2], os.Args[3:]; subcommand {
+		case "add":
+			for _, pattern := range args {
+				if err := ignore.AddPattern(config.IgnoreFile, pattern); err != nil {
+					fmt.Println("Error adding pattern:", err)
+					continue
+				}
+				fmt.Println("Pattern added:", pattern)
+			}
+
+		case "remove":
+			for _, pattern := range args {
+				if err := ignore.RemovePattern(config.IgnoreFile, pattern); err != nil {
+					fmt.Println("Error removing pattern:", err)
+					continue
+				}
+				fmt.Println("Pattern removed:", pattern)
+			}
+
+		case "list":
+			rules, err := ignore.ReadFile(config.IgnoreFile)
+			if err != nil {
+				fmt.Println("Warning:", err)
+			}
+			for _, rule := range rules.Rules() {
+				fmt.Println(rule)
+			}
+
+		case "check":
+			entries, err := parsing.ReadTrackFile(config.TrackFile)
+			if err != nil {
+				fmt.Println("Error reading track file:", err)
+				return
+			}
+			for _, arg := range args {
+				path, err := filepath.Abs(arg)
+				if err != nil {
+					fmt.Println("Error checking path:", err)
+					continue
+				}
+				// a missing path is a directory when written with a trailing slash
+				isDir := strings.HasSuffix(arg, "/")
+				if info, err := os.Lstat(path); err == nil {
+					isDir = info.IsDir()
+				}
+
+				rule, ignored, err := hashing.ExplainIgnored(path, isDir, entries)
+				switch {
+				case err != nil:
+					fmt.Println("Error checking path:", err)
+				case ignored:
+					fmt.Printf("%s: ignored by %s\n", path, rule)
+				case rule != nil:
+					fmt.Printf("%s: not ignored, re-included by %s\n", path, rule)
+				default:
+					fmt.Printf("%s: not ignored, no rule matches\n", path)
+				}
+			}
+
+		case "preview":
+			entries, err := parsing.ReadTrackFile(config.TrackFile)
+			if err != nil {
+				fmt.Println("Error reading track file:", err)
+				return
+			}
+			exclusions, err := hashing.PreviewIgnore(entries)
+			if err != nil {
+				fmt.Println("Error previewing ignore rules:", err)
+				return
+			}
+
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+			for _, exclusion := range exclusions {
+				fmt.Fprintf(writer, "%d file(s)\t  %s\n", exclusion.Files, exclusion.Rule)
+			}
+			writer.Flush()
+
+		default:
+			fmt.Println("Unknown ignore command:", subcommand)
+		}
 
 	case command == "init":
 		// Initialize the magma directory
@@ -124,7 +388,660 @@ func main() {
 			fmt.Println("Error initializing magma:", err)
 			return
 		}
+
+	case command == "fsck":
+		// validate the magma directory, optionally repairing what can be repaired
+		opts := fsck.DefaultOptions()
+		for _, arg := range os.Args[2:] {
+			if arg == "--repair" {
+				opts.Repair = true
+			}
+		}
+
+		problems, err := fsck.Run(opts)
+		if err != nil {
+			fmt.Println("Error checking magma directory:", err)
+			os.Exit(1)
+		}
+
+		unrepaired := 0
+		for _, problem := range problems {
+			fmt.Println(problem)
+			if !problem.Repaired {
+				unrepaired++
+			}
+		}
+
+		if len(problems) == 0 {
+			fmt.Println("No problems found")
+		}
+		if unrepaired > 0 {
+			fmt.Printf("%d problem(s) left unrepaired\n", unrepaired)
+			os.Exit(1)
+		}
+
+	case command == "doctor":
+		// check the environment and print a fix for everything that is not right
+		failed := false
+		for _, result := range doctor.Run(doctor.DefaultOptions()) {
+			fmt.Printf("[%-4s] %-14s %s\n", result.Status, result.Name, result.Message)
+			if result.Fix != "" {
+				fmt.Println("       fix:", result.Fix)
+			}
+			if result.Status == doctor.Fail {
+				failed = true
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+
+	case command == "prune":
+		// the retention rules come from the config file and can be overridden on the command line
+		policy := config.VariableConfig.Retention
+		flags := flag.NewFlagSet("prune", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only print what would be removed")
+		flags.IntVar(&policy.KeepLast, "keep-last", policy.KeepLast, "keep the N most recent snapshots")
+		flags.IntVar(&policy.KeepDaily, "keep-daily", policy.KeepDaily, "keep the newest snapshot of each of the last N days")
+		flags.IntVar(&policy.KeepWeekly, "keep-weekly", policy.KeepWeekly, "keep the newest snapshot of each of the last N weeks")
+		flags.IntVar(&policy.KeepMonthly, "keep-monthly", policy.KeepMonthly, "keep the newest snapshot of each of the last N months")
+		flags.Parse(os.Args[2:])
+
+		snapshots, err := hashing.ListSnapshots(config.SnapshotsDir)
+		if err != nil {
+			fmt.Println("Error reading snapshots:", err)
+			return
+		}
+
+		decisions, err := prune.Plan(snapshots, policy)
+		if err != nil {
+			fmt.Println("Error pruning snapshots:", err)
+			return
+		}
+
+		for _, decision := range decisions {
+			if decision.Keep {
+				fmt.Printf("keep   %s %v\n", decision.Snapshot.ID, decision.Reasons)
+			} else {
+				fmt.Printf("remove %s\n", decision.Snapshot.ID)
+			}
+		}
+
+		if *dryRun {
+			fmt.Println("Dry run, no snapshot removed")
+			return
+		}
+
+		removed, err := prune.Apply(decisions)
+		if err != nil {
+			fmt.Println("Error removing snapshots:", err)
+			return
+		}
+		fmt.Printf("%d snapshot(s) removed\n", removed)
+
+		objectsRemoved, freed, err := prune.CollectObjects(config.ObjectsDir, decisions)
+		if err != nil {
+			fmt.Println("Error removing stored content:", err)
+			return
+		}
+		if objectsRemoved > 0 {
+			fmt.Printf("%d stored file(s) removed, %d bytes freed\n", objectsRemoved, freed)
+		}
+
+	case command == "list":
+		snapshots, err := hashing.ListSnapshots(config.SnapshotsDir)
+		if err != nil {
+			fmt.Println("Error reading snapshots:", err)
+			return
+		}
+
+		for _, snapshot := range snapshots {
+			fmt.Printf("%s  %s  %s\n", snapshot.ID, snapshot.Created.Local().Format("2006-01-02 15:04:05"), strings.Join(snapshot.Tags, ","))
+			for _, note := range snapshot.Notes {
+				fmt.Printf("    %s %s: %s\n", note.Time.Local().Format("2006-01-02 15:04"), note.Author, note.Message)
+			}
+		}
+
+	case command == "tag":
+		// tag <snapshot> <tag> [--move]
+		args := slices.DeleteFunc(slices.Clone(os.Args[2:]), func(arg string) bool { return arg == "--move" })
+		if len(args) != 2 {
+			fmt.Println("please provide a snapshot and a tag, e.g. magma tag 20240131T120000Z-1a2b3c4d known-good")
+			return
+		}
+
+		// unique tags always move, other tags only when asked to
+		move := len(args) != len(os.Args[2:]) || slices.Contains(config.VariableConfig.UniqueTags, args[1])
+
+		snapshot, err := tag.Add(config.SnapshotsDir, args[0], args[1], move)
+		if err != nil {
+			fmt.Println("Error tagging snapshot:", err)
+			return
+		}
+
+		fmt.Printf("Snapshot %s tagged %s\n", snapshot.ID, args[1])
+
+	case command == "untag":
+		if len(os.Args) != 4 {
+			fmt.Println("please provide a snapshot and a tag, e.g. magma untag 20240131T120000Z-1a2b3c4d known-good")
+			return
+		}
+
+		snapshot, err := tag.Remove(config.SnapshotsDir, os.Args[2], os.Args[3])
+		if err != nil {
+			fmt.Println("Error untagging snapshot:", err)
+			return
+		}
+
+		fmt.Printf("Tag %s removed from snapshot %s\n", os.Args[3], snapshot.ID)
+
+	case command == "note":
+		if len(os.Args) < 4 {
+			fmt.Println("please provide a snapshot and a message, e.g. magma note baseline \"after kernel upgrade\"")
+			return
+		}
+
+		snapshot, err := tag.Note(config.SnapshotsDir, os.Args[2], strings.Join(os.Args[3:], " "), tag.CurrentUser())
+		if err != nil {
+			fmt.Println("Error annotating snapshot:", err)
+			return
+		}
+
+		fmt.Printf("Note added to snapshot %s\n", snapshot.ID)
+
+	case command == "status" || command == "verify":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		failOn := flags.String("fail-on", "warning", "lowest severity that makes verify fail: info, warning or critical")
+		flags.Parse(os.Args[2:])
+
+		threshold, err := policy.ParseSeverity(*failOn)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		entries, err := parsing.ReadTrackFile(config.TrackFile)
+		if err != nil {
+			fmt.Println("Error reading magma file:", err)
+			os.Exit(2)
+		}
+
+		rules, err := policy.ReadPolicy(config.PolicyFile)
+		if err != nil {
+			fmt.Println("Error reading policy file:", err)
+			os.Exit(2)
+		}
+
+		// compare the tracked paths against the baseline, or the latest snapshot without one
+		report, err := drift.Check(config.SnapshotsDir, entries, rules)
+		if err != nil {
+			fmt.Println("Error checking drift:", err)
+			os.Exit(2)
+		}
+
+		printReport(report)
+		notifyDrift(command, report)
+		writeTextfiles(func(registry *metrics.Registry) {
+			registry.ObserveDrift(report.Findings)
+		})
+
+		// verify is meant for scripts, drift is reported through the exit status
+		if worst, found := report.Worst(); command == "verify" && found && worst >= threshold {
+			os.Exit(1)
+		}
+
+	case command == "baseline":
+		if len(os.Args) < 3 {
+			// show the current baseline
+			reference, isBaseline, err := drift.Reference(config.SnapshotsDir)
+			if err != nil || !isBaseline {
+				fmt.Println("No baseline designated, use 'magma baseline <snapshot>' or 'magma accept'")
+				return
+			}
+			fmt.Println("Baseline:", reference.ID)
+			if reference.Approval != nil {
+				fmt.Printf("Accepted by %s on %s: %s\n", reference.Approval.By, reference.Approval.Time.Local().Format("2006-01-02 15:04"), reference.Approval.Reason)
+			}
+			return
+		}
+
+		snapshot, err := drift.SetBaseline(config.SnapshotsDir, os.Args[2])
+		if err != nil {
+			fmt.Println("Error setting baseline:", err)
+			return
+		}
+		fmt.Println("Baseline set to", snapshot.ID)
+
+	case command == "accept":
+		flags := flag.NewFlagSet("accept", flag.ExitOnError)
+		by := flags.String("by", tag.CurrentUser(), "name of the approver")
+		reason := flags.String("reason", "", "why the changes are accepted (required)")
+		flags.Parse(os.Args[2:])
+
+		entries, err := parsing.ReadTrackFile(config.TrackFile)
+		if err != nil {
+			fmt.Println("Error reading magma file:", err)
+			return
+		}
+
+		baseline, err := drift.Accept(config.SnapshotsDir, entries, flags.Args(), *by, *reason)
+		if err != nil {
+			fmt.Println("Error accepting changes:", err)
+			return
+		}
+		fmt.Println("New baseline saved to", baseline.File)
+
+	case command == "daemon":
+		flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+		once := flags.Bool("once", false, "take a single scheduled snapshot and exit, used by the systemd timer")
+		noAPI := flags.Bool("no-api", false, "do not serve the local API")
+		flags.Parse(os.Args[2:])
+
+		scheduleConfig := config.VariableConfig.Schedule
+		opts := daemon.Options{
+			Jitter:          scheduleConfig.Jitter,
+			SkipIfUnchanged: scheduleConfig.SkipIfUnchanged,
+			Tags:            scheduleConfig.Tags,
+			UniqueTags:      config.VariableConfig.UniqueTags,
+			TrackFile:       config.TrackFile,
+			SnapshotsDir:    config.SnapshotsDir,
+			PolicyFile:      config.PolicyFile,
+			Metrics:         newMetrics(),
+			TextfileDir:     config.VariableConfig.Metrics.TextfileDir,
+		}
+		if opts.Tags == nil {
+			opts.Tags = []string{"scheduled"}
+		}
+		for _, t := range opts.Tags {
+			if err := tag.Validate(t); err != nil {
+				fmt.Println("Error in schedule.tags:", err)
+				os.Exit(1)
+			}
+		}
+
+		// with push.auto every snapshot written, scheduled or through the API, is pushed
+		pushConfig := config.VariableConfig.Push
+		var pushOpts push.Options
+		if pushConfig.Auto {
+			var err error
+			if pushOpts, err = pushOptions(); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+
+		if *once {
+			start := time.Now()
+			snapshot, taken, err := daemon.Tick(opts)
+			daemon.Observe(opts, snapshot, taken, time.Since(start), err)
+			if err != nil && !taken {
+				fmt.Println("Error creating snapshot:", err)
+				os.Exit(1)
+			}
+			if !taken {
+				fmt.Println("Tracked paths unchanged since", snapshot.ID+", snapshot skipped")
+				return
+			}
+			fmt.Println("Snapshot saved to", snapshot.File)
+			if err != nil {
+				fmt.Println("Warning:", err)
+			}
+
+			// the snapshots that fail to push are pushed by the next run
+			if pushConfig.Auto {
+				pushQueued(pushOpts)
+			}
+			return
+		}
+
+		var err error
+		opts.Schedule, err = schedule.FromConfig(scheduleConfig)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		// stop cleanly when the service manager stops the daemon
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// snapshots are pushed as they are taken, failed pushes are retried in the background
+		pushDone := make(chan struct{})
+		if pushConfig.Auto {
+			retry := pushConfig.RetryInterval
+			if retry <= 0 {
+				retry = 5 * time.Minute
+			}
+
+			trigger := make(chan struct{}, 1)
+			opts.AfterSnapshot = func(hashing.Snapshot) {
+				select {
+				case trigger <- struct{}{}:
+				default:
+				}
+			}
+			go func() {
+				push.Loop(ctx, pushOpts, retry, trigger)
+				close(pushDone)
+			}()
+		} else {
+			close(pushDone)
+		}
+
+		// the API is served next to the scheduler, the daemon stops if it cannot be served
+		apiDone := make(chan error, 1)
+		if *noAPI {
+			apiDone <- nil
+		} else {
+			server := &api.Server{
+				SnapshotsDir:  config.SnapshotsDir,
+				TrackFile:     config.TrackFile,
+				PolicyFile:    config.PolicyFile,
+				UniqueTags:    config.VariableConfig.UniqueTags,
+				Metrics:       opts.Metrics,
+				TextfileDir:   opts.TextfileDir,
+				AfterSnapshot: opts.AfterSnapshot,
+			}
+			go func() {
+				err := api.Serve(ctx, server, config.VariableConfig.API)
+				stop()
+				apiDone <- err
+			}()
+		}
+
+		err = daemon.Run(ctx, opts)
+		stop()
+		<-pushDone
+		if apiErr := <-apiDone; apiErr != nil {
+			fmt.Println("Error serving API:", apiErr)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println("Error running daemon:", err)
+			os.Exit(1)
+		}
+
+	case command == "install-service":
+		flags := flag.NewFlagSet("install-service", flag.ExitOnError)
+		timer := flags.Bool("timer", false, "install a systemd timer instead of a long running daemon")
+		dir := flags.String("dir", daemon.SystemdDir, "directory the units are written to")
+		flags.Parse(os.Args[2:])
+
+		binary, err := os.Executable()
+		if err != nil {
+			fmt.Println("Error locating the magma binary:", err)
+			return
+		}
+
+		written, enable, err := daemon.InstallService(daemon.ServiceOptions{Dir: *dir, Binary: binary, Timer: *timer, Schedule: config.VariableConfig.Schedule})
+		if err != nil {
+			fmt.Println("Error installing service:", err)
+			return
+		}
+
+		for _, path := range written {
+			fmt.Println("Unit written to", path)
+		}
+		fmt.Printf("Enable it with 'systemctl daemon-reload && systemctl enable --now %s'\n", enable)
+
+	case command == "push":
+		opts, err := pushOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		if err := pushQueued(opts); err != nil {
+			fmt.Println("Run 'magma push' again to retry")
+			os.Exit(1)
+		}
+
+	case command == "server":
+		flags := flag.NewFlagSet("server", flag.ExitOnError)
+		listen := flags.String("listen", ":7080", "address to listen on")
+		dir := flags.String("dir", "/var/lib/magma-collector", "directory the pushed snapshots are stored in")
+		token := flags.String("token", os.Getenv("MAGMA_COLLECTOR_TOKEN"), "bearer token required from the devices, defaults to $MAGMA_COLLECTOR_TOKEN")
+		insecure := flags.Bool("insecure", false, "accept pushes from any device without a token")
+		flags.Parse(os.Args[2:])
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		server := &collector.Collector{Dir: *dir, Token: *token, Insecure: *insecure}
+		if err := server.ListenAndServe(ctx, *listen); err != nil {
+			fmt.Println("Error running collector:", err)
+			os.Exit(1)
+		}
+
+	case command == "compare-devices":
+		flags := flag.NewFlagSet("compare-devices", flag.ExitOnError)
+		dir := flags.String("dir", "/var/lib/magma-collector", "collector directory holding the snapshots of the devices")
+		ref := flags.String("ref", "", "snapshot to compare on each device, e.g. baseline, the latest when empty")
+		golden := flags.String("golden", "", "device the others must match, the majority when empty")
+		flags.Parse(os.Args[2:])
+
+		// snapshot files given on the command line replace the collector
+		var devices []fleet.Device
+		var err error
+		if flags.NArg() > 0 {
+			devices, err = fleet.LoadFiles(flags.Args())
+		} else {
+			var warnings []string
+			devices, warnings, err = fleet.LoadCollector(*dir, *ref)
+			for _, warning := range warnings {
+				fmt.Println("Warning:", warning)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error reading snapshots:", err)
+			os.Exit(2)
+		}
+		if len(devices) < 2 {
+			fmt.Println("At least two devices are needed to compare them")
+			os.Exit(2)
+		}
+
+		reports, err := fleet.Compare(devices, *golden)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		basis := "the majority"
+		if *golden != "" {
+			basis = "golden device " + *golden
+		}
+		fmt.Printf("Comparing %d device(s) against %s\n", len(devices), basis)
+
+		short := func(hash string) string {
+			if hash == fleet.Absent {
+				return "absent"
+			}
+			return hash[:min(8, len(hash))]
+		}
+		for _, report := range reports {
+			if report.Tie {
+				fmt.Printf("%s: no majority among %d device(s)\n", report.Path, report.Compared)
+			} else {
+				fmt.Printf("%s: %d/%d device(s) agree on %s\n", report.Path, report.Agreeing, report.Compared, short(report.Expected))
+			}
+			for _, deviation := range report.Deviations {
+				fmt.Printf("    %-20s %s\n", deviation.Device, short(deviation.Hash))
+			}
+		}
+
+		if len(reports) == 0 {
+			fmt.Println("All devices agree")
+			return
+		}
+		fmt.Printf("%d file(s) deviate\n", len(reports))
+		os.Exit(1)
+
+	case command == "watch":
+		flags := flag.NewFlagSet("watch", flag.ExitOnError)
+		snap := flags.Bool("snap", false, "take a snapshot after each burst of changes")
+		debounce := flags.Duration("debounce", 2*time.Second, "how long changes must settle before they are reported")
+		rescan := flags.Duration("rescan", 5*time.Minute, "rescan interval used when inotify watches are exhausted")
+		flags.Parse(os.Args[2:])
+
+		// the remaining arguments tag the snapshots taken by the watch
+		tags := flags.Args()
+		for _, t := range tags {
+			if err := tag.Validate(t); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		}
+
+		entries, err := parsing.ReadTrackFile(config.TrackFile)
+		if err != nil {
+			fmt.Println("Error reading magma file:", err)
+			return
+		}
+		if len(entries) == 0 {
+			fmt.Println("No paths to track")
+			return
+		}
+
+		rules, err := policy.ReadPolicy(config.PolicyFile)
+		if err != nil {
+			fmt.Println("Error reading policy file:", err)
+			return
+		}
+
+		onChange := func(paths []string) {
+			for _, path := range paths {
+				log.Println("changed:", path)
+			}
+
+			// evaluate the changes against the policy, a missing reference is not fatal to the watch
+			report, err := drift.Check(config.SnapshotsDir, entries, rules)
+			if err == nil {
+				printReport(report)
+				notifyDrift("watch", report)
+			} else if !errors.Is(err, drift.ErrNoSnapshot) {
+				log.Println("Error checking drift:", err)
+			}
+
+			if *snap {
+				if _, err := hashing.SnapShot(config.SnapshotsDir, entries, tags...); err != nil {
+					log.Println("Error creating snapshot:", err)
+				}
+			}
+		}
+
+		// stop cleanly on Ctrl-C or when the service manager stops the watch
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		log.Printf("Watching %d tracked path(s)", len(entries))
+		err = watch.Run(ctx, watch.Options{Entries: entries, Debounce: *debounce, RescanInterval: *rescan, OnChange: onChange})
+		if err != nil {
+			fmt.Println("Error watching tracked paths:", err)
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Println("Unknown command ", os.Args[1])
 	}
 }
+
+// printReport prints the drift between the tracked paths and their reference snapshot
+func printReport(report drift.Report) {
+	if report.IsBaseline {
+		fmt.Println("Comparing against baseline", report.Reference.ID)
+	} else {
+		fmt.Println("No baseline designated, comparing against the latest snapshot", report.Reference.ID)
+	}
+
+	if len(report.Findings) == 0 {
+		fmt.Println("No drift")
+		return
+	}
+
+	for _, finding := range report.Findings {
+		rule := ""
+		if finding.Rule != nil {
+			rule = fmt.Sprintf(" [%s %s]", finding.Rule.Kind, finding.Rule.Pattern)
+		}
+		fmt.Printf("  %-8s %-9s %s: %s%s\n", finding.Severity, finding.Change.Kind, finding.Change.Path, finding.Reason, rule)
+	}
+	fmt.Printf("%d finding(s)\n", len(report.Findings))
+}
+
+// pushOptions returns the options pushing the local snapshots to the configured collector
+func pushOptions() (push.Options, error) {
+	key, err := push.LoadKey(config.SigningKey)
+	if err != nil {
+		return push.Options{}, err
+	}
+	return push.Options{
+		URL:          config.VariableConfig.Push.URL,
+		Token:        config.VariableConfig.Push.Token,
+		DeviceID:     config.VariableConfig.DeviceID,
+		Key:          key,
+		SnapshotsDir: config.SnapshotsDir,
+		StateFile:    config.PushState,
+	}, nil
+}
+
+// pushQueued pushes the snapshots the collector has not acknowledged yet and prints the outcome,
+// the snapshots that failed stay queued
+func pushQueued(opts push.Options) error {
+	pushed, err := push.Push(opts)
+	fmt.Printf("%d snapshot(s) pushed to %s\n", pushed, opts.URL)
+	if err != nil {
+		pending, _ := push.Pending(opts)
+		fmt.Println("Error pushing snapshots:", err)
+		fmt.Printf("%d snapshot(s) left to push\n", pending)
+	}
+	return err
+}
+
+// notifyDrift sends the findings of a drift report to the notification channels of the config file
+func notifyDrift(source string, report drift.Report) {
+	if len(report.Findings) == 0 {
+		return
+	}
+
+	notifier, err := notify.New(config.VariableConfig.Notify, config.NotifyState)
+	if err != nil {
+		fmt.Println("Error in notification settings:", err)
+		return
+	}
+
+	err = notifier.Notify(notify.Event{
+		Device:    config.VariableConfig.DeviceID,
+		Source:    source,
+		Reference: report.Reference.ID,
+		Time:      time.Now(),
+		Findings:  report.Findings,
+	})
+	if err != nil {
+		fmt.Println("Error sending notifications:", err)
+	}
+}
+
+// newMetrics returns a registry knowing when the latest snapshot was taken
+func newMetrics() *metrics.Registry {
+	registry := metrics.New()
+	if snapshots, err := hashing.ListSnapshots(config.SnapshotsDir); err == nil && len(snapshots) > 0 {
+		registry.SetLastSuccess(snapshots[len(snapshots)-1].Created)
+	}
+	return registry
+}
+
+// writeTextfiles records a one-shot command in the node_exporter textfiles, when configured
+func writeTextfiles(observe func(registry *metrics.Registry)) {
+	dir := config.VariableConfig.Metrics.TextfileDir
+	if dir == "" {
+		return
+	}
+
+	registry := newMetrics()
+	observe(registry)
+	if err := registry.WriteTextfiles(dir); err != nil {
+		fmt.Println("Error writing metrics:", err)
+	}
+}